@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"strings"
 
@@ -38,3 +39,11 @@ func userIdentity(name, email string) string {
 	}
 	return ""
 }
+
+// clientIP — адрес клиента (за доверенным прокси r.RemoteAddr уже поправлен realIP, см. TRUSTED_PROXIES).
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
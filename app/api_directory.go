@@ -4,36 +4,61 @@ import (
 	"net/http"
 )
 
+// полный список пользователей: ?q=<имя, email или логин>&active=true|false&source=ldap|manual
+// &sort=name|email|login|id&order=asc|desc&limit=&offset=|cursor= (см. listing.go)
 func handleUsersAll(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	users, err := ListUsersAll(r.Context())
+	q := r.URL.Query()
+	f := UserFilter{Search: q.Get("q"), Source: q.Get("source")}
+	var err error
+	if f.Active, err = parseOptionalBool(q, "active"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := parseListParams(q, userSorts)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, page, err := getStore().ListUsersAll(r.Context(), f, p)
 	if err != nil {
-		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		writeListError(w, err)
 		return
 	}
 
 	writeJSON(w, struct {
 		Users []UserFull `json:"users"`
-	}{Users: users})
+		Page
+	}{Users: users, Page: page})
 }
 
+// активные ПК: ?q=<имя, DNS-имя или описание>&sort=name|dns_host_name|id&order=&limit=&offset=|cursor=
 func handleComputers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	pcs, err := ListComputers(r.Context())
+	q := r.URL.Query()
+	p, err := parseListParams(q, computerSorts)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pcs, page, err := getStore().ListComputers(r.Context(), ComputerFilter{Search: q.Get("q")}, p)
 	if err != nil {
-		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		writeListError(w, err)
 		return
 	}
 
 	writeJSON(w, struct {
 		Computers []Computer `json:"computers"`
-	}{Computers: pcs})
+		Page
+	}{Computers: pcs, Page: page})
 }
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// ручной запуск синхронизации LDAP (в фоне; результат — через GET /api/admin/ldap/sync)
+func handleLDAPSyncStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ldapEnabled() {
+		httpError(w, "LDAP не настроен", http.StatusBadRequest)
+		return
+	}
+	if !beginLDAPSync("manual") {
+		httpError(w, ldapSyncBusyMessage(), http.StatusConflict)
+		return
+	}
+
+	logging.Infof("ldap sync requested by %q", requestActor(r))
+	auditTarget(r, "ldap_sync", "all", nil, nil)
+
+	// Синхронизация на большом каталоге дольше HTTP-таймаутов: запускаем отдельно от запроса.
+	go syncLDAPAll(context.WithoutCancel(r.Context()), "manual")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, map[string]any{"status": "started"})
+}
+
+// ldapSyncBusyMessage — причина отказа beginLDAPSync для ответа 409.
+func ldapSyncBusyMessage() string {
+	if restoreInProgress() {
+		return "идёт восстановление БД из бэкапа, синхронизация LDAP недоступна"
+	}
+	return "синхронизация LDAP уже выполняется"
+}
+
+func handleLDAPSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := getLDAPSyncStatus()
+	var err error
+	if st.LastSuccessAt, st.LastError, err = lastSyncOutcome(r.Context()); err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, st)
+}
@@ -30,13 +30,14 @@ func handleImportMeetings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cnt, err := ReplaceMeetingsSnapshot(r.Context(), strings.TrimSpace(req.ExportedAt), req.Items)
+	cnt, err := getStore().ReplaceMeetingsSnapshot(r.Context(), strings.TrimSpace(req.ExportedAt), req.Items)
 	if err != nil {
 		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	logging.Infof("import meetings: imported=%d", cnt)
+	auditTarget(r, "meeting", nil, nil, map[string]any{"imported": cnt, "exported_at": strings.TrimSpace(req.ExportedAt)})
 
 	resp := struct {
 		Status           string `json:"status"`
@@ -55,7 +56,7 @@ func handleMeetingsState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state, err := GetMeetingsState(r.Context())
+	state, err := getStore().GetMeetingsState(r.Context())
 	if err != nil {
 		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
 		return
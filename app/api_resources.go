@@ -0,0 +1,252 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// REST-ресурсы: /api/licenses/{id}, /api/licenses/{id}/assignment, /api/users/{id}, /api/computers/{id}.
+// Старые RPC-роуты (/api/assign, /api/license/update, /api/license/unassign) оставлены как совместимые алиасы
+// и работают через те же методы Store.
+
+// LicensePatch — частичное изменение лицензии (PATCH): не переданные поля не меняются.
+type LicensePatch struct {
+	Comment    *string `json:"comment"`
+	PC         *string `json:"pc"`          // "" — отвязать ПК
+	ComputerID *int    `json:"computer_id"` // если задан — pc берётся из справочника
+}
+
+type AssignmentRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// UserDetails — карточка пользователя с его лицензиями.
+type UserDetails struct {
+	UserFull
+	Licenses []License `json:"licenses"`
+}
+
+// ComputerDetails — карточка ПК с привязанными к нему лицензиями.
+type ComputerDetails struct {
+	ComputerFull
+	Licenses []License `json:"licenses"`
+}
+
+// idParam читает {id} из пути; при ошибке отвечает 400.
+func idParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		httpError(w, "некорректный id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeResourceError — ошибки Store для REST-роутов: "не найден" — 404 (в старых роутах — 400).
+func writeResourceError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "license_not_found"):
+		httpError(w, "лицензия не найдена", http.StatusNotFound)
+	case strings.Contains(msg, "user_not_found"):
+		httpError(w, "пользователь не найден", http.StatusNotFound)
+	default:
+		httpError(w, "db error: "+msg, http.StatusInternalServerError)
+	}
+}
+
+// writeLicense отвечает текущим состоянием лицензии (после изменения).
+func writeLicense(w http.ResponseWriter, r *http.Request, id int) {
+	l, err := getStore().GetLicense(r.Context(), id)
+	if err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, l)
+}
+
+// =============== /api/licenses/{id} ===============
+
+func handleLicenseGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	writeLicense(w, r, id)
+}
+
+func handleLicensePatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	auditLicense(r, id)
+
+	var req LicensePatch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cur, err := getStore().GetLicense(r.Context(), id)
+	if err != nil {
+		writeResourceError(w, err)
+		return
+	}
+
+	comment, pc, computerID := cur.Comment, cur.PC, 0
+	if req.Comment != nil {
+		comment = *req.Comment
+	}
+	if req.PC != nil {
+		pc = *req.PC
+	}
+	if req.ComputerID != nil {
+		computerID = *req.ComputerID
+	}
+
+	if err := getStore().UpdateLicense(r.Context(), requestActor(r), id, comment, pc, computerID); err != nil {
+		if strings.Contains(err.Error(), "computer_not_found") {
+			httpError(w, "ПК не найден среди активных компьютеров каталога", http.StatusBadRequest)
+			return
+		}
+		writeResourceError(w, err)
+		return
+	}
+	writeLicense(w, r, id)
+}
+
+func handleLicenseDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	auditLicense(r, id)
+
+	if err := getStore().DeleteLicense(r.Context(), requestActor(r), id); err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, map[string]any{"status": "ok"})
+}
+
+// =============== /api/licenses/{id}/assignment ===============
+
+func handleAssignmentPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	auditLicense(r, id)
+
+	var req AssignmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID <= 0 {
+		httpError(w, "user_id обязателен", http.StatusBadRequest)
+		return
+	}
+
+	if err := getStore().AssignLicense(r.Context(), requestActor(r), req.UserID, id); err != nil {
+		if strings.Contains(err.Error(), "user_not_found") {
+			// пользователь — из тела запроса, а не из пути: это ошибка запроса, а не отсутствующий ресурс
+			httpError(w, "пользователь не найден", http.StatusBadRequest)
+			return
+		}
+		writeResourceError(w, err)
+		return
+	}
+	writeLicense(w, r, id)
+}
+
+func handleAssignmentDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	auditLicense(r, id)
+
+	if err := getStore().UnassignLicense(r.Context(), requestActor(r), id); err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	writeLicense(w, r, id)
+}
+
+// =============== /api/users/{id}, /api/computers/{id} ===============
+
+func handleUserGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := getStore().GetUser(r.Context(), id)
+	if err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	licenses, _, err := getStore().ListLicenses(r.Context(), LicenseFilter{AssignedUserID: id}, ListParams{})
+	if err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, UserDetails{UserFull: u, Licenses: licenses})
+}
+
+func handleComputerGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := getStore().GetComputer(r.Context(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "computer_not_found") {
+			httpError(w, "ПК не найден", http.StatusNotFound)
+			return
+		}
+		writeResourceError(w, err)
+		return
+	}
+	licenses, _, err := getStore().ListLicenses(r.Context(), LicenseFilter{ComputerID: id}, ListParams{})
+	if err != nil {
+		writeResourceError(w, err)
+		return
+	}
+	writeJSON(w, ComputerDetails{ComputerFull: c, Licenses: licenses})
+}
@@ -0,0 +1,431 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// API-токены для скриптов и интеграций (заголовок X-API-Token вместо сессии).
+// У каждого токена — имя (оно же actor в истории и журнале аудита), набор scope и срок действия.
+// В БД хранится только SHA-256 токена: сам токен показывается один раз при создании.
+// Scope ограничивает, какие запросы к /api разрешены токену (см. apiTokenScope), а роль токена
+// для requireRole выводится из scope (см. roleForScopes).
+
+const (
+	scopeRead           = "read"            // любые GET /api
+	scopeLicensesWrite  = "licenses:write"  // импорт, назначение и изменение лицензий
+	scopeUsersWrite     = "users:write"     // ручной импорт пользователей
+	scopeMeetingsImport = "meetings:import" // импорт встреч
+	scopeAdmin          = "admin"           // всё, включая /api/admin
+)
+
+var apiTokenScopes = []string{scopeRead, scopeLicensesWrite, scopeUsersWrite, scopeMeetingsImport, scopeAdmin}
+
+// apiTokenPrefix — чтобы токен было легко узнать (например, в логах или при поиске утёкших секретов).
+const apiTokenPrefix = "onessa_"
+
+// last_used_at обновляем не чаще раза в минуту, как last_seen_at у сессий.
+const apiTokenTouchEvery = time.Minute
+
+var apiTokenNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// APIToken — токен без секрета; RevokedAt не пуст — токен отозван.
+type APIToken struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	CreatedBy  string   `json:"created_by"`
+	ExpiresAt  string   `json:"expires_at"` // "" — бессрочный
+	LastUsedAt string   `json:"last_used_at"`
+	RevokedAt  string   `json:"revoked_at"`
+}
+
+func apiTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// normalizeScopes проверяет scope и убирает повторы; "invalid_scope", если scope неизвестен или список пуст.
+func normalizeScopes(in []string) ([]string, error) {
+	var out []string
+	for _, s := range in {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(apiTokenScopes, s) {
+			return nil, fmt.Errorf("invalid_scope: %q", s)
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("invalid_scope: empty")
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// CreateAPIToken сохраняет новый токен и возвращает его вместе с секретом (больше его узнать нельзя).
+// Ошибки: "invalid_token_name", "invalid_scope", "api_token_exists" (действующий токен с таким именем уже есть).
+func CreateAPIToken(ctx context.Context, name string, scopes []string, expiresAt, createdBy string) (APIToken, string, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !apiTokenNameRe.MatchString(name) {
+		return APIToken{}, "", fmt.Errorf("invalid_token_name")
+	}
+	scopes, err = normalizeScopes(scopes)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	// имя — actor в журнале: два действующих токена с одним именем различить было бы нельзя
+	var n int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM api_tokens WHERE name=? AND revoked_at=''`, name).Scan(&n); err != nil {
+		return APIToken{}, "", err
+	}
+	if n > 0 {
+		return APIToken{}, "", fmt.Errorf("api_token_exists")
+	}
+
+	secret, err := newAPIToken()
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("api token: %w", err)
+	}
+
+	t := APIToken{
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	err = conn.QueryRowContext(ctx, `
+		INSERT INTO api_tokens(name, token_hash, scopes, created_at, created_by, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, t.Name, apiTokenHash(secret), strings.Join(t.Scopes, " "), t.CreatedAt, t.CreatedBy, t.ExpiresAt).Scan(&t.ID)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	return t, secret, nil
+}
+
+const apiTokenColumns = `id, name, scopes, created_at, created_by, expires_at, last_used_at, revoked_at`
+
+func scanAPIToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	if err := row.Scan(&t.ID, &t.Name, &scopes, &t.CreatedAt, &t.CreatedBy, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt); err != nil {
+		return APIToken{}, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	return t, nil
+}
+
+// AuthenticateAPIToken находит действующий токен по секрету и отмечает его использование.
+// "api_token_not_found" — токена нет, он отозван или истёк.
+func AuthenticateAPIToken(ctx context.Context, secret string) (APIToken, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	t, err := scanAPIToken(conn.QueryRowContext(ctx,
+		`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash=?`, apiTokenHash(secret)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIToken{}, fmt.Errorf("api_token_not_found")
+		}
+		return APIToken{}, err
+	}
+
+	now := time.Now().UTC()
+	if t.RevokedAt != "" {
+		return APIToken{}, fmt.Errorf("api_token_not_found")
+	}
+	if t.ExpiresAt != "" {
+		if exp, err := time.Parse(time.RFC3339, t.ExpiresAt); err != nil || !now.Before(exp) {
+			return APIToken{}, fmt.Errorf("api_token_not_found")
+		}
+	}
+
+	if used, err := time.Parse(time.RFC3339, t.LastUsedAt); err != nil || now.Sub(used) > apiTokenTouchEvery {
+		t.LastUsedAt = now.Format(time.RFC3339)
+		if _, err := conn.ExecContext(ctx, `UPDATE api_tokens SET last_used_at=? WHERE id=?`, t.LastUsedAt, t.ID); err != nil {
+			logging.Warnf("api token touch error: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// ListAPITokens — все токены, включая отозванные; новые первыми.
+func ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+	out := []APIToken{}
+	err = scanRows(ctx, conn, `SELECT `+apiTokenColumns+` FROM api_tokens ORDER BY id DESC`, nil, func(rows *sql.Rows) error {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return err
+		}
+		out = append(out, t)
+		return nil
+	})
+	return out, err
+}
+
+// RevokeAPIToken отзывает токен (запись остаётся для истории); "api_token_not_found" — нет такого или уже отозван.
+func RevokeAPIToken(ctx context.Context, id int) (APIToken, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return APIToken{}, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := conn.ExecContext(ctx, `UPDATE api_tokens SET revoked_at=? WHERE id=? AND revoked_at=''`, now, id)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if a, _ := res.RowsAffected(); a == 0 {
+		return APIToken{}, fmt.Errorf("api_token_not_found")
+	}
+	return scanAPIToken(conn.QueryRowContext(ctx, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE id=?`, id))
+}
+
+// hasAPITokens — заведён ли хотя бы один именной токен (в том числе отозванный или истёкший).
+func hasAPITokens(ctx context.Context) (bool, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return false, err
+	}
+	n := 0
+	err = conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM (SELECT 1 FROM api_tokens LIMIT 1) t`).Scan(&n)
+	return n > 0, err
+}
+
+// =============== авторизация ===============
+
+// roleForScopes — роль токена для requireRole: admin — admin, любой scope на запись — operator, иначе viewer.
+func roleForScopes(scopes []string) Role {
+	switch {
+	case slices.Contains(scopes, scopeAdmin):
+		return RoleAdmin
+	case slices.ContainsFunc(scopes, func(s string) bool { return s != scopeRead }):
+		return RoleOperator
+	default:
+		return RoleViewer
+	}
+}
+
+// apiTokenScope — scope, который нужен токену для запроса.
+func apiTokenScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return scopeRead
+	}
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/admin/"):
+		return scopeAdmin
+	case path == "/api/meetings/import":
+		return scopeMeetingsImport
+	case path == "/api/users/import":
+		return scopeUsersWrite
+	case strings.HasPrefix(path, "/api/licenses") || strings.HasPrefix(path, "/api/license/") || path == "/api/assign":
+		return scopeLicensesWrite
+	default:
+		// новый изменяющий маршрут без своего scope — только полному токену
+		return scopeAdmin
+	}
+}
+
+// legacyAPITokenScopes — права токена из WRITE_API_TOKEN: как раньше, любые изменения, кроме администрирования.
+var legacyAPITokenScopes = []string{scopeLicensesWrite, scopeMeetingsImport, scopeUsersWrite}
+
+// apiTokenPrincipal находит, под кем выполняется запрос с X-API-Token.
+// WRITE_API_TOKEN (устаревший общий токен) принимается только на изменяющие запросы и только пока
+// не заведено ни одного именного токена; каждое использование пишется в лог как предупреждение.
+func apiTokenPrincipal(r *http.Request, secret string) (Principal, bool) {
+	if legacy := strings.TrimSpace(getConfig().WriteAPIToken); legacy != "" &&
+		r.Method != http.MethodGet && r.Method != http.MethodHead &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(legacy)) == 1 {
+		named, err := hasAPITokens(r.Context())
+		if err != nil {
+			logging.Errorf("api token check error: %v", err)
+			return Principal{}, false
+		}
+		if named {
+			logging.Warnf("WRITE_API_TOKEN rejected for %s %s: named API tokens exist, use them and remove WRITE_API_TOKEN", r.Method, r.URL.Path)
+			return Principal{}, false
+		}
+		logging.Warnf("WRITE_API_TOKEN is deprecated (used for %s %s): create a named token in /api/admin/tokens", r.Method, r.URL.Path)
+		return Principal{Username: "api-token", Role: RoleOperator, Scopes: legacyAPITokenScopes}, true
+	}
+
+	t, err := AuthenticateAPIToken(r.Context(), secret)
+	if err != nil {
+		if !strings.Contains(err.Error(), "api_token_not_found") {
+			logging.Errorf("api token check error: %v", err)
+		}
+		return Principal{}, false
+	}
+	return Principal{Username: "token:" + t.Name, Role: roleForScopes(t.Scopes), Scopes: t.Scopes}, true
+}
+
+// requireTokenScope — middleware для роутов /api: запросы по API-токену пропускаются только в пределах его scope.
+func requireTokenScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := principalFrom(r.Context())
+		if !ok || p.Scopes == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		need := apiTokenScope(r)
+		if !slices.Contains(p.Scopes, need) && !slices.Contains(p.Scopes, scopeAdmin) {
+			logging.Warnf("requireTokenScope: %q denied %s %s, need scope %s", p.Username, r.Method, r.URL.Path, need)
+			httpError(w, "у API-токена нет scope "+need, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// =============== API (admin) ===============
+
+type CreateAPITokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"` // YYYY-MM-DD (действует по этот день включительно) или RFC3339; пусто — бессрочный
+}
+
+func handleAPITokensList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := ListAPITokens(r.Context())
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"items": items})
+}
+
+// создание токена: ответ содержит сам токен (поле token) — второй раз его не получить
+func handleAPITokenCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := ""
+	if v := strings.TrimSpace(req.ExpiresAt); v != "" {
+		exp, err := parseTokenExpiry(v)
+		if err != nil {
+			httpError(w, "expires_at: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		expiresAt = exp.Format(time.RFC3339)
+	}
+
+	t, secret, err := CreateAPIToken(r.Context(), req.Name, req.Scopes, expiresAt, requestActor(r))
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "invalid_token_name"):
+			httpError(w, "name: латинские буквы, цифры, '.', '_', '-' (до 64 символов)", http.StatusBadRequest)
+		case strings.Contains(msg, "invalid_scope"):
+			httpError(w, "scopes: укажите один или несколько из "+strings.Join(apiTokenScopes, ", "), http.StatusBadRequest)
+		case strings.Contains(msg, "api_token_exists"):
+			httpError(w, "действующий токен с таким именем уже есть", http.StatusConflict)
+		default:
+			httpError(w, "db error: "+msg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logging.Infof("api token %q created by %q: scopes=%v expires=%q", t.Name, requestActor(r), t.Scopes, t.ExpiresAt)
+	auditTarget(r, "api_token", t.ID, nil, t)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, struct {
+		APIToken
+		Token string `json:"token"`
+	}{APIToken: t, Token: secret})
+}
+
+func handleAPITokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := RevokeAPIToken(r.Context(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "api_token_not_found") {
+			httpError(w, "токен не найден или уже отозван", http.StatusNotFound)
+			return
+		}
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Infof("api token %q revoked by %q", t.Name, requestActor(r))
+	auditTarget(r, "api_token", t.ID, nil, t)
+	writeJSON(w, t)
+}
+
+// parseTokenExpiry — срок действия токена; дата без времени — до конца этого дня (UTC).
+func parseTokenExpiry(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		d, derr := time.Parse(licenseDateLayout, v)
+		if derr != nil {
+			return time.Time{}, fmt.Errorf("ожидается YYYY-MM-DD или RFC3339")
+		}
+		t = d.AddDate(0, 0, 1)
+	}
+	t = t.UTC()
+	if !t.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("срок уже прошёл")
+	}
+	return t, nil
+}
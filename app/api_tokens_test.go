@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLegacyWriteTokenRejectedOnceNamedTokensExist(t *testing.T) {
+	newTestDB(t, Config{WriteAPIToken: "legacy-secret"})
+
+	post := httptest.NewRequest("POST", "/api/licenses/import", nil)
+	if p, ok := apiTokenPrincipal(post, "legacy-secret"); !ok || p.Username != "api-token" {
+		t.Fatalf("legacy token without named tokens: got %+v, %v", p, ok)
+	}
+	if _, ok := apiTokenPrincipal(httptest.NewRequest("GET", "/api/licenses", nil), "legacy-secret"); ok {
+		t.Fatal("legacy token accepted for GET")
+	}
+
+	tok, _, err := CreateAPIToken(context.Background(), "ci", []string{scopeLicensesWrite}, "", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := apiTokenPrincipal(post, "legacy-secret"); ok {
+		t.Fatal("legacy token accepted after a named token was created")
+	}
+
+	// отозванный именной токен не возвращает общий
+	if _, err := RevokeAPIToken(context.Background(), tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := apiTokenPrincipal(post, "legacy-secret"); ok {
+		t.Fatal("legacy token accepted after the named token was revoked")
+	}
+}
@@ -3,8 +3,11 @@ package app
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/ryantrue/onessa/internal/logging"
 )
 
@@ -14,9 +17,10 @@ type AssignRequest struct {
 }
 
 type UpdateLicenseRequest struct {
-	LicenseID int    `json:"license_id"`
-	Comment   string `json:"comment"`
-	PC        string `json:"pc"`
+	LicenseID  int    `json:"license_id"`
+	Comment    string `json:"comment"`
+	PC         string `json:"pc"`
+	ComputerID int    `json:"computer_id"` // если задан — pc берётся из справочника
 }
 
 type UnassignRequest struct {
@@ -24,18 +28,11 @@ type UnassignRequest struct {
 }
 
 type ImportUsersRequest struct {
-	Users []struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
-	} `json:"users"`
+	Users []ManualUserImport `json:"users"`
 }
 
 type ImportLicensesRequest struct {
-	Licenses []struct {
-		Key     string `json:"key"`
-		Comment string `json:"comment"`
-		PC      string `json:"pc"`
-	} `json:"licenses"`
+	Licenses []LicenseImport `json:"licenses"`
 }
 
 // =============== API ОБЩЕЕ СОСТОЯНИЕ ===============
@@ -88,7 +85,7 @@ func handleImportUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imported, warnings, err := ImportManualUsersUpsert(r.Context(), req.Users)
+	imported, warnings, err := getStore().ImportManualUsers(r.Context(), req.Users)
 	if err != nil {
 		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -98,6 +95,7 @@ func handleImportUsers(w http.ResponseWriter, r *http.Request) {
 		logging.Warnf("import users warning: %s", wmsg)
 	}
 	logging.Infof("import users: imported=%d warnings=%d", imported, len(warnings))
+	auditTarget(r, "user", nil, nil, map[string]any{"imported": imported, "warnings": len(warnings)})
 
 	resp := struct {
 		UsersImported int      `json:"users_imported"`
@@ -112,45 +110,142 @@ func handleImportUsers(w http.ResponseWriter, r *http.Request) {
 
 // =============== API ЛИЦЕНЗИИ ===============
 
-// импорт лицензий (всегда в БД)
+// импорт лицензий (всегда в БД): JSON {"licenses": [...]} или файл CSV/XLSX (multipart, см. license_import.go).
+// ?dry_run=true — только проверка: ответ с итогом по каждой строке, в БД ничего не записывается.
 func handleImportLicenses(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req ImportLicensesRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+	dry, err := parseOptionalBool(r.URL.Query(), "dry_run")
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(req.Licenses) == 0 {
-		httpError(w, "передайте хотя бы одну лицензию", http.StatusBadRequest)
-		return
+	dryRun := dry != nil && *dry
+
+	var in licenseImportFile
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if in, err = readLicenseImportForm(w, r); err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var req ImportLicensesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Licenses) == 0 {
+			httpError(w, "передайте хотя бы одну лицензию", http.StatusBadRequest)
+			return
+		}
+		in.Licenses = req.Licenses
 	}
 
-	imported, warnings, err := ImportLicenses(r.Context(), req.Licenses)
+	res, err := getStore().ImportLicenses(r.Context(), requestActor(r), in.Licenses, dryRun)
 	if err != nil {
 		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if in.Rows != nil {
+		for i := range res.Rows {
+			res.Rows[i].Row = in.Rows[i]
+		}
+	}
 
-	for _, wmsg := range warnings {
-		logging.Warnf("import licenses warning: %s", wmsg)
+	if dryRun {
+		logging.Infof("import licenses dry run: ok=%d rows=%d", res.Imported, len(res.Rows))
+	} else {
+		for _, wmsg := range res.Warnings {
+			logging.Warnf("import licenses warning: %s", wmsg)
+		}
+		logging.Infof("import licenses: imported=%d warnings=%d", res.Imported, len(res.Warnings))
+	}
+	var keys []string
+	for _, row := range res.Rows {
+		if row.Status == "ok" {
+			keys = append(keys, row.Key)
+		}
 	}
-	logging.Infof("import licenses: imported=%d warnings=%d", imported, len(warnings))
+	auditTarget(r, "license", nil, nil, map[string]any{"imported": res.Imported, "dry_run": dryRun, "keys": keys})
 
 	resp := struct {
-		LicensesImported int      `json:"licenses_imported"`
-		Warnings         []string `json:"warnings,omitempty"`
+		LicensesImported int                `json:"licenses_imported"` // при dry_run — сколько будет добавлено
+		DryRun           bool               `json:"dry_run"`
+		Warnings         []string           `json:"warnings,omitempty"`
+		Rows             []LicenseImportRow `json:"rows"`
 	}{
-		LicensesImported: imported,
-		Warnings:         warnings,
+		LicensesImported: res.Imported,
+		DryRun:           dryRun,
+		Warnings:         res.Warnings,
+		Rows:             res.Rows,
 	}
 
 	writeJSON(w, resp)
 }
 
+// список лицензий с фильтрами для планирования продлений:
+// ?product=CSP&version=5.0&license_type=term&expires_before=2025-12-31&expires_after=...&expired=true|false
+// и для таблицы на главной: ?q=<ключ, комментарий, ПК или пользователь>&assigned=true|false&user_id=
+// &sort=key|user|comment|pc|product|expires_at|id&order=asc|desc&limit=50&offset=0 (или &cursor=<next_cursor>)
+func handleLicenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f := LicenseFilter{
+		Product:     q.Get("product"),
+		Version:     q.Get("version"),
+		LicenseType: q.Get("license_type"),
+		Search:      q.Get("q"),
+	}
+
+	var err error
+	if f.ExpiresBefore, err = normalizeLicenseDate(q.Get("expires_before")); err != nil {
+		httpError(w, "expires_before: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.ExpiresAfter, err = normalizeLicenseDate(q.Get("expires_after")); err != nil {
+		httpError(w, "expires_after: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Expired, err = parseOptionalBool(q, "expired"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Assigned, err = parseOptionalBool(q, "assigned"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("user_id"); v != "" {
+		if f.AssignedUserID, err = strconv.Atoi(v); err != nil || f.AssignedUserID <= 0 {
+			httpError(w, "некорректный user_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, err := parseListParams(q, licenseSorts)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	licenses, page, err := getStore().ListLicenses(r.Context(), f, p)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	writeJSON(w, struct {
+		Licenses []License `json:"licenses"`
+		Page
+	}{Licenses: licenses, Page: page})
+}
+
 // привязка / перепривязка лицензии к пользователю
 func handleAssign(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -168,8 +263,9 @@ func handleAssign(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "user_id и license_id обязательны", http.StatusBadRequest)
 		return
 	}
+	auditLicense(r, req.LicenseID)
 
-	if err := AssignLicense(r.Context(), req.UserID, req.LicenseID); err != nil {
+	if err := getStore().AssignLicense(r.Context(), requestActor(r), req.UserID, req.LicenseID); err != nil {
 		msg := err.Error()
 		switch {
 		case strings.Contains(msg, "user_not_found"):
@@ -203,14 +299,21 @@ func handleUpdateLicense(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "license_id обязателен", http.StatusBadRequest)
 		return
 	}
+	auditLicense(r, req.LicenseID)
 
-	if err := UpdateLicense(r.Context(), req.LicenseID, req.Comment, req.PC); err != nil {
-		if strings.Contains(err.Error(), "license_not_found") {
+	if err := getStore().UpdateLicense(r.Context(), requestActor(r), req.LicenseID, req.Comment, req.PC, req.ComputerID); err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "license_not_found"):
 			httpError(w, "лицензия не найдена", http.StatusBadRequest)
 			return
+		case strings.Contains(msg, "computer_not_found"):
+			httpError(w, "ПК не найден среди активных компьютеров каталога", http.StatusBadRequest)
+			return
+		default:
+			httpError(w, "db error: "+msg, http.StatusInternalServerError)
+			return
 		}
-		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
-		return
 	}
 
 	writeJSON(w, map[string]any{"status": "ok"})
@@ -232,8 +335,9 @@ func handleUnassignLicense(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "license_id обязателен", http.StatusBadRequest)
 		return
 	}
+	auditLicense(r, req.LicenseID)
 
-	if err := UnassignLicense(r.Context(), req.LicenseID); err != nil {
+	if err := getStore().UnassignLicense(r.Context(), requestActor(r), req.LicenseID); err != nil {
 		if strings.Contains(err.Error(), "license_not_found") {
 			httpError(w, "лицензия не найдена", http.StatusBadRequest)
 			return
@@ -244,3 +348,35 @@ func handleUnassignLicense(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, map[string]any{"status": "ok"})
 }
+
+// история лицензии: кто и когда её назначал/переназначал/редактировал
+func handleLicenseHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		httpError(w, "некорректный id лицензии", http.StatusBadRequest)
+		return
+	}
+
+	events, err := getStore().ListLicenseEvents(r.Context(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "license_not_found") {
+			httpError(w, "лицензия не найдена", http.StatusNotFound)
+			return
+		}
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		LicenseID int            `json:"license_id"`
+		Events    []LicenseEvent `json:"events"`
+	}{
+		LicenseID: id,
+		Events:    events,
+	})
+}
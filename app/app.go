@@ -9,7 +9,9 @@ func Init(ctx context.Context, cfg Config) error {
 	if err := InitDB(cfg.DataDir); err != nil {
 		return err
 	}
-	EnsureLDAPDataLoaded(ctx)
+	EnsureLDAPDataLoaded(ctx, "startup")
 	StartBackgroundLDAPSync(ctx)
+	StartBackgroundExpiryNotify(ctx)
+	StartBackgroundBackup(ctx)
 	return nil
 }
@@ -0,0 +1,446 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Журнал аудита (audit_log): кто, что и когда менял.
+// auditMiddleware пишет запись на каждый изменяющий запрос к /api — и на отклонённый, со статусом ответа;
+// действие — operationId маршрута из openapi.json. Обработчики дополняют запись сущностью и её состоянием
+// до/после (auditTarget, auditLicense). Вход, неудачный вход и выход пишут handleLogin и handleLogout.
+
+// AuditEntry — запись журнала; Before/After — JSON состояния сущности (если обработчик его передал).
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Actor     string          `json:"actor"` // пользователь сессии или API-токен
+	Role      string          `json:"role"`
+	Action    string          `json:"action"` // operationId; login | loginFailed | logout
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"` // HTTP-статус ответа; для входа — 200 или 401
+	RequestID string          `json:"request_id"`
+	IP        string          `json:"ip"`
+}
+
+// AuditFilter — фильтры журнала (пустые поля не применяются).
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID string
+	From     string // RFC3339 (UTC), включительно
+	To       string // RFC3339 (UTC), не включительно
+	Failed   *bool  // true — только отклонённые и ошибочные (status >= 400)
+}
+
+// порядок — по id; по умолчанию новые сверху (см. handleAuditList)
+var auditSorts = listSorts{"": {idSortKey}}
+
+const auditColumns = `id, created_at, actor, role, action, entity, entity_id, before_json, after_json,
+	method, path, status, request_id, ip`
+
+// InsertAuditEntry добавляет запись в журнал.
+func InsertAuditEntry(ctx context.Context, e AuditEntry) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO audit_log(created_at, actor, role, action, entity, entity_id, before_json, after_json,
+			method, path, status, request_id, ip)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.CreatedAt, e.Actor, e.Role, e.Action, e.Entity, e.EntityID, string(e.Before), string(e.After),
+		e.Method, e.Path, e.Status, e.RequestID, e.IP)
+	return err
+}
+
+// EachAuditEntry передаёт в fn записи журнала под фильтром (страницу — по p).
+func EachAuditEntry(ctx context.Context, f AuditFilter, p ListParams, fn func(AuditEntry) error) (Page, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return Page{}, err
+	}
+
+	q := listQuery{cols: auditColumns, from: "audit_log"}
+	for _, c := range []struct{ col, val string }{
+		{"actor", f.Actor}, {"action", f.Action}, {"entity", f.Entity}, {"entity_id", f.EntityID},
+	} {
+		if v := strings.TrimSpace(c.val); v != "" {
+			q.where = append(q.where, c.col+" = ?")
+			q.args = append(q.args, v)
+		}
+	}
+	if f.From != "" {
+		q.where = append(q.where, "created_at >= ?")
+		q.args = append(q.args, f.From)
+	}
+	if f.To != "" {
+		q.where = append(q.where, "created_at < ?")
+		q.args = append(q.args, f.To)
+	}
+	if f.Failed != nil {
+		if *f.Failed {
+			q.where = append(q.where, "status >= 400")
+		} else {
+			q.where = append(q.where, "status < 400")
+		}
+	}
+
+	return q.run(ctx, conn, auditSorts, p, func(rows *sql.Rows, keys []any) error {
+		var e AuditEntry
+		var before, after string
+		if err := rows.Scan(append([]any{&e.ID, &e.CreatedAt, &e.Actor, &e.Role, &e.Action, &e.Entity, &e.EntityID,
+			&before, &after, &e.Method, &e.Path, &e.Status, &e.RequestID, &e.IP}, keys...)...); err != nil {
+			return err
+		}
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		return fn(e)
+	})
+}
+
+// =============== запись из HTTP ===============
+
+// auditRecord — то, что обработчик сообщает о запросе; лежит в context запроса (auditMiddleware).
+type auditRecord struct {
+	entity   string
+	entityID string
+	before   any
+	after    any
+	afterFn  func(ctx context.Context) any // состояние после — читается, когда обработчик закончил
+}
+
+type auditCtxKey struct{}
+
+func auditFrom(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditCtxKey{}).(*auditRecord)
+	if rec == nil {
+		return &auditRecord{} // запрос без аудита: изменения некуда записать
+	}
+	return rec
+}
+
+// auditTarget — сущность, которую меняет запрос, и её состояние до/после (nil — нет).
+func auditTarget(r *http.Request, entity string, id any, before, after any) {
+	rec := auditFrom(r)
+	rec.entity = entity
+	if id != nil {
+		rec.entityID = fmt.Sprint(id)
+	}
+	rec.before, rec.after = before, after
+}
+
+// auditLicense запоминает лицензию до изменения; состояние после читается после ответа обработчика.
+func auditLicense(r *http.Request, id int) {
+	var before any
+	if l, err := getStore().GetLicense(r.Context(), id); err == nil {
+		before = l
+	}
+	auditTarget(r, "license", id, before, nil)
+	auditFrom(r).afterFn = func(ctx context.Context) any {
+		l, err := getStore().GetLicense(ctx, id)
+		if err != nil {
+			return nil // удалена
+		}
+		return l
+	}
+}
+
+// auditMiddleware — запись в журнал на каждый изменяющий запрос к маршруту /api.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &auditRecord{}
+		r = r.WithContext(context.WithValue(r.Context(), auditCtxKey{}, rec))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		action := auditAction(r)
+		if action == "" {
+			return // 404/405: маршрута нет — и менять было нечего
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// запись — уже после ответа: отмена запроса клиентом не должна её терять
+		ctx := context.WithoutCancel(r.Context())
+		if rec.afterFn != nil && status < 400 {
+			rec.after = rec.afterFn(ctx)
+		}
+		writeAudit(ctx, r, AuditEntry{
+			Action:   action,
+			Entity:   rec.entity,
+			EntityID: rec.entityID,
+			Before:   auditJSON(rec.before),
+			After:    auditJSON(rec.after),
+			Status:   status,
+		})
+	})
+}
+
+var (
+	auditOpsOnce sync.Once
+	auditOps     map[string]string
+)
+
+// auditAction — operationId маршрута запроса из openapi.json ("" — маршрут не найден).
+func auditAction(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	pattern := rctx.RoutePattern()
+	if pattern == "" || strings.HasSuffix(pattern, "/*") {
+		return ""
+	}
+	auditOpsOnce.Do(func() {
+		ops, err := openAPIOperations(openAPISpec)
+		if err != nil {
+			logging.Errorf("audit: %v", err)
+		}
+		auditOps = ops
+	})
+	op := r.Method + " " + pattern
+	if id := auditOps[op]; id != "" {
+		return id
+	}
+	return op
+}
+
+func auditJSON(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		logging.Errorf("audit: marshal %T: %v", v, err)
+		return nil
+	}
+	return b
+}
+
+// writeAudit дополняет запись данными запроса (кто, откуда, request id) и пишет её; ошибка — только в лог.
+func writeAudit(ctx context.Context, r *http.Request, e AuditEntry) {
+	e.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	if e.Actor == "" {
+		e.Actor = requestActor(r)
+	}
+	if p, ok := principalFrom(r.Context()); ok && e.Role == "" {
+		e.Role = p.Role.String()
+	}
+	e.Method = r.Method
+	e.Path = r.URL.Path
+	e.RequestID = middleware.GetReqID(r.Context())
+	e.IP = clientIP(r)
+
+	if err := InsertAuditEntry(ctx, e); err != nil {
+		logging.Errorf("audit: cannot write %s by %q: %v", e.Action, e.Actor, err)
+	}
+}
+
+// auditLogin — вход, неудачный вход и выход (/login, /logout — вне /api, их пишет сам обработчик).
+// status — итог по смыслу (200 — вход выполнен, 401 — отказ), а не код ответа: ответ всегда редирект.
+func auditLogin(r *http.Request, action, username string, role Role, status int, reason string) {
+	var after any
+	if reason != "" {
+		after = map[string]string{"reason": reason}
+	}
+	e := AuditEntry{
+		Actor:    normalizeLogin(username),
+		Action:   action,
+		Entity:   "session",
+		EntityID: normalizeLogin(username),
+		After:    auditJSON(after),
+		Status:   status,
+	}
+	if role != RoleNone {
+		e.Role = role.String()
+	}
+	writeAudit(r.Context(), r, e)
+}
+
+// =============== API (admin) ===============
+
+// parseAuditFilter читает ?actor=&action=&entity=&entity_id=&from=&to=&failed=; ошибка — текст для ответа 400.
+func parseAuditFilter(q url.Values) (AuditFilter, error) {
+	get := func(k string) string { return strings.TrimSpace(q.Get(k)) }
+	f := AuditFilter{
+		Actor:    get("actor"),
+		Action:   get("action"),
+		Entity:   get("entity"),
+		EntityID: get("entity_id"),
+	}
+
+	var err error
+	if f.From, err = auditTime(get("from"), false); err != nil {
+		return f, fmt.Errorf("from: %v", err)
+	}
+	if f.To, err = auditTime(get("to"), true); err != nil {
+		return f, fmt.Errorf("to: %v", err)
+	}
+	if f.Failed, err = parseOptionalBool(q, "failed"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// auditTime — граница периода в формате created_at. Дата без времени в to — до конца этого дня.
+func auditTime(v string, end bool) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse(licenseDateLayout, v)
+	if err != nil {
+		return "", fmt.Errorf("ожидается YYYY-MM-DD или RFC3339")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Format(time.RFC3339), nil
+}
+
+// журнал: фильтры (см. parseAuditFilter), &limit=50 и &offset= или &cursor=; новые сверху, order=asc — старые сверху
+func handleAuditList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f, err := parseAuditFilter(q)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := parseListParams(q, auditSorts)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.Desc = !strings.EqualFold(q.Get("order"), "asc")
+	if p.Limit == 0 {
+		p.Limit = 50
+	}
+
+	items := []AuditEntry{}
+	page, err := EachAuditEntry(r.Context(), f, p, func(e AuditEntry) error {
+		items = append(items, e)
+		return nil
+	})
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	writeJSON(w, struct {
+		Items []AuditEntry `json:"items"`
+		Page
+	}{Items: items, Page: page})
+}
+
+// csvSafe защищает ячейку CSV от выполнения как формулы в Excel/LibreOffice (CSV injection):
+// значение, начинающееся с =, +, -, @, табуляции или CR, предваряется апострофом.
+// Поля журнала (логин, комментарий, before/after) приходят от пользователей.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// выгрузка журнала целиком (те же фильтры, по возрастанию id): ?format=csv (по умолчанию) | json
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f, err := parseAuditFilter(q)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		httpError(w, "format должен быть csv или json", http.StatusBadRequest)
+		return
+	}
+
+	name := "audit-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	// Заголовок ответа уже отправлен: ошибка посреди выгрузки — только в лог (файл будет неполным).
+	var each func(AuditEntry) error
+	var finish func()
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "created_at", "actor", "role", "action", "entity", "entity_id",
+			"status", "method", "path", "request_id", "ip", "before", "after"})
+		each = func(e AuditEntry) error {
+			row := []string{strconv.Itoa(e.ID), e.CreatedAt, e.Actor, e.Role, e.Action, e.Entity, e.EntityID,
+				strconv.Itoa(e.Status), e.Method, e.Path, e.RequestID, e.IP, string(e.Before), string(e.After)}
+			for i := range row {
+				row[i] = csvSafe(row[i])
+			}
+			return cw.Write(row)
+		}
+		finish = cw.Flush
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		n := 0
+		_, _ = w.Write([]byte("["))
+		each = func(e AuditEntry) error {
+			if n > 0 {
+				_, _ = w.Write([]byte(","))
+			}
+			n++
+			return enc.Encode(e)
+		}
+		finish = func() { _, _ = w.Write([]byte("]\n")) }
+	}
+
+	if _, err := EachAuditEntry(r.Context(), f, ListParams{}, each); err != nil {
+		logging.Errorf("audit export: %v", err)
+	}
+	finish()
+	logging.Infof("audit exported by %q: format=%s", requestActor(r), format)
+}
@@ -1,14 +1,10 @@
 package app
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -77,108 +73,61 @@ func sessionSecret() []byte {
 
 }
 
-func makeSessionToken(username string, ts int64) string {
+func currentSession(r *http.Request) (Principal, bool) {
 
-	payload := fmt.Sprintf("%s|%d", username, ts)
-
-	mac := hmac.New(sha256.New, sessionSecret())
-
-	_, _ = mac.Write([]byte(payload))
-
-	sig := mac.Sum(nil)
-
-	token := fmt.Sprintf("%s|%s", payload, base64.RawURLEncoding.EncodeToString(sig))
-
-	return base64.RawURLEncoding.EncodeToString([]byte(token))
-
-}
-
-func parseSessionToken(token string) (string, bool) {
-
-	raw, err := base64.RawURLEncoding.DecodeString(token)
-
-	if err != nil {
-
-		return "", false
-
-	}
-
-	parts := strings.Split(string(raw), "|")
+	c, err := r.Cookie(sessionCookieName)
 
-	if len(parts) != 3 {
+	if err != nil || c.Value == "" {
 
-		return "", false
+		return Principal{}, false
 
 	}
 
-	username := parts[0]
-
-	tsStr := parts[1]
-
-	sigB64 := parts[2]
-
-	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	sess, err := TouchSession(r.Context(), sessionIDFromToken(c.Value))
 
 	if err != nil {
 
-		return "", false
-
-	}
-
-	issuedAt := time.Unix(ts, 0)
-
-	if time.Since(issuedAt) > sessionTTL {
-
-		return "", false
+		return Principal{}, false
 
 	}
 
-	payload := fmt.Sprintf("%s|%d", username, ts)
+	return Principal{Username: sess.Username, Role: parseRole(sess.Role)}, true
 
-	mac := hmac.New(sha256.New, sessionSecret())
-
-	_, _ = mac.Write([]byte(payload))
+}
 
-	expectedSig := mac.Sum(nil)
+func currentUsername(r *http.Request) (string, bool) {
 
-	sig, err := base64.RawURLEncoding.DecodeString(sigB64)
+	p, ok := currentSession(r)
 
-	if err != nil {
+	return p.Username, ok
 
-		return "", false
+}
 
+// requestActor — кто выполняет запрос (для истории изменений).
+func requestActor(r *http.Request) string {
+	if p, ok := principalFrom(r.Context()); ok && p.Username != "" {
+		return normalizeLogin(p.Username)
 	}
-
-	if !hmac.Equal(expectedSig, sig) {
-
-		return "", false
-
+	if username, ok := currentUsername(r); ok {
+		return normalizeLogin(username)
 	}
-
-	return username, true
-
+	if strings.TrimSpace(r.Header.Get("X-API-Token")) != "" {
+		return "api-token"
+	}
+	return "anonymous"
 }
 
-func currentUsername(r *http.Request) (string, bool) {
+// setAuthCookie создаёт серверную сессию и отдаёт её токен в cookie.
+func setAuthCookie(w http.ResponseWriter, r *http.Request, username string, role Role) error {
 
-	c, err := r.Cookie(sessionCookieName)
+	token, err := CreateSession(r.Context(), normalizeLogin(username), role, clientIP(r), r.UserAgent())
 
-	if err != nil || c.Value == "" {
+	if err != nil {
 
-		return "", false
+		return err
 
 	}
 
-	return parseSessionToken(c.Value)
-
-}
-
-func setAuthCookie(w http.ResponseWriter, username string) {
-
-	now := time.Now().Unix()
-
-	token := makeSessionToken(username, now)
-
 	secure := getConfig().SessionCookieSecure
 
 	http.SetCookie(w, &http.Cookie{
@@ -189,6 +138,8 @@ func setAuthCookie(w http.ResponseWriter, username string) {
 
 		Path: "/",
 
+		MaxAge: int(sessionTTL.Seconds()),
+
 		HttpOnly: true,
 
 		SameSite: http.SameSiteLaxMode,
@@ -196,6 +147,8 @@ func setAuthCookie(w http.ResponseWriter, username string) {
 		Secure: secure,
 	})
 
+	return nil
+
 }
 
 func normalizeLogin(username string) string {
@@ -240,6 +193,8 @@ func clearAuthCookie(w http.ResponseWriter) {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	setCSRFCookie(w, "", -1)
+
 }
 
 func staticFile(name string) string {
@@ -250,12 +205,14 @@ func staticFile(name string) string {
 	return filepath.Join(dir, name)
 }
 
-func ldapCheckUser(username, password string) (bool, error) {
+// ldapCheckUser проверяет логин/пароль через LDAP bind и определяет роль по группам пользователя.
+// RoleNone означает, что вход запрещён.
+func ldapCheckUser(username, password string) (Role, error) {
 	if !ldapEnabled() {
-		return false, nil
+		return RoleNone, nil
 	}
 	if strings.TrimSpace(username) == "" || password == "" {
-		return false, nil
+		return RoleNone, nil
 	}
 
 	raw := strings.TrimSpace(username)
@@ -263,7 +220,7 @@ func ldapCheckUser(username, password string) (bool, error) {
 
 	if !authAllowed(username) {
 		logging.Warnf("ldapCheckUser: user %q is not in AUTH_USERS allowlist", username)
-		return false, nil
+		return RoleNone, nil
 	}
 
 	logging.Infof("ldapCheckUser: start, username=%q (raw=%q)", username, raw)
@@ -274,14 +231,14 @@ func ldapCheckUser(username, password string) (bool, error) {
 	)
 	if err != nil {
 		logging.Errorf("ldapCheckUser: dial error: %v", err)
-		return false, fmt.Errorf("ldap dial: %w", err)
+		return RoleNone, fmt.Errorf("ldap dial: %w", err)
 	}
 	defer conn.Close()
 
 	if ldapCfg.BindDN != "" {
 		if err := conn.Bind(ldapCfg.BindDN, ldapCfg.BindPassword); err != nil {
 			logging.Errorf("ldapCheckUser: service bind error: %v", err)
-			return false, fmt.Errorf("ldap bind (service): %w", err)
+			return RoleNone, fmt.Errorf("ldap bind (service): %w", err)
 		}
 	}
 
@@ -298,18 +255,18 @@ func ldapCheckUser(username, password string) (bool, error) {
 		ldap.NeverDerefAliases,
 		1, 0, false,
 		filter,
-		[]string{"dn"},
+		[]string{"dn", "memberOf"},
 		nil,
 	)
 
 	sr, err := conn.Search(searchReq)
 	if err != nil {
 		logging.Errorf("ldapCheckUser: search error: %v", err)
-		return false, fmt.Errorf("ldap search: %w", err)
+		return RoleNone, fmt.Errorf("ldap search: %w", err)
 	}
 	if len(sr.Entries) == 0 {
 		logging.Warnf("ldapCheckUser: no entries found for username=%q", username)
-		return false, nil
+		return RoleNone, nil
 	}
 
 	userDN := sr.Entries[0].DN
@@ -318,11 +275,24 @@ func ldapCheckUser(username, password string) (bool, error) {
 	// Проверяем пароль – bind под этим пользователем
 	if err := conn.Bind(userDN, password); err != nil {
 		logging.Warnf("ldapCheckUser: bad password for %q: %v", username, err)
-		return false, nil
+		return RoleNone, nil
 	}
 
-	logging.Infof("ldapCheckUser: success for %q", username)
-	return true, nil
+	// Группы читаем после bind пользователя: в AD у него есть права на чтение своих memberOf.
+	groups, err := ldapUserGroups(conn, sr.Entries[0])
+	if err != nil {
+		logging.Errorf("ldapCheckUser: groups lookup error for %q: %v", username, err)
+		return RoleNone, err
+	}
+
+	role := roleFromGroups(groups)
+	if role == RoleNone {
+		logging.Warnf("ldapCheckUser: %q is not a member of any role group", username)
+		return RoleNone, nil
+	}
+
+	logging.Infof("ldapCheckUser: success for %q, role=%s", username, role)
+	return role, nil
 }
 
 func safeNext(next string) string {
@@ -387,32 +357,19 @@ func isPublicStaticForLogin(path string) bool {
 
 }
 
-func isWriteAPIWithoutAuth(r *http.Request) bool {
-	if !strings.HasPrefix(r.URL.Path, "/api/") {
-		return false
-	}
-
-	if r.Method == http.MethodGet || r.Method == http.MethodHead {
-		return false
-	}
+func authMiddleware(next http.Handler) http.Handler {
 
-	token := strings.TrimSpace(getConfig().WriteAPIToken)
-	if token == "" {
-		logging.Warnf("WRITE_API_TOKEN is empty: write /api/* endpoints are public (legacy mode)")
-		return true
-	}
+	if !authEnabled() {
 
-	got := strings.TrimSpace(r.Header.Get("X-API-Token"))
-	return got != "" && got == token
-}
+		logging.Warnf("authMiddleware: neither LDAP nor OIDC is configured, auth is DISABLED")
 
-func authMiddleware(next http.Handler) http.Handler {
+		return next
 
-	if !ldapEnabled() {
+	}
 
-		logging.Warnf("authMiddleware: LDAP is not configured, auth is DISABLED")
+	if strings.TrimSpace(getConfig().WriteAPIToken) != "" {
 
-		return next
+		logging.Warnf("WRITE_API_TOKEN is deprecated and stops working once any named token exists: create named tokens with POST /api/admin/tokens and remove it")
 
 	}
 
@@ -420,7 +377,9 @@ func authMiddleware(next http.Handler) http.Handler {
 
 		path := r.URL.Path
 
-		if path == "/healthz" || path == "/login" || path == "/logout" || isPublicStaticForLogin(path) {
+		// /metrics защищён своим токеном (METRICS_TOKEN)
+		if path == "/healthz" || path == "/login" || path == "/logout" || strings.HasPrefix(path, "/login/") || isPublicStaticForLogin(path) ||
+			(path == "/metrics" && metricsOnMainAddr(getConfig())) {
 
 			next.ServeHTTP(w, r)
 
@@ -428,19 +387,34 @@ func authMiddleware(next http.Handler) http.Handler {
 
 		}
 
-		if isWriteAPIWithoutAuth(r) {
+		// Сессия проверяется первой: браузер залогиненного пользователя не передаёт X-API-Token.
+		if p, ok := currentSession(r); ok {
 
-			next.ServeHTTP(w, r)
+			logging.Infof("authMiddleware: session ok for %q (role=%s), path=%s", p.Username, p.Role, path)
+
+			ensureCSRFCookie(w, r)
+
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
 
 			return
 
 		}
 
-		if username, ok := currentUsername(r); ok {
+		if token := strings.TrimSpace(r.Header.Get("X-API-Token")); token != "" && strings.HasPrefix(path, "/api/") {
 
-			logging.Infof("authMiddleware: session ok for %q, path=%s", username, path)
+			p, ok := apiTokenPrincipal(r, token)
 
-			next.ServeHTTP(w, r)
+			if !ok {
+
+				logging.Warnf("authMiddleware: invalid api token from %s, path=%s", clientIP(r), path)
+
+				httpError(w, "недействительный API-токен", http.StatusUnauthorized)
+
+				return
+
+			}
+
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
 
 			return
 
@@ -474,6 +448,8 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Cache-Control", "no-store")
 
+		ensureLoginCSRFCookie(w, r)
+
 		http.ServeFile(w, r, staticFile("login.html"))
 
 	case http.MethodPost:
@@ -492,19 +468,58 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 		next := safeNext(r.Form.Get("next"))
 
-		ok, err := ldapCheckUser(username, password)
+		if !checkLoginCSRF(r) {
+
+			logging.Warnf("handleLogin: csrf check failed for %q from %s", username, clientIP(r))
+
+			auditLogin(r, "loginFailed", username, RoleNone, http.StatusForbidden, "csrf")
+
+			redirectToLogin(w, r, next, "Форма входа устарела, попробуйте ещё раз")
+
+			return
+
+		}
+
+		if !ldapEnabled() {
+
+			// только OIDC: форма пароля скрыта, но POST мог прийти из старой вкладки
+			redirectToLogin(w, r, next, "Вход по паролю отключён, используйте вход через SSO")
+
+			return
+
+		}
+
+		reservation, ok := reserveLogin(w, r, username, next)
+
+		if !ok {
+
+			return
+
+		}
+
+		role, err := ldapCheckUser(username, password)
+
+		observeLDAPLogin(role, err)
 
 		if err != nil {
 
 			logging.Errorf("handleLogin: ldap error for %q: %v", username, err)
 
+			auditLogin(r, "loginFailed", username, RoleNone, http.StatusInternalServerError, "ldap_error")
+
+			releaseLogin(r, username)
+
 			redirectToLogin(w, r, next, "Ошибка авторизации, обратитесь к администратору")
 
 			return
 
 		}
 
-		if !ok {
+		if role == RoleNone {
+
+			auditLogin(r, "loginFailed", username, RoleNone, http.StatusUnauthorized, "invalid_credentials")
+
+			loginFailed(reservation)
 
 			redirectToLogin(w, r, next, "Неверный логин/пароль или у вас нет доступа")
 
@@ -512,7 +527,27 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 		}
 
-		setAuthCookie(w, username)
+		if err := setAuthCookie(w, r, username, role); err != nil {
+
+			logging.Errorf("handleLogin: cannot create session for %q: %v", username, err)
+
+			auditLogin(r, "loginFailed", username, role, http.StatusInternalServerError, "session_error")
+
+			releaseLogin(r, username)
+
+			redirectToLogin(w, r, next, "Ошибка авторизации, обратитесь к администратору")
+
+			return
+
+		}
+
+		auditLogin(r, "login", username, role, http.StatusOK, "")
+
+		if err := ResetLoginFailures(r.Context(), username, clientIP(r)); err != nil {
+
+			logging.Warnf("handleLogin: cannot reset login failures for %q: %v", username, err)
+
+		}
 
 		http.Redirect(w, r, next, http.StatusFound)
 
@@ -526,6 +561,22 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 func handleLogout(w http.ResponseWriter, r *http.Request) {
 
+	if p, ok := currentSession(r); ok {
+
+		auditLogin(r, "logout", p.Username, p.Role, http.StatusOK, "")
+
+	}
+
+	if c, err := r.Cookie(sessionCookieName); err == nil && c.Value != "" {
+
+		if err := DeleteSession(r.Context(), sessionIDFromToken(c.Value)); err != nil {
+
+			logging.Warnf("handleLogout: cannot delete session: %v", err)
+
+		}
+
+	}
+
 	clearAuthCookie(w)
 
 	http.Redirect(w, r, "/login", http.StatusFound)
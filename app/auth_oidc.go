@@ -0,0 +1,827 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Вход через OpenID Connect: authorization code + PKCE (S256).
+//
+//	GET /login/oidc?next=/         → редирект на authorization_endpoint провайдера
+//	GET /login/oidc/callback       → обмен code на токены, проверка id_token, сессия как после LDAP-входа
+//
+// state, nonce, code_verifier и next живут до возврата от провайдера в подписанной cookie cp_oidc.
+// id_token проверяется локально по ключам jwks_uri (RS*, PS*, ES*): iss, aud, exp, nonce.
+// Логин из OIDC_USERNAME_CLAIM нормализуется как LDAP-логин и сопоставляется со строкой users
+// (по login, затем по подтверждённому email), поэтому сессии, аудит и история изменений общие для обоих способов входа.
+
+const (
+	oidcCookieName   = "cp_oidc"
+	oidcCookiePath   = "/login/oidc"
+	oidcFlowTTL      = 10 * time.Minute
+	oidcCallbackPath = "/login/oidc/callback"
+
+	// провайдер и часы сервиса могут слегка расходиться
+	oidcClockSkew = time.Minute
+)
+
+func oidcEnabled() bool {
+	c := getConfig()
+	return strings.TrimSpace(c.OIDCIssuer) != "" && strings.TrimSpace(c.OIDCClientID) != ""
+}
+
+// authEnabled — включена ли авторизация (хотя бы один способ входа).
+func authEnabled() bool {
+	return ldapEnabled() || oidcEnabled()
+}
+
+// =============== провайдер: discovery и ключи ===============
+
+type oidcProviderMeta struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+var oidcCache struct {
+	mu          sync.Mutex
+	issuer      string
+	meta        *oidcProviderMeta
+	metaFetched time.Time
+	keys        map[string]crypto.PublicKey // kid → ключ
+	keysFetched time.Time
+}
+
+func oidcHTTPClient() *http.Client {
+	c := getConfig()
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfigWithCA("OIDC_CA_FILE", c.OIDCCAFile, c.OIDCTLSInsecureSkipVerify)
+	return &http.Client{Timeout: 15 * time.Second, Transport: tr}
+}
+
+func oidcIssuer() string {
+	return strings.TrimRight(strings.TrimSpace(getConfig().OIDCIssuer), "/")
+}
+
+// oidcGetJSON — GET url, ответ JSON в out; token (если задан) — Bearer.
+func oidcGetJSON(ctx context.Context, rawURL, token string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := oidcHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// oidcProvider — метаданные провайдера (.well-known/openid-configuration), кэшируются на час.
+func oidcProvider(ctx context.Context) (*oidcProviderMeta, error) {
+	issuer := oidcIssuer()
+
+	oidcCache.mu.Lock()
+	defer oidcCache.mu.Unlock()
+	if oidcCache.meta != nil && oidcCache.issuer == issuer && time.Since(oidcCache.metaFetched) < time.Hour {
+		return oidcCache.meta, nil
+	}
+
+	var meta oidcProviderMeta
+	if err := oidcGetJSON(ctx, issuer+"/.well-known/openid-configuration", "", &meta); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match OIDC_ISSUER %q", meta.Issuer, issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+
+	if oidcCache.issuer != issuer {
+		oidcCache.keys = nil
+		oidcCache.keysFetched = time.Time{}
+	}
+	oidcCache.issuer = issuer
+	oidcCache.meta = &meta
+	oidcCache.metaFetched = time.Now()
+	return &meta, nil
+}
+
+// oidcKey — ключ подписи по kid. Неизвестный kid — повод перечитать jwks_uri (провайдер сменил ключи),
+// но не чаще раза в 10 секунд.
+func oidcKey(ctx context.Context, meta *oidcProviderMeta, kid string) (crypto.PublicKey, error) {
+	oidcCache.mu.Lock()
+	defer oidcCache.mu.Unlock()
+
+	lookup := func() (crypto.PublicKey, bool) {
+		if kid == "" && len(oidcCache.keys) == 1 {
+			for _, k := range oidcCache.keys {
+				return k, true
+			}
+		}
+		k, ok := oidcCache.keys[kid]
+		return k, ok
+	}
+	if k, ok := lookup(); ok {
+		return k, nil
+	}
+	if time.Since(oidcCache.keysFetched) < 10*time.Second {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := oidcGetJSON(ctx, meta.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, raw := range set.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			logging.Warnf("oidc jwks: skip key: %v", err)
+			continue
+		}
+		if key != nil {
+			keys[kid] = key
+		}
+	}
+	oidcCache.keys = keys
+	oidcCache.keysFetched = time.Now()
+
+	if k, ok := lookup(); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// parseJWK — открытый ключ RSA или EC из JWK; ключи не для подписи (use=enc) пропускаются (nil, nil).
+func parseJWK(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", nil, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return k.Kid, nil, nil
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64(k.N)
+		e, err2 := b64(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return "", nil, fmt.Errorf("kid %q: bad RSA key", k.Kid)
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return k.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("kid %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err1 := b64(k.X)
+		y, err2 := b64(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if err1 != nil || err2 != nil || len(x) != size || len(y) != size {
+			return "", nil, fmt.Errorf("kid %q: bad EC key", k.Kid)
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return "", nil, fmt.Errorf("kid %q: %w", k.Kid, err)
+		}
+		return k.Kid, key, nil
+	default:
+		return "", nil, fmt.Errorf("kid %q: unsupported key type %q", k.Kid, k.Kty)
+	}
+}
+
+// =============== id_token ===============
+
+// verifyJWS проверяет подпись компактного JWS и возвращает его claims.
+func verifyJWS(ctx context.Context, meta *oidcProviderMeta, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token: not a compact JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil {
+		return nil, errors.New("id_token: bad header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id_token: bad signature encoding")
+	}
+
+	// RS256, PS384, ES512, ...: семейство и размер хэша
+	if len(header.Alg) != 5 || !slices.Contains([]string{"RS", "PS", "ES"}, header.Alg[:2]) {
+		return nil, fmt.Errorf("id_token: unsupported alg %q", header.Alg)
+	}
+	family := header.Alg[:2]
+	hash, ok := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[header.Alg[2:]]
+	if !ok {
+		return nil, fmt.Errorf("id_token: unsupported alg %q", header.Alg)
+	}
+
+	key, err := oidcKey(ctx, meta, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	_, _ = h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch family {
+		case "RS":
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+		case "PS":
+			valid = rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if family == "ES" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(pub, digest, r, s)
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("id_token: bad signature (alg=%s kid=%q)", header.Alg, header.Kid)
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("id_token: bad payload encoding")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(pb)))
+	dec.UseNumber()
+	var claims map[string]any
+	if err := dec.Decode(&claims); err != nil {
+		return nil, errors.New("id_token: bad payload")
+	}
+	return claims, nil
+}
+
+// checkIDTokenClaims — iss, aud/azp, exp, iat и nonce по OpenID Connect Core 3.1.3.7.
+func checkIDTokenClaims(claims map[string]any, issuer, clientID, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != issuer {
+		return fmt.Errorf("id_token: iss %q is not %q", iss, issuer)
+	}
+	aud := claimStrings(claims, "aud")
+	if !slices.Contains(aud, clientID) {
+		return fmt.Errorf("id_token: aud %v does not contain client_id", aud)
+	}
+	// при нескольких aud токен должен быть выписан именно этому клиенту
+	azp, hasAzp := claims["azp"].(string)
+	if (hasAzp && azp != clientID) || (!hasAzp && len(aud) > 1) {
+		return fmt.Errorf("id_token: azp %q is not client_id", azp)
+	}
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		return errors.New("id_token: no exp")
+	}
+	if now.After(exp.Add(oidcClockSkew)) {
+		return fmt.Errorf("id_token: expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && iat.After(now.Add(oidcClockSkew)) {
+		return fmt.Errorf("id_token: issued in the future (%s)", iat.UTC().Format(time.RFC3339))
+	}
+	if got, _ := claims["nonce"].(string); !hmac.Equal([]byte(got), []byte(nonce)) {
+		return errors.New("id_token: nonce mismatch")
+	}
+	return nil
+}
+
+func claimTime(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// claimValue — значение claim по пути через точку ("realm_access.roles").
+func claimValue(claims map[string]any, path string) (any, bool) {
+	var cur any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// claimStrings — claim как список строк: строка — список из одного элемента.
+func claimStrings(claims map[string]any, path string) []string {
+	v, _ := claimValue(claims, path)
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func claimString(claims map[string]any, path string) string {
+	if v := claimStrings(claims, path); len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// =============== роли и пользователь ===============
+
+// oidcRole выбирает максимальную роль по значениям OIDC_ROLE_CLAIM.
+// Если значения ролей для OIDC не заданы: при настроенных LDAP-группах вход запрещён
+// (иначе OIDC открыл бы admin всем, кого LDAP пускает только по группам), без них — admin, как у LDAP.
+func oidcRole(values []string) Role {
+	c := getConfig()
+	if len(c.OIDCAdminRoles) == 0 && len(c.OIDCOperatorRoles) == 0 && len(c.OIDCViewerRoles) == 0 {
+		if roleMappingEnabled() {
+			logging.Warnf("oidcRole: LDAP role groups are set but OIDC_*_ROLES are not, OIDC login is denied")
+			return RoleNone
+		}
+		return RoleAdmin
+	}
+
+	mapping := []struct {
+		role Role
+		want []string
+	}{
+		{RoleAdmin, c.OIDCAdminRoles},
+		{RoleOperator, c.OIDCOperatorRoles},
+		{RoleViewer, c.OIDCViewerRoles},
+	}
+	for _, m := range mapping {
+		for _, want := range m.want {
+			for _, have := range values {
+				if strings.EqualFold(strings.TrimSpace(want), strings.TrimSpace(have)) {
+					return m.role
+				}
+			}
+		}
+	}
+	return RoleNone
+}
+
+// oidcVerifiedEmail — email из claims, только если провайдер его подтвердил (email_verified: true):
+// иначе пользователь, сам вписавший чужой адрес в профиль у провайдера, вошёл бы под чужой строкой users.
+func oidcVerifiedEmail(claims map[string]any) string {
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return ""
+	}
+	return claimString(claims, "email")
+}
+
+// oidcUserLogin — логин сессии для OIDC-пользователя: login строки users, совпавшей по логину
+// или (если по логину нет) по подтверждённому email (см. oidcVerifiedEmail);
+// без совпадений — нормализованный логин из claim.
+func oidcUserLogin(ctx context.Context, username, email string) (string, error) {
+	login := normalizeLogin(username)
+	conn, err := requireDB()
+	if err != nil {
+		return "", err
+	}
+
+	var found string
+	err = conn.QueryRowContext(ctx, `
+		SELECT login FROM users WHERE unicode_lower(login) = ? AND login <> '' ORDER BY active DESC, id LIMIT 1
+	`, login).Scan(&found)
+	if err == nil {
+		return normalizeLogin(found), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		err = conn.QueryRowContext(ctx, `
+			SELECT login FROM users WHERE unicode_lower(email) = ? AND login <> '' ORDER BY active DESC, id LIMIT 1
+		`, email).Scan(&found)
+		if err == nil {
+			return normalizeLogin(found), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+	return login, nil
+}
+
+// =============== состояние входа (cookie cp_oidc) ===============
+
+type oidcFlow struct {
+	State       string `json:"s"`
+	Nonce       string `json:"n"`
+	Verifier    string `json:"v"`
+	RedirectURI string `json:"r"`
+	Next        string `json:"next"`
+	Expires     int64  `json:"exp"`
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func oidcFlowMAC(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	_, _ = mac.Write([]byte("oidc:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setOIDCFlowCookie(w http.ResponseWriter, f oidcFlow) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    payload + "." + oidcFlowMAC(payload),
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // возврат от провайдера — переход верхнего уровня, Lax-cookie приходит
+		Secure:   getConfig().SessionCookieSecure,
+	})
+	return nil
+}
+
+func clearOIDCFlowCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: oidcCookiePath, MaxAge: -1, HttpOnly: true})
+}
+
+func readOIDCFlowCookie(r *http.Request) (oidcFlow, bool) {
+	c, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	payload, mac, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(oidcFlowMAC(payload))) {
+		return oidcFlow{}, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	var f oidcFlow
+	if json.Unmarshal(b, &f) != nil || time.Now().Unix() > f.Expires {
+		return oidcFlow{}, false
+	}
+	return f, true
+}
+
+// oidcRedirectURI — OIDC_REDIRECT_URL или адрес callback на хосте запроса.
+func oidcRedirectURI(r *http.Request) string {
+	if u := strings.TrimSpace(getConfig().OIDCRedirectURL); u != "" {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallbackPath
+}
+
+// =============== обмен code на токены ===============
+
+type oidcTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+func oidcExchange(ctx context.Context, meta *oidcProviderMeta, code string, f oidcFlow) (oidcTokens, error) {
+	c := getConfig()
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {f.RedirectURI},
+		"code_verifier": {f.Verifier},
+		"client_id":     {c.OIDCClientID},
+	}
+	// client_secret_basic — способ по умолчанию; post — если провайдер заявил только его
+	basic := c.OIDCClientSecret != "" && (len(meta.TokenAuthMethods) == 0 || slices.Contains(meta.TokenAuthMethods, "client_secret_basic"))
+	if c.OIDCClientSecret != "" && !basic {
+		form.Set("client_secret", c.OIDCClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcTokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(c.OIDCClientID), url.QueryEscape(c.OIDCClientSecret))
+	}
+
+	resp, err := oidcHTTPClient().Do(req)
+	if err != nil {
+		return oidcTokens{}, fmt.Errorf("oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return oidcTokens{}, fmt.Errorf("oidc token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return oidcTokens{}, fmt.Errorf("oidc token: %s %s %s", resp.Status, e.Error, e.Description)
+	}
+	var t oidcTokens
+	if err := json.Unmarshal(body, &t); err != nil {
+		return oidcTokens{}, fmt.Errorf("oidc token: %w", err)
+	}
+	if t.IDToken == "" {
+		return oidcTokens{}, errors.New("oidc token: no id_token in response (is the openid scope requested?)")
+	}
+	return t, nil
+}
+
+// oidcClaims — проверенные claims id_token, дополненные userinfo, если логина или ролей в id_token нет.
+func oidcClaims(ctx context.Context, meta *oidcProviderMeta, t oidcTokens, nonce string) (map[string]any, error) {
+	c := getConfig()
+	claims, err := verifyJWS(ctx, meta, t.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIDTokenClaims(claims, oidcIssuer(), c.OIDCClientID, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+
+	_, hasUser := claimValue(claims, c.OIDCUsernameClaim)
+	_, hasRoles := claimValue(claims, c.OIDCRoleClaim)
+	if (hasUser && hasRoles) || meta.UserinfoEndpoint == "" || t.AccessToken == "" {
+		return claims, nil
+	}
+
+	var info map[string]any
+	if err := oidcGetJSON(ctx, meta.UserinfoEndpoint, t.AccessToken, &info); err != nil {
+		return nil, fmt.Errorf("oidc userinfo: %w", err)
+	}
+	if sub, _ := info["sub"].(string); sub != claims["sub"] {
+		return nil, errors.New("oidc userinfo: sub does not match id_token")
+	}
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return claims, nil
+}
+
+// =============== HTTP ===============
+
+// handleLoginOptions — какие способы входа показать на странице входа (публичный, без сессии).
+func handleLoginOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, struct {
+		LDAP     bool   `json:"ldap"`
+		OIDC     bool   `json:"oidc"`
+		OIDCName string `json:"oidc_name,omitempty"`
+	}{
+		LDAP:     ldapEnabled(),
+		OIDC:     oidcEnabled(),
+		OIDCName: strings.TrimSpace(getConfig().OIDCProviderName),
+	})
+}
+
+// handleOIDCLogin начинает вход: state/nonce/PKCE в cookie и редирект к провайдеру.
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	next := safeNext(r.URL.Query().Get("next"))
+	if !oidcEnabled() {
+		redirectToLogin(w, r, next, "Вход через SSO не настроен")
+		return
+	}
+
+	meta, err := oidcProvider(r.Context())
+	if err != nil {
+		logging.Errorf("handleOIDCLogin: %v", err)
+		oidcLoginsTotal.WithLabelValues("error").Inc()
+		redirectToLogin(w, r, next, "Провайдер входа недоступен, попробуйте позже")
+		return
+	}
+
+	f := oidcFlow{RedirectURI: oidcRedirectURI(r), Next: next, Expires: time.Now().Add(oidcFlowTTL).Unix()}
+	for _, p := range []*string{&f.State, &f.Nonce, &f.Verifier} {
+		if *p, err = randomToken(); err != nil {
+			logging.Errorf("handleOIDCLogin: cannot generate state: %v", err)
+			redirectToLogin(w, r, next, "Ошибка авторизации, обратитесь к администратору")
+			return
+		}
+	}
+	if err := setOIDCFlowCookie(w, f); err != nil {
+		logging.Errorf("handleOIDCLogin: %v", err)
+		redirectToLogin(w, r, next, "Ошибка авторизации, обратитесь к администратору")
+		return
+	}
+
+	c := getConfig()
+	challenge := sha256.Sum256([]byte(f.Verifier))
+	scopes := slices.DeleteFunc(slices.Clone(c.OIDCScopes), func(s string) bool { return strings.TrimSpace(s) == "" })
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.OIDCClientID},
+		"redirect_uri":          {f.RedirectURI},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {f.State},
+		"nonce":                 {f.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	target := meta.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + q.Encode()
+	} else {
+		target += "?" + q.Encode()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback завершает вход: проверяет state, меняет code на токены и открывает сессию.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+
+	f, ok := readOIDCFlowCookie(r)
+	clearOIDCFlowCookie(w)
+	if !ok || q.Get("state") == "" || !hmac.Equal([]byte(q.Get("state")), []byte(f.State)) {
+		logging.Warnf("handleOIDCCallback: bad or expired state from %s", clientIP(r))
+		auditLogin(r, "loginFailed", "", RoleNone, http.StatusForbidden, "oidc_state")
+		redirectToLogin(w, r, f.Next, "Вход устарел, попробуйте ещё раз")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		logging.Warnf("handleOIDCCallback: provider returned %s: %s", e, q.Get("error_description"))
+		oidcLoginsTotal.WithLabelValues("failure").Inc()
+		auditLogin(r, "loginFailed", "", RoleNone, http.StatusUnauthorized, "oidc_"+e)
+		redirectToLogin(w, r, f.Next, "Провайдер отклонил вход")
+		return
+	}
+
+	fail := func(err error) {
+		logging.Errorf("handleOIDCCallback: %v", err)
+		oidcLoginsTotal.WithLabelValues("error").Inc()
+		auditLogin(r, "loginFailed", "", RoleNone, http.StatusInternalServerError, "oidc_error")
+		redirectToLogin(w, r, f.Next, "Ошибка авторизации, обратитесь к администратору")
+	}
+	meta, err := oidcProvider(r.Context())
+	if err != nil {
+		fail(err)
+		return
+	}
+	tokens, err := oidcExchange(r.Context(), meta, q.Get("code"), f)
+	if err != nil {
+		fail(err)
+		return
+	}
+	claims, err := oidcClaims(r.Context(), meta, tokens, f.Nonce)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	c := getConfig()
+	username := claimString(claims, c.OIDCUsernameClaim)
+	if username == "" {
+		fail(fmt.Errorf("claim %q is empty (sub=%v)", c.OIDCUsernameClaim, claims["sub"]))
+		return
+	}
+	login, err := oidcUserLogin(r.Context(), username, oidcVerifiedEmail(claims))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// AUTH_USERS — про того, кто вошёл у провайдера, а не про строку users, найденную по email
+	role := RoleNone
+	if authAllowed(username) {
+		role = oidcRole(claimStrings(claims, c.OIDCRoleClaim))
+	} else {
+		logging.Warnf("handleOIDCCallback: user %q (%s) is not in AUTH_USERS allowlist", username, c.OIDCUsernameClaim)
+	}
+	if role == RoleNone {
+		logging.Warnf("handleOIDCCallback: %q (%s=%q, claim %s=%v) has no access", login, c.OIDCUsernameClaim, username, c.OIDCRoleClaim, claimStrings(claims, c.OIDCRoleClaim))
+		oidcLoginsTotal.WithLabelValues("failure").Inc()
+		auditLogin(r, "loginFailed", login, RoleNone, http.StatusUnauthorized, "oidc_no_access")
+		redirectToLogin(w, r, f.Next, "У вас нет доступа к сервису")
+		return
+	}
+
+	if err := setAuthCookie(w, r, login, role); err != nil {
+		logging.Errorf("handleOIDCCallback: cannot create session for %q: %v", login, err)
+		auditLogin(r, "loginFailed", login, role, http.StatusInternalServerError, "session_error")
+		redirectToLogin(w, r, f.Next, "Ошибка авторизации, обратитесь к администратору")
+		return
+	}
+	logging.Infof("handleOIDCCallback: success for %q (%s=%q), role=%s", login, c.OIDCUsernameClaim, username, role)
+	oidcLoginsTotal.WithLabelValues("success").Inc()
+	auditLogin(r, "login", login, role, http.StatusOK, "")
+	http.Redirect(w, r, safeNext(f.Next), http.StatusFound)
+}
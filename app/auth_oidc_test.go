@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "onessa"
+
+// mockOIDC — провайдер OIDC на httptest: discovery, jwks с одним RSA-ключом и token endpoint,
+// который отдаёт id_token, подготовленный тестом.
+type mockOIDC struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		writeJSON(w, map[string]string{"access_token": "at", "id_token": m.idToken})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockOIDC) claims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                m.srv.URL,
+		"aud":                testOIDCClientID,
+		"sub":                "u-1",
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "ivanov",
+		"groups":             []string{"onessa-admins"},
+	}
+}
+
+func jwsPart(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signRS256 — id_token, подписанный key (RS256, kid k1).
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	input := jwsPart(t, map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + jwsPart(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// oidcTestLogin проходит вход целиком: /login/oidc, ответ провайдера с id_token от makeToken(nonce), callback.
+// Возвращает ответ callback.
+func oidcTestLogin(t *testing.T, m *mockOIDC, makeToken func(nonce string) string) *http.Response {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handleOIDCLogin(w, httptest.NewRequest("GET", "/login/oidc?next=/licenses", nil))
+	start := w.Result()
+	loc, err := url.Parse(start.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), m.srv.URL+"/authorize") {
+		t.Fatalf("login redirect: %q", start.Header.Get("Location"))
+	}
+
+	m.mu.Lock()
+	m.idToken = makeToken(loc.Query().Get("nonce"))
+	m.mu.Unlock()
+
+	r := httptest.NewRequest("GET", "/login/oidc/callback?code=c1&state="+url.QueryEscape(loc.Query().Get("state")), nil)
+	for _, c := range start.Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	handleOIDCCallback(w, r)
+	return w.Result()
+}
+
+// oidcSessionUser — логин открытой сессии или "", если вход не удался.
+func oidcSessionUser(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookieName && c.Value != "" {
+			sess, err := TouchSession(context.Background(), sessionIDFromToken(c.Value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sess.Username
+		}
+	}
+	return ""
+}
+
+func oidcTestConfig(m *mockOIDC) Config {
+	return Config{
+		SessionSecret:     "test-session-secret",
+		OIDCIssuer:        m.srv.URL,
+		OIDCClientID:      testOIDCClientID,
+		OIDCUsernameClaim: "preferred_username",
+		OIDCRoleClaim:     "groups",
+		OIDCAdminRoles:    []string{"onessa-admins"},
+	}
+}
+
+func TestOIDCCallbackVerifiesIDToken(t *testing.T) {
+	m := newMockOIDC(t)
+	newTestDB(t, oidcTestConfig(m))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&m.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	cases := []struct {
+		name      string
+		makeToken func(nonce string) string
+		ok        bool
+	}{
+		{"good signature", func(nonce string) string {
+			return signRS256(t, m.key, m.claims(nonce))
+		}, true},
+		{"bad signature", func(nonce string) string {
+			return signRS256(t, otherKey, m.claims(nonce))
+		}, false},
+		{"alg confusion: HS256 keyed with the public key", func(nonce string) string {
+			input := jwsPart(t, map[string]string{"alg": "HS256", "kid": "k1"}) + "." + jwsPart(t, m.claims(nonce))
+			mac := hmac.New(sha256.New, pubPEM)
+			_, _ = mac.Write([]byte(input))
+			return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+		}, false},
+		{"alg none", func(nonce string) string {
+			return jwsPart(t, map[string]string{"alg": "none"}) + "." + jwsPart(t, m.claims(nonce)) + "."
+		}, false},
+		{"nonce mismatch", func(nonce string) string {
+			return signRS256(t, m.key, m.claims(nonce+"x"))
+		}, false},
+		{"expired", func(nonce string) string {
+			c := m.claims(nonce)
+			c["exp"] = time.Now().Add(-oidcClockSkew - time.Minute).Unix()
+			return signRS256(t, m.key, c)
+		}, false},
+		{"wrong aud", func(nonce string) string {
+			c := m.claims(nonce)
+			c["aud"] = "another-client"
+			return signRS256(t, m.key, c)
+		}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := oidcTestLogin(t, m, c.makeToken)
+			loc := resp.Header.Get("Location")
+			user := oidcSessionUser(t, resp)
+			if c.ok && (loc != "/licenses" || user != "ivanov") {
+				t.Fatalf("want session for ivanov and redirect to /licenses, got %q, %q", user, loc)
+			}
+			if !c.ok && (user != "" || !strings.HasPrefix(loc, "/login?")) {
+				t.Fatalf("want rejected login, got session %q and redirect %q", user, loc)
+			}
+		})
+	}
+}
+
+func TestOIDCEmailFallbackRequiresVerifiedEmail(t *testing.T) {
+	m := newMockOIDC(t)
+	cfg := oidcTestConfig(m)
+	conn := newTestDB(t, cfg)
+	if _, err := conn.ExecContext(context.Background(),
+		`INSERT INTO users(identity, name, email, login) VALUES('ldap:ivanov', 'Иванов', 'ivanov@corp.test', 'ivanov')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// у провайдера другой логин, адрес совпадает со строкой users
+	login := func(verified any) string {
+		return oidcSessionUser(t, oidcTestLogin(t, m, func(nonce string) string {
+			c := m.claims(nonce)
+			c["preferred_username"] = "petrov"
+			c["email"] = "Ivanov@corp.test"
+			if verified != nil {
+				c["email_verified"] = verified
+			}
+			return signRS256(t, m.key, c)
+		}))
+	}
+	if got := login(nil); got != "petrov" {
+		t.Fatalf("no email_verified: session user %q, want petrov", got)
+	}
+	if got := login("true"); got != "petrov" {
+		t.Fatalf(`email_verified "true": session user %q, want petrov`, got)
+	}
+	if got := login(false); got != "petrov" {
+		t.Fatalf("email_verified false: session user %q, want petrov", got)
+	}
+	if got := login(true); got != "ivanov" {
+		t.Fatalf("email_verified true: session user %q, want ivanov", got)
+	}
+
+	// AUTH_USERS сверяется с логином провайдера, а не с найденной по email строкой
+	cfg.AuthUsers = []string{"ivanov"}
+	SetConfig(cfg)
+	if got := login(true); got != "" {
+		t.Fatalf("AUTH_USERS=ivanov let petrov in as %q", got)
+	}
+	cfg.AuthUsers = []string{"petrov"}
+	SetConfig(cfg)
+	if got := login(true); got != "ivanov" {
+		t.Fatalf("AUTH_USERS=petrov: session user %q, want ivanov", got)
+	}
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Role — уровень доступа пользователя. Роли упорядочены: каждая следующая включает права предыдущей.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func parseRole(s string) Role {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return RoleViewer
+	case "operator":
+		return RoleOperator
+	case "admin":
+		return RoleAdmin
+	default:
+		return RoleNone
+	}
+}
+
+// Principal — кто выполняет запрос (кладётся в context в authMiddleware).
+type Principal struct {
+	Username string
+	Role     Role
+	Scopes   []string // только у API-токенов (см. api_tokens.go); nil — сессия
+}
+
+type principalCtxKey struct{}
+
+func withPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func principalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
+
+// requireRole — middleware для роутов: пропускает только пользователей с ролью не ниже need.
+// Если авторизация выключена (не настроены ни LDAP, ни OIDC) — пропускает всех.
+func requireRole(need Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authEnabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p, ok := principalFrom(r.Context())
+			if !ok {
+				httpError(w, "требуется авторизация", http.StatusUnauthorized)
+				return
+			}
+			if p.Role < need {
+				logging.Warnf("requireRole: %q (role=%s) denied %s %s, need=%s", p.Username, p.Role, r.Method, r.URL.Path, need)
+				httpError(w, "недостаточно прав", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// roleMappingEnabled — заданы ли вообще группы для ролей.
+// Если нет — сохраняем старое поведение: любой вошедший пользователь получает admin.
+func roleMappingEnabled() bool {
+	c := getConfig()
+	return len(c.LDAPAdminGroups) > 0 || len(c.LDAPOperatorGroups) > 0 || len(c.LDAPViewerGroups) > 0
+}
+
+// roleFromGroups выбирает максимальную роль по списку DN групп пользователя.
+func roleFromGroups(groups []string) Role {
+	if !roleMappingEnabled() {
+		return RoleAdmin
+	}
+
+	c := getConfig()
+	mapping := []struct {
+		role Role
+		dns  []string
+	}{
+		{RoleAdmin, c.LDAPAdminGroups},
+		{RoleOperator, c.LDAPOperatorGroups},
+		{RoleViewer, c.LDAPViewerGroups},
+	}
+
+	for _, m := range mapping {
+		for _, want := range m.dns {
+			for _, have := range groups {
+				if sameDN(want, have) {
+					return m.role
+				}
+			}
+		}
+	}
+	return RoleNone
+}
+
+func sameDN(a, b string) bool {
+	a = strings.TrimSpace(a)
+	b = strings.TrimSpace(b)
+	if a == "" || b == "" {
+		return false
+	}
+	da, errA := ldap.ParseDN(a)
+	db, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return da.EqualFold(db)
+}
+
+// ldapUserGroups возвращает DN групп пользователя: memberOf из записи и,
+// если включено LDAP_NESTED_GROUPS, все группы по цепочке вложенности (AD LDAP_MATCHING_RULE_IN_CHAIN).
+func ldapUserGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groups := entry.GetAttributeValues("memberOf")
+	if !getConfig().LDAPNestedGroups {
+		return groups, nil
+	}
+
+	filter := fmt.Sprintf("(member:1.2.840.113556.1.4.1941:=%s)", ldap.EscapeFilter(entry.DN))
+	req := ldap.NewSearchRequest(
+		ldapCfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		filter,
+		[]string{"dn"},
+		nil,
+	)
+	sr, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("ldap nested groups search: %w", err)
+	}
+	for _, e := range sr.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+// handleMe отдаёт фронту текущего пользователя и его роль (чтобы прятать недоступные действия).
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, ok := principalFrom(r.Context())
+	if !ok {
+		// авторизация выключена
+		p = Principal{Username: "", Role: RoleAdmin}
+	}
+
+	writeJSON(w, struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}{
+		Username: p.Username,
+		Role:     p.Role.String(),
+	})
+}
@@ -0,0 +1,524 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Резервные копии SQLite.
+// Снимок делается через VACUUM INTO: SQLite пишет согласованную копию из одной транзакции чтения,
+// поэтому бэкап можно снимать на работающем сервере (в отличие от копирования файлов в WAL-режиме).
+// Копии лежат в <DATA_DIR>/backups; BACKUP_KEEP ограничивает их число, BACKUP_SCHEDULE включает бэкап по расписанию.
+// Для PostgreSQL бэкап делается штатными средствами (pg_dump) — здесь не поддерживается.
+
+const (
+	backupPrefix = "onessa-"
+	backupExt    = ".sqlite"
+)
+
+// BackupInfo — файл резервной копии.
+type BackupInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"created_at"`
+
+	modTime time.Time
+}
+
+func backupDir() string {
+	return filepath.Join(dataDir, "backups")
+}
+
+func requireSQLiteBackup() (*dbConn, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+	if conn.driver != driverSQLite {
+		return nil, errors.New("backup_unsupported: backups are supported only for sqlite, use pg_dump for postgres")
+	}
+	return conn, nil
+}
+
+// CreateBackup снимает копию текущей БД в <DATA_DIR>/backups и удаляет лишние старые копии (BACKUP_KEEP).
+// label попадает в имя файла, пустой — обычная копия. Во время восстановления БД не выполняется.
+func CreateBackup(ctx context.Context, label string) (BackupInfo, error) {
+	if !beginJob("backup") {
+		return BackupInfo{}, errRestoreInProgress
+	}
+	defer endJob("backup")
+	return createBackup(ctx, label)
+}
+
+// createBackup — CreateBackup без учёта фоновых задач (для копии "pre-restore" внутри восстановления).
+func createBackup(ctx context.Context, label string) (BackupInfo, error) {
+	conn, err := requireSQLiteBackup()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	dir := backupDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return BackupInfo{}, err
+	}
+
+	name := backupPrefix
+	if label != "" {
+		name += label + "-"
+	}
+	name += time.Now().UTC().Format("20060102-150405")
+	p := filepath.Join(dir, name+backupExt)
+	// VACUUM INTO не перезаписывает существующий файл
+	for i := 2; fileExists(p); i++ {
+		p = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, backupExt))
+	}
+
+	if err := vacuumInto(ctx, conn, p); err != nil {
+		return BackupInfo{}, err
+	}
+
+	info, err := backupFileInfo(p)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	logging.Infof("backup created: %s (%d bytes)", p, info.Size)
+
+	if keep := getConfig().BackupKeep; keep > 0 {
+		if err := pruneBackups(keep); err != nil {
+			logging.Warnf("backup retention: %v", err)
+		}
+	}
+	return info, nil
+}
+
+// BackupTo — CLI: onessa backup [file]. Открывает БД без миграций и снимает копию в path
+// (файл не должен существовать) или, если path пуст, в <DATA_DIR>/backups. Возвращает путь к копии.
+func BackupTo(ctx context.Context, cfg Config, path string) (string, error) {
+	SetConfig(cfg)
+	SetDataDir(cfg.DataDir)
+	conn, _, err := openDB(cfg.DataDir)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		setDB(nil)
+		_ = conn.Close()
+	}()
+	setDB(conn)
+
+	if path == "" {
+		info, err := CreateBackup(ctx, "")
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(backupDir(), info.Name), nil
+	}
+	if _, err := requireSQLiteBackup(); err != nil {
+		return "", err
+	}
+	if fileExists(path) {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	return path, vacuumInto(ctx, conn, path)
+}
+
+func vacuumInto(ctx context.Context, conn *dbConn, path string) error {
+	if _, err := conn.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("vacuum into %s: %w", path, err)
+	}
+	return nil
+}
+
+// ListBackups — копии в <DATA_DIR>/backups, новые первыми.
+func ListBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(backupDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []BackupInfo{}, nil
+		}
+		return nil, err
+	}
+
+	out := make([]BackupInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !isBackupName(e.Name()) {
+			continue
+		}
+		info, err := backupFileInfo(filepath.Join(backupDir(), e.Name()))
+		if err != nil {
+			continue
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].modTime.After(out[j].modTime)
+	})
+	return out, nil
+}
+
+// pruneBackups оставляет keep самых новых копий.
+func pruneBackups(keep int) error {
+	items, err := ListBackups()
+	if err != nil {
+		return err
+	}
+	for _, b := range items[min(keep, len(items)):] {
+		if err := os.Remove(filepath.Join(backupDir(), b.Name)); err != nil {
+			return err
+		}
+		logging.Infof("backup removed by retention: %s", b.Name)
+	}
+	return nil
+}
+
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExt) &&
+		filepath.Base(name) == name
+}
+
+// backupPath — путь к копии по имени из API (только файлы из каталога бэкапов).
+func backupPath(name string) (string, error) {
+	if !isBackupName(name) {
+		return "", errors.New("backup_not_found")
+	}
+	p := filepath.Join(backupDir(), name)
+	if !fileExists(p) {
+		return "", errors.New("backup_not_found")
+	}
+	return p, nil
+}
+
+func backupFileInfo(p string) (BackupInfo, error) {
+	st, err := os.Stat(p)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return BackupInfo{
+		Name:      filepath.Base(p),
+		Size:      st.Size(),
+		CreatedAt: st.ModTime().UTC().Format(time.RFC3339),
+		modTime:   st.ModTime(),
+	}, nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// =============== восстановление ===============
+
+// validateBackup проверяет, что файл — целая БД onessa со схемой не новее этой сборки.
+// Возвращает версию схемы копии (0 — копия, снятая до версионированных миграций).
+func validateBackup(ctx context.Context, path string) (int, error) {
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var check string
+	if err := conn.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&check); err != nil {
+		return 0, fmt.Errorf("invalid_backup: not a sqlite database: %w", err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("invalid_backup: integrity check failed: %s", check)
+	}
+
+	tables := map[string]bool{}
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type='table'`)
+	if err != nil {
+		return 0, fmt.Errorf("invalid_backup: %w", err)
+	}
+	names, err := scanStrings(rows)
+	if err != nil {
+		return 0, fmt.Errorf("invalid_backup: %w", err)
+	}
+	for _, n := range names {
+		tables[n] = true
+	}
+	if !tables["users"] || !tables["licenses"] {
+		return 0, errors.New("invalid_backup: not an onessa database")
+	}
+	if !tables["schema_migrations"] {
+		return 0, nil
+	}
+
+	version := 0
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("invalid_backup: %w", err)
+	}
+	if latest := migrations[len(migrations)-1].Version; version > latest {
+		return version, fmt.Errorf("invalid_backup: schema version %d is newer than this build supports (%d)", version, latest)
+	}
+	return version, nil
+}
+
+// RestoreResult — итог восстановления.
+type RestoreResult struct {
+	SchemaVersion int    `json:"schema_version"`
+	PreRestore    string `json:"pre_restore_backup"`
+}
+
+// RestoreBackup подменяет работающую БД копией из файла src (файл не изменяется).
+// Перед подменой копия проверяется, а текущая БД сохраняется в бэкап "pre-restore".
+// После подмены применяются недостающие миграции (копия могла быть снята более старой версией).
+// Пока идёт синхронизация LDAP или другая фоновая задача, восстановление отклоняется (restore_busy).
+func RestoreBackup(ctx context.Context, src string) (RestoreResult, error) {
+	if _, err := requireSQLiteBackup(); err != nil {
+		return RestoreResult{}, err
+	}
+	busy, ok := beginRestore()
+	if !ok {
+		return RestoreResult{}, fmt.Errorf("restore_busy: %s", strings.Join(busy, ", "))
+	}
+	defer endRestore()
+
+	live := sqlitePath(dataDir)
+	tmp, err := copyToTemp(src, filepath.Dir(live))
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	defer os.Remove(tmp)
+
+	version, err := validateBackup(ctx, tmp)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	// Ждём запросы, которые уже работают с БД (см. dbLease); новые ждут, пока откроется восстановленная.
+	dbLease.Lock()
+	defer dbLease.Unlock()
+
+	pre, err := createBackup(ctx, "pre-restore")
+	if err != nil {
+		return RestoreResult{}, fmt.Errorf("pre-restore backup: %w", err)
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if err := db.Close(); err != nil {
+		logging.Warnf("restore: close current db: %v", err)
+	}
+	if err := swapSQLiteFile(tmp, live); err != nil {
+		return RestoreResult{}, reopenAfterRestore(ctx, live, fmt.Errorf("swap database file: %w", err))
+	}
+	if err := reopenAfterRestore(ctx, live, nil); err != nil {
+		return RestoreResult{}, err
+	}
+
+	logging.Infof("database restored from %s (schema version %d), previous state saved to %s", filepath.Base(src), version, pre.Name)
+	return RestoreResult{SchemaVersion: version, PreRestore: pre.Name}, nil
+}
+
+// reopenAfterRestore открывает БД по пути live заново (вызывается под dbMu).
+func reopenAfterRestore(ctx context.Context, live string, cause error) error {
+	conn, _, err := openSQLite(dataDir)
+	if err == nil {
+		if _, err = applyMigrations(ctx, conn); err != nil {
+			_ = conn.Close()
+		}
+	}
+	if err != nil {
+		db, store = nil, &sqlStore{}
+		logging.Errorf("restore: cannot reopen %s: %v", live, err)
+		return errors.Join(cause, fmt.Errorf("reopen database: %w", err))
+	}
+	db, store = conn, &sqlStore{conn: conn}
+	return cause
+}
+
+// swapSQLiteFile заменяет файл БД; -wal/-shm от прежней БД к новой не относятся и удаляются.
+func swapSQLiteFile(src, live string) error {
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(live + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(src, live)
+}
+
+// copyToTemp копирует src во временный файл в dir (тот же раздел, что и БД, — чтобы rename был атомарным).
+func copyToTemp(src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	return writeTemp(in, dir)
+}
+
+func writeTemp(r io.Reader, dir string) (string, error) {
+	out, err := os.CreateTemp(dir, ".restore-*.sqlite")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// RestoreOffline — CLI: onessa restore <file>. Сервер должен быть остановлен.
+func RestoreOffline(ctx context.Context, cfg Config, src string) (RestoreResult, error) {
+	SetConfig(cfg)
+	if err := InitDB(cfg.DataDir); err != nil {
+		return RestoreResult{}, err
+	}
+	defer func() {
+		if conn := setDB(nil); conn != nil {
+			_ = conn.Close()
+		}
+	}()
+	return RestoreBackup(ctx, src)
+}
+
+// =============== HTTP ===============
+
+func handleBackupsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := ListBackups()
+	if err != nil {
+		httpError(w, "backup error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"items": items})
+}
+
+func handleBackupCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, err := CreateBackup(r.Context(), "")
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	logging.Infof("backup created by %q: %s", requestActor(r), info.Name)
+	auditTarget(r, "backup", info.Name, nil, info)
+	writeJSON(w, info)
+}
+
+func handleBackupDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := backupPath(chi.URLParam(r, "name"))
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(p)+`"`)
+	http.ServeFile(w, r, p)
+}
+
+const (
+	restorePath = "/api/admin/backups/restore"
+	// maxRestoreUpload — предел размера загружаемой копии.
+	maxRestoreUpload = 1 << 30
+	// restoreTimeout — сколько может идти восстановление: загрузка до 1 ГиБ, копия "pre-restore"
+	// через VACUUM INTO и миграции. Общий таймаут запросов (60 с) и таймауты сервера
+	// на чтение/запись к этому маршруту не применяются.
+	restoreTimeout = 30 * time.Minute
+)
+
+// handleBackupRestore: ?name=<файл из списка бэкапов> или тело запроса — файл SQLite (application/octet-stream).
+func handleBackupRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(restoreTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		logging.Warnf("restore: cannot extend read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		logging.Warnf("restore: cannot extend write deadline: %v", err)
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+	if restoreInProgress() {
+		writeBackupError(w, errRestoreInProgress)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	src := ""
+	if name != "" {
+		p, err := backupPath(name)
+		if err != nil {
+			writeBackupError(w, err)
+			return
+		}
+		src = p
+	} else {
+		if _, err := requireSQLiteBackup(); err != nil {
+			writeBackupError(w, err)
+			return
+		}
+		p, err := writeTemp(http.MaxBytesReader(w, r.Body, maxRestoreUpload), dataDir)
+		if err != nil {
+			httpError(w, "не удалось прочитать файл: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer os.Remove(p)
+		src = p
+		name = "upload"
+	}
+
+	res, err := RestoreBackup(ctx, src)
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	logging.Infof("database restored by %q from %s", requestActor(r), name)
+	auditTarget(r, "backup", name, nil, res)
+	writeJSON(w, map[string]any{"status": "ok", "schema_version": res.SchemaVersion, "pre_restore_backup": res.PreRestore})
+}
+
+func writeBackupError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "backup_not_found"):
+		httpError(w, "бэкап не найден", http.StatusNotFound)
+	case strings.Contains(msg, "backup_unsupported"):
+		httpError(w, "бэкап поддерживается только для SQLite; для PostgreSQL используйте pg_dump", http.StatusNotImplemented)
+	case strings.Contains(msg, "restore_busy"):
+		httpError(w, "восстановление невозможно, пока выполняются фоновые задачи: "+strings.TrimPrefix(msg, "restore_busy: "), http.StatusConflict)
+	case strings.Contains(msg, "restore_in_progress"):
+		httpError(w, "идёт восстановление БД из бэкапа, повторите позже", http.StatusConflict)
+	case strings.Contains(msg, "invalid_backup"):
+		httpError(w, msg, http.StatusBadRequest)
+	default:
+		httpError(w, "backup error: "+msg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Защита от CSRF для запросов с cookie сессии (API-токены её не требуют: браузер их сам не подставляет).
+// Double-submit, привязанный к сессии: в cookie cp_csrf (доступна JS) лежит HMAC от токена сессии,
+// фронт передаёт её в заголовке X-CSRF-Token, сервер сверяет заголовок с HMAC от cp_session.
+// Чужой сайт не может ни прочитать cp_csrf, ни вычислить её без SESSION_SECRET.
+// Дополнительно Origin (или Referer, если Origin нет) должен совпадать с адресом сервиса
+// или одним из CSRF_TRUSTED_ORIGINS. Форма входа защищена так же: до входа в cp_csrf — случайное значение,
+// форма отправляет его в поле csrf_token.
+
+const (
+	csrfCookieName = "cp_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// csrfTokenFor — CSRF-токен сессии.
+func csrfTokenFor(sessionToken string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	_, _ = mac.Write([]byte("csrf:" + sessionToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setCSRFCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: false, // значение читает фронт (layout.js, login.html)
+		SameSite: http.SameSiteLaxMode,
+		Secure:   getConfig().SessionCookieSecure,
+	})
+}
+
+// ensureCSRFCookie выставляет cp_csrf текущей сессии, если в браузере её нет или она от прежней сессии.
+func ensureCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return
+	}
+	want := csrfTokenFor(c.Value)
+	if got, err := r.Cookie(csrfCookieName); err == nil && got.Value == want {
+		return
+	}
+	setCSRFCookie(w, want, int(sessionTTL.Seconds()))
+}
+
+// ensureLoginCSRFCookie — случайное значение cp_csrf для формы входа (до входа сессии нет).
+func ensureLoginCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		return
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logging.Errorf("csrf: cannot generate login token: %v", err)
+		return
+	}
+	setCSRFCookie(w, base64.RawURLEncoding.EncodeToString(b), 0)
+}
+
+// sameOrigin — Origin (или Referer) запроса указывает на этот сервис. Без обоих заголовков — true:
+// так ходят не браузеры, а скрипты, и от них защищает сам токен.
+func sameOrigin(r *http.Request) bool {
+	src := r.Header.Get("Origin")
+	if src == "" {
+		src = r.Header.Get("Referer")
+		if src == "" {
+			return true
+		}
+	}
+	u, err := url.Parse(src)
+	if err != nil || u.Host == "" {
+		return false // в том числе Origin: null
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	return slices.ContainsFunc(getConfig().CSRFTrustedOrigins, func(o string) bool {
+		return strings.ToLower(strings.TrimRight(strings.TrimSpace(o), "/")) == origin
+	})
+}
+
+// requireCSRF — middleware для роутов /api: изменяющие запросы с cookie сессии — только с X-CSRF-Token
+// этой сессии и со своего Origin.
+func requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, ok := principalFrom(r.Context())
+		c, err := r.Cookie(sessionCookieName)
+		if !ok || p.Scopes != nil || err != nil || c.Value == "" {
+			// авторизация выключена или запрос по API-токену
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !sameOrigin(r) {
+			logging.Warnf("requireCSRF: %q %s %s from foreign origin (Origin=%q, Referer=%q)",
+				p.Username, r.Method, r.URL.Path, r.Header.Get("Origin"), r.Header.Get("Referer"))
+			httpError(w, "запрос с чужого сайта отклонён", http.StatusForbidden)
+			return
+		}
+		if got := r.Header.Get(csrfHeaderName); !hmac.Equal([]byte(got), []byte(csrfTokenFor(c.Value))) {
+			logging.Warnf("requireCSRF: %q %s %s without valid %s", p.Username, r.Method, r.URL.Path, csrfHeaderName)
+			httpError(w, "нет или неверный CSRF-токен: обновите страницу", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkLoginCSRF — форма входа: поле csrf_token совпадает с cookie cp_csrf, Origin — свой.
+func checkLoginCSRF(r *http.Request) bool {
+	if !sameOrigin(r) {
+		return false
+	}
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(r.Form.Get(csrfFormField)), []byte(c.Value))
+}
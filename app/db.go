@@ -3,13 +3,18 @@ package app
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
 
 	"github.com/ryantrue/onessa/internal/logging"
 )
@@ -32,6 +37,12 @@ type UserFull struct {
 	Active bool   `json:"active"`
 }
 
+// ManualUserImport — строка ручного импорта пользователей (когда LDAP не настроен).
+type ManualUserImport struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
 type Computer struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
@@ -39,12 +50,48 @@ type Computer struct {
 	Description string `json:"description"`
 }
 
+// ComputerFull — ПК с источником и признаком активности (карточка ПК, в т.ч. деактивированного).
+type ComputerFull struct {
+	Computer
+	Source string `json:"source"`
+	Active bool   `json:"active"`
+}
+
 type License struct {
 	ID             int    `json:"id"`
 	Key            string `json:"key"`
 	AssignedUserID int    `json:"assigned_user_id"`
 	Comment        string `json:"comment"`
 	PC             string `json:"pc"`
+	// ПК из справочника computers (0 — не сопоставлен; тогда pc — свободный текст старых записей).
+	ComputerID int `json:"computer_id"`
+	// Продукт и срок действия: CSP / TLS / OCSP / TSP, версия, perpetual|term, даты в формате YYYY-MM-DD.
+	Product      string `json:"product"`
+	Version      string `json:"version"`
+	LicenseType  string `json:"license_type"`
+	PurchaseDate string `json:"purchase_date"`
+	ExpiresAt    string `json:"expires_at"`
+	// Лицензия помечена на разбор политикой reclaim (держатель деактивирован).
+	ReclaimFlaggedAt string `json:"reclaim_flagged_at,omitempty"`
+	// Имя назначенного пользователя (для списка и поиска по нему).
+	AssignedUserName string `json:"assigned_user_name,omitempty"`
+}
+
+// LicenseEvent — запись истории изменений лицензии (кто, когда и что поменял).
+type LicenseEvent struct {
+	ID          int    `json:"id"`
+	LicenseID   int    `json:"license_id"`
+	Action      string `json:"action"`
+	Actor       string `json:"actor"`
+	OldUserID   int    `json:"old_user_id"`
+	OldUserName string `json:"old_user_name"`
+	NewUserID   int    `json:"new_user_id"`
+	NewUserName string `json:"new_user_name"`
+	OldPC       string `json:"old_pc"`
+	NewPC       string `json:"new_pc"`
+	OldComment  string `json:"old_comment"`
+	NewComment  string `json:"new_comment"`
+	CreatedAt   string `json:"created_at"`
 }
 
 type Meeting struct {
@@ -64,119 +111,140 @@ type MeetingsState struct {
 	Items      []Meeting `json:"items"`
 }
 
-// =============== SQLite ===============
+// =============== БД ===============
 
 var (
 	dataDir string
-	db      *sql.DB
+
+	// dbMu защищает db и store: восстановление из бэкапа подменяет соединение на лету.
+	dbMu sync.RWMutex
+	db   *dbConn
 )
 
 func SetDataDir(dir string) {
 	dataDir = dir
 }
 
-// InitDB открывает SQLite (по умолчанию: <DATA_DIR>/cryptopro.sqlite) и прогоняет миграции.
+// InitDB открывает БД (DB_DRIVER: sqlite — по умолчанию <DATA_DIR>/onessa.sqlite, postgres — DB_DSN)
+// и применяет миграции схемы.
 func InitDB(dir string) error {
 	SetDataDir(dir)
 
-	// Источник правды — Config.DBPath. На всякий случай читаем и из env напрямую,
-	// чтобы не ломать старые деплои, где DB_PATH используется без env.Parse.
+	conn, where, err := openDB(dir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := applyMigrations(context.Background(), conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	setDB(conn)
+	logging.Infof("%s initialized: %s", conn.driver, where)
+	return nil
+}
+
+// setDB подменяет текущее соединение и возвращает прежнее (его закрывает вызывающий).
+func setDB(conn *dbConn) (prev *dbConn) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	prev = db
+	db = conn
+	store = &sqlStore{conn: conn}
+	return prev
+}
+
+// openDB открывает БД по DB_DRIVER; миграции не применяет.
+// where — расположение БД для логов (путь к файлу SQLite или хост/база PostgreSQL, без пароля).
+func openDB(dir string) (conn *dbConn, where string, err error) {
+	cfg := getConfig()
+	switch strings.ToLower(strings.TrimSpace(cfg.DBDriver)) {
+	case "", driverSQLite:
+		return openSQLite(dir)
+	case driverPostgres, "postgresql", "pgx":
+		return openPostgres(cfg.DBDSN)
+	default:
+		return nil, "", fmt.Errorf("unknown DB_DRIVER %q (sqlite or postgres)", cfg.DBDriver)
+	}
+}
+
+// sqlitePath — путь к файлу SQLite: DB_PATH (относительный — от DATA_DIR) или <DATA_DIR>/onessa.sqlite.
+func sqlitePath(dir string) string {
 	p := strings.TrimSpace(getConfig().DBPath)
 	if p == "" {
-		p = filepath.Join(dir, "onessa.sqlite")
-	} else if !filepath.IsAbs(p) {
-		p = filepath.Join(dir, p)
+		return filepath.Join(dir, "onessa.sqlite")
 	}
+	if !filepath.IsAbs(p) {
+		return filepath.Join(dir, p)
+	}
+	return p
+}
+
+func init() {
+	// lower() в SQLite меняет регистр только у ASCII. Для поиска, сортировки списков и сравнений
+	// без учёта регистра (в том числе по кириллице) запросы приложения вызывают unicode_lower —
+	// strings.ToLower, как lower() в PostgreSQL (там unicode_lower создаёт ensureSQLFunctions).
+	// Встроенный lower() не подменяется.
+	sqlite.MustRegisterDeterministicScalarFunction("unicode_lower", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if v, ok := args[0].(string); ok {
+			return strings.ToLower(v), nil
+		}
+		return args[0], nil
+	})
+}
+
+func openSQLite(dir string) (*dbConn, string, error) {
+	p := sqlitePath(dir)
+
+	// разумные настройки; WAL полезен для параллельных чтений.
+	// foreign_keys и busy_timeout действуют на одно соединение, поэтому задаются в DSN (_pragma):
+	// modernc.org/sqlite выполняет их на каждом соединении пула, а не только на первом.
+	q := url.Values{"_pragma": {"foreign_keys(1)", "journal_mode(WAL)", "busy_timeout(5000)"}}
 
 	// modernc.org/sqlite: driver name "sqlite"
-	conn, err := sql.Open("sqlite", p)
+	conn, err := sql.Open("sqlite", p+"?"+q.Encode())
 	if err != nil {
-		return fmt.Errorf("open sqlite: %w", err)
+		return nil, "", fmt.Errorf("open sqlite: %w", err)
+	}
+	if err := conn.Ping(); err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("open sqlite: %w", err)
 	}
+	return &dbConn{DB: conn, driver: driverSQLite}, p, nil
+}
 
-	// разумные настройки; WAL полезен для параллельных чтений
-	pragmas := []string{
-		"PRAGMA foreign_keys = ON;",
-		"PRAGMA journal_mode = WAL;",
-		"PRAGMA busy_timeout = 5000;",
+// openPostgres подключается к PostgreSQL (pgx). Несколько реплик приложения могут работать с одной БД.
+func openPostgres(dsn string) (*dbConn, string, error) {
+	dsn = strings.TrimSpace(dsn)
+	if dsn == "" {
+		return nil, "", errors.New("DB_DSN is required for DB_DRIVER=postgres")
 	}
-	for _, q := range pragmas {
-		if _, e := conn.Exec(q); e != nil {
-			_ = conn.Close()
-			return fmt.Errorf("sqlite pragma error (%s): %w", q, e)
-		}
+	pc, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad DB_DSN: %w", err)
 	}
+	where := fmt.Sprintf("%s:%d/%s", pc.Host, pc.Port, pc.Database)
 
-	if err := migrate(conn); err != nil {
-		_ = conn.Close()
-		return err
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("open postgres: %w", err)
 	}
+	conn.SetMaxOpenConns(20)
+	conn.SetConnMaxIdleTime(5 * time.Minute)
 
-	db = conn
-	logging.Infof("sqlite initialized: %s", p)
-	return nil
-}
-
-func migrate(conn *sql.DB) error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			identity TEXT NOT NULL UNIQUE,
-			name TEXT NOT NULL DEFAULT '',
-			email TEXT NOT NULL DEFAULT '',
-			login TEXT NOT NULL DEFAULT '',
-			source TEXT NOT NULL DEFAULT 'manual',
-			active INTEGER NOT NULL DEFAULT 1,
-			updated_at TEXT NOT NULL DEFAULT ''
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_users_active ON users(active);`,
-		`CREATE TABLE IF NOT EXISTS computers (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			identity TEXT NOT NULL UNIQUE,
-			name TEXT NOT NULL DEFAULT '',
-			dns_host_name TEXT NOT NULL DEFAULT '',
-			description TEXT NOT NULL DEFAULT '',
-			source TEXT NOT NULL DEFAULT 'manual',
-			active INTEGER NOT NULL DEFAULT 1,
-			updated_at TEXT NOT NULL DEFAULT ''
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_computers_active ON computers(active);`,
-		`CREATE TABLE IF NOT EXISTS licenses (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key TEXT NOT NULL UNIQUE,
-			assigned_user_id INTEGER NULL,
-			comment TEXT NOT NULL DEFAULT '',
-			pc TEXT NOT NULL DEFAULT '',
-			created_at TEXT NOT NULL DEFAULT '',
-			FOREIGN KEY(assigned_user_id) REFERENCES users(id) ON DELETE SET NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS meetings_meta (
-			id INTEGER PRIMARY KEY CHECK (id = 1),
-			exported_at TEXT NOT NULL DEFAULT ''
-		);`,
-		`INSERT OR IGNORE INTO meetings_meta(id, exported_at) VALUES (1, '');`,
-		`CREATE TABLE IF NOT EXISTS meetings (
-			id TEXT PRIMARY KEY,
-			subject TEXT NOT NULL DEFAULT '',
-			start TEXT NOT NULL DEFAULT '',
-			end TEXT NOT NULL DEFAULT '',
-			location TEXT NOT NULL DEFAULT '',
-			is_recurring INTEGER NOT NULL DEFAULT 0,
-			is_canceled INTEGER NOT NULL DEFAULT 0,
-			link TEXT NOT NULL DEFAULT '',
-			participants TEXT NOT NULL DEFAULT ''
-		);`,
-	}
-
-	for _, s := range stmts {
-		if _, err := conn.Exec(s); err != nil {
-			return fmt.Errorf("sqlite migrate error: %w (sql=%s)", err, s)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("postgres ping (%s): %w", where, err)
 	}
-	return nil
+	return &dbConn{DB: conn, driver: driverPostgres}, where, nil
 }
 
-func requireDB() (*sql.DB, error) {
+func requireDB() (*dbConn, error) {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
 	if db == nil {
 		return nil, errors.New("db is not initialized")
 	}
@@ -185,8 +253,8 @@ func requireDB() (*sql.DB, error) {
 
 // =============== USERS ===============
 
-func ListUsers(ctx context.Context) ([]User, error) {
-	conn, err := requireDB()
+func (s *sqlStore) ListUsers(ctx context.Context) ([]User, error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return nil, err
 	}
@@ -207,70 +275,141 @@ func ListUsers(ctx context.Context) ([]User, error) {
 	return out, rows.Err()
 }
 
+// UserFilter — фильтры полного списка пользователей (пустые поля не применяются).
+type UserFilter struct {
+	Search string // подстрока имени, email или логина
+	Active *bool
+	Source string // ldap | manual
+}
+
+// userSorts — ?sort= для /api/users/all; по умолчанию активные сверху, затем по имени.
+var userSorts = listSorts{
+	"":      {{expr: "active", numeric: true, desc: true}, {expr: "unicode_lower(name)"}, {expr: "unicode_lower(email)"}, idSortKey},
+	"name":  {{expr: "unicode_lower(name)"}, idSortKey},
+	"email": {{expr: "unicode_lower(email)"}, idSortKey},
+	"login": {{expr: "unicode_lower(login)"}, idSortKey},
+	"id":    {idSortKey},
+}
+
 // ListUsersAll отдаёт полный список пользователей (active + inactive),
 // чтобы фронт мог показывать историю/старые привязки.
-func ListUsersAll(ctx context.Context) ([]UserFull, error) {
-	conn, err := requireDB()
+func (s *sqlStore) ListUsersAll(ctx context.Context, f UserFilter, p ListParams) ([]UserFull, Page, error) {
+	conn, err := s.requireConn()
 	if err != nil {
-		return nil, err
+		return nil, Page{}, err
 	}
-	rows, err := conn.QueryContext(ctx, `
-		SELECT id, name, email, login, source, active
-		FROM users
-		ORDER BY active DESC, name, email, id
-	`)
-	if err != nil {
-		return nil, err
+
+	q := listQuery{cols: "id, name, email, login, source, active", from: "users"}
+	if strings.TrimSpace(f.Search) != "" {
+		cond, args := searchCond(f.Search, "name", "email", "login")
+		q.where = append(q.where, cond)
+		q.args = append(q.args, args...)
+	}
+	if f.Active != nil {
+		q.where = append(q.where, "active = ?")
+		q.args = append(q.args, boolToInt(*f.Active))
+	}
+	if v := strings.TrimSpace(f.Source); v != "" {
+		q.where = append(q.where, "source = ?")
+		q.args = append(q.args, strings.ToLower(v))
 	}
-	defer rows.Close()
 
 	var out []UserFull
-	for rows.Next() {
+	page, err := q.run(ctx, conn, userSorts, p, func(rows *sql.Rows, keys []any) error {
 		var u UserFull
 		var activeInt int
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Login, &u.Source, &activeInt); err != nil {
-			return nil, err
+		if err := rows.Scan(append([]any{&u.ID, &u.Name, &u.Email, &u.Login, &u.Source, &activeInt}, keys...)...); err != nil {
+			return err
 		}
 		u.Active = activeInt != 0
 		out = append(out, u)
-	}
-	return out, rows.Err()
+		return nil
+	})
+	return out, page, err
+}
+
+// ComputerFilter — фильтры списка ПК.
+type ComputerFilter struct {
+	Search string // подстрока имени, DNS-имени или описания
+}
+
+// computerSorts — ?sort= для /api/computers; по умолчанию по имени.
+var computerSorts = listSorts{
+	"":              {{expr: "unicode_lower(name)"}, idSortKey},
+	"name":          {{expr: "unicode_lower(name)"}, idSortKey},
+	"dns_host_name": {{expr: "unicode_lower(dns_host_name)"}, idSortKey},
+	"id":            {idSortKey},
 }
 
 // ListComputers отдаёт список ПК из БД (active=1).
-func ListComputers(ctx context.Context) ([]Computer, error) {
-	conn, err := requireDB()
+func (s *sqlStore) ListComputers(ctx context.Context, f ComputerFilter, p ListParams) ([]Computer, Page, error) {
+	conn, err := s.requireConn()
 	if err != nil {
-		return nil, err
+		return nil, Page{}, err
 	}
-	rows, err := conn.QueryContext(ctx, `
-		SELECT id, name, dns_host_name, description
-		FROM computers
-		WHERE active=1
-		ORDER BY name, id
-	`)
-	if err != nil {
-		return nil, err
+
+	q := listQuery{cols: "id, name, dns_host_name, description", from: "computers", where: []string{"active=1"}}
+	if strings.TrimSpace(f.Search) != "" {
+		cond, args := searchCond(f.Search, "name", "dns_host_name", "description")
+		q.where = append(q.where, cond)
+		q.args = append(q.args, args...)
 	}
-	defer rows.Close()
 
 	var out []Computer
-	for rows.Next() {
+	page, err := q.run(ctx, conn, computerSorts, p, func(rows *sql.Rows, keys []any) error {
 		var c Computer
-		if err := rows.Scan(&c.ID, &c.Name, &c.DNSHostName, &c.Description); err != nil {
-			return nil, err
+		if err := rows.Scan(append([]any{&c.ID, &c.Name, &c.DNSHostName, &c.Description}, keys...)...); err != nil {
+			return err
 		}
 		out = append(out, c)
+		return nil
+	})
+	return out, page, err
+}
+
+// GetUser — пользователь по id (в т.ч. неактивный); "user_not_found", если его нет.
+func (s *sqlStore) GetUser(ctx context.Context, id int) (UserFull, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return UserFull{}, err
 	}
-	return out, rows.Err()
+	var u UserFull
+	var activeInt int
+	err = conn.QueryRowContext(ctx, `SELECT id, name, email, login, source, active FROM users WHERE id=?`, id).
+		Scan(&u.ID, &u.Name, &u.Email, &u.Login, &u.Source, &activeInt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserFull{}, fmt.Errorf("user_not_found")
+		}
+		return UserFull{}, err
+	}
+	u.Active = activeInt != 0
+	return u, nil
 }
 
-// ImportManualUsersUpsert — импорт/обновление пользователей из JSON (fallback, когда LDAP не настроен).
-func ImportManualUsersUpsert(ctx context.Context, in []struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
-}) (imported int, warnings []string, err error) {
-	conn, err := requireDB()
+// GetComputer — ПК по id (в т.ч. деактивированный); "computer_not_found", если его нет.
+func (s *sqlStore) GetComputer(ctx context.Context, id int) (ComputerFull, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return ComputerFull{}, err
+	}
+	var c ComputerFull
+	var activeInt int
+	err = conn.QueryRowContext(ctx, `SELECT id, name, dns_host_name, description, source, active FROM computers WHERE id=?`, id).
+		Scan(&c.ID, &c.Name, &c.DNSHostName, &c.Description, &c.Source, &activeInt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ComputerFull{}, fmt.Errorf("computer_not_found")
+		}
+		return ComputerFull{}, err
+	}
+	c.Active = activeInt != 0
+	return c, nil
+}
+
+// ImportManualUsers — импорт/обновление пользователей из JSON (fallback, когда LDAP не настроен).
+func (s *sqlStore) ImportManualUsers(ctx context.Context, in []ManualUserImport) (imported int, warnings []string, err error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return 0, nil, err
 	}
@@ -328,14 +467,14 @@ func ImportManualUsersUpsert(ctx context.Context, in []struct {
 }
 
 // UpsertLDAPUser — внутренняя утилита для LDAP-синхронизации.
-func UpsertLDAPUser(ctx context.Context, tx *sql.Tx, login, name, email string) error {
+func UpsertLDAPUser(ctx context.Context, tx *dbTx, login, name, email string) error {
 	login = strings.TrimSpace(login)
 	name = strings.TrimSpace(name)
 	email = strings.ToLower(strings.TrimSpace(email))
 	if login == "" {
 		return errors.New("empty ldap login")
 	}
-	identity := "ldap:" + strings.ToLower(login)
+	identity := ldapUserIdentity(login)
 	now := time.Now().UTC().Format(time.RFC3339)
 
 	_, err := tx.ExecContext(ctx, `
@@ -347,29 +486,40 @@ func UpsertLDAPUser(ctx context.Context, tx *sql.Tx, login, name, email string)
 			login=excluded.login,
 			active=1,
 			updated_at=excluded.updated_at
+		WHERE users.name IS DISTINCT FROM excluded.name
+			OR users.email IS DISTINCT FROM excluded.email
+			OR users.login IS DISTINCT FROM excluded.login
+			OR users.active <> 1
 	`, identity, name, email, login, now)
 	return err
 }
 
-// MarkAllLDAPUsersInactive — перед синхронизацией: деактивируем всех LDAP-пользователей.
-func MarkAllLDAPUsersInactive(ctx context.Context, tx *sql.Tx) (int, error) {
-	res, err := tx.ExecContext(ctx, `UPDATE users SET active=0 WHERE source='ldap'`)
-	if err != nil {
-		return 0, err
-	}
-	a, _ := res.RowsAffected()
-	return int(a), nil
+// DeactivateLDAPUsersExcept — после полной синхронизации: деактивируем LDAP-пользователей,
+// которых нет в новой выборке. updated_at меняется только у реально деактивированных.
+// Возвращает identity деактивированных.
+func DeactivateLDAPUsersExcept(ctx context.Context, tx *dbTx, seen []string) ([]string, error) {
+	return deactivateLDAPExcept(ctx, tx, "users", seen)
+}
+
+// DeactivateLDAPUsers — деактивация конкретных LDAP-пользователей (для инкрементальной синхронизации).
+func DeactivateLDAPUsers(ctx context.Context, tx *dbTx, identities []string) ([]string, error) {
+	return deactivateLDAPIdentities(ctx, tx, "users", identities)
+}
+
+// ActiveLDAPUserIdentities — identity LDAP-пользователей, которые сейчас active=1.
+func ActiveLDAPUserIdentities(ctx context.Context, tx *dbTx) (map[string]struct{}, error) {
+	return activeLDAPIdentities(ctx, tx, "users")
 }
 
 // UpsertLDAPComputer — внутренняя утилита для LDAP-синхронизации ПК.
-func UpsertLDAPComputer(ctx context.Context, tx *sql.Tx, name, dnsHostName, description string) error {
+func UpsertLDAPComputer(ctx context.Context, tx *dbTx, name, dnsHostName, description string) error {
 	name = strings.TrimSpace(name)
 	dnsHostName = strings.TrimSpace(dnsHostName)
 	description = strings.TrimSpace(description)
 	if name == "" {
 		return errors.New("empty computer name")
 	}
-	identity := "ldap:" + strings.ToLower(name)
+	identity := ldapComputerIdentity(name)
 	now := time.Now().UTC().Format(time.RFC3339)
 
 	_, err := tx.ExecContext(ctx, `
@@ -381,162 +531,790 @@ func UpsertLDAPComputer(ctx context.Context, tx *sql.Tx, name, dnsHostName, desc
 			description=excluded.description,
 			active=1,
 			updated_at=excluded.updated_at
+		WHERE computers.name IS DISTINCT FROM excluded.name
+			OR computers.dns_host_name IS DISTINCT FROM excluded.dns_host_name
+			OR computers.description IS DISTINCT FROM excluded.description
+			OR computers.active <> 1
 	`, identity, name, dnsHostName, description, now)
 	return err
 }
 
-// MarkAllLDAPComputersInactive — перед синхронизацией: деактивируем все LDAP-компьютеры.
-func MarkAllLDAPComputersInactive(ctx context.Context, tx *sql.Tx) (int, error) {
-	res, err := tx.ExecContext(ctx, `UPDATE computers SET active=0 WHERE source='ldap'`)
+// DeactivateLDAPComputersExcept — после полной синхронизации: деактивируем отсутствующие в выборке ПК.
+func DeactivateLDAPComputersExcept(ctx context.Context, tx *dbTx, seen []string) ([]string, error) {
+	return deactivateLDAPExcept(ctx, tx, "computers", seen)
+}
+
+// DeactivateLDAPComputers — деактивация конкретных LDAP-компьютеров (для инкрементальной синхронизации).
+func DeactivateLDAPComputers(ctx context.Context, tx *dbTx, identities []string) ([]string, error) {
+	return deactivateLDAPIdentities(ctx, tx, "computers", identities)
+}
+
+// ActiveLDAPComputerIdentities — identity LDAP-компьютеров, которые сейчас active=1.
+func ActiveLDAPComputerIdentities(ctx context.Context, tx *dbTx) (map[string]struct{}, error) {
+	return activeLDAPIdentities(ctx, tx, "computers")
+}
+
+// deactivateLDAPExcept складывает увиденные identity во временную таблицу
+// (каталог может быть большим — NOT IN по списку параметров не подходит).
+// table — только константы "users"/"computers".
+func deactivateLDAPExcept(ctx context.Context, tx *dbTx, table string, seen []string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE IF NOT EXISTS ldap_seen (identity TEXT PRIMARY KEY)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ldap_seen`); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO ldap_seen(identity) VALUES(?) ON CONFLICT DO NOTHING`)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, id := range seen {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE `+table+` SET active=0, updated_at=?
+		WHERE source='ldap' AND active=1 AND identity NOT IN (SELECT identity FROM ldap_seen)
+		RETURNING identity
+	`, now)
+	if err != nil {
+		return nil, err
 	}
-	a, _ := res.RowsAffected()
-	return int(a), nil
+	return scanStrings(rows)
 }
 
-// =============== LICENSES ===============
+func deactivateLDAPIdentities(ctx context.Context, tx *dbTx, table string, identities []string) ([]string, error) {
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	stmt, err := tx.PrepareContext(ctx, `UPDATE `+table+` SET active=0, updated_at=? WHERE source='ldap' AND active=1 AND identity=?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
 
-func ListLicenses(ctx context.Context) ([]License, error) {
-	conn, err := requireDB()
+	var out []string
+	for _, id := range identities {
+		res, err := stmt.ExecContext(ctx, now, id)
+		if err != nil {
+			return nil, err
+		}
+		if a, _ := res.RowsAffected(); a > 0 {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func activeLDAPIdentities(ctx context.Context, tx *dbTx, table string) (map[string]struct{}, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT identity FROM `+table+` WHERE source='ldap' AND active=1`)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := conn.QueryContext(ctx, `SELECT id, key, assigned_user_id, comment, pc FROM licenses ORDER BY id`)
+	ids, err := scanStrings(rows)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	out := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		out[id] = struct{}{}
+	}
+	return out, nil
+}
 
-	var out []License
-	for rows.Next() {
-		var l License
-		var assigned sql.NullInt64
-		if err := rows.Scan(&l.ID, &l.Key, &assigned, &l.Comment, &l.PC); err != nil {
-			return nil, err
+func ldapUserIdentity(login string) string {
+	return "ldap:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func ldapComputerIdentity(name string) string {
+	return "ldap:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// LDAPSyncState — курсор инкрементальной синхронизации каталога.
+type LDAPSyncState struct {
+	Kind       string
+	Server     string
+	CursorAttr string
+	Cursor     string
+	LastFullAt string
+}
+
+func GetLDAPSyncState(ctx context.Context, kind string) (LDAPSyncState, bool, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return LDAPSyncState{}, false, err
+	}
+	st := LDAPSyncState{Kind: kind}
+	err = conn.QueryRowContext(ctx, `
+		SELECT server, cursor_attr, cursor, last_full_at FROM ldap_sync_state WHERE kind=?
+	`, kind).Scan(&st.Server, &st.CursorAttr, &st.Cursor, &st.LastFullAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return st, false, nil
+		}
+		return st, false, err
+	}
+	return st, true, nil
+}
+
+func SaveLDAPSyncState(ctx context.Context, tx *dbTx, st LDAPSyncState) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO ldap_sync_state(kind, server, cursor_attr, cursor, last_full_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind) DO UPDATE SET
+			server=excluded.server,
+			cursor_attr=excluded.cursor_attr,
+			cursor=excluded.cursor,
+			last_full_at=excluded.last_full_at,
+			updated_at=excluded.updated_at
+	`, st.Kind, st.Server, st.CursorAttr, st.Cursor, st.LastFullAt, now)
+	return err
+}
+
+// =============== LICENSES ===============
+
+// LicenseImport — строка импорта лицензий.
+type LicenseImport struct {
+	Key          string `json:"key"`
+	Comment      string `json:"comment"`
+	PC           string `json:"pc"`
+	Product      string `json:"product"`
+	Version      string `json:"version"`
+	LicenseType  string `json:"license_type"`
+	PurchaseDate string `json:"purchase_date"`
+	ExpiresAt    string `json:"expires_at"`
+	UserLogin    string `json:"user_login"` // логин активного пользователя — лицензия сразу назначается ему
+}
+
+// LicenseImportRow — итог по одной строке импорта: ok — добавлена (при dry_run — будет добавлена),
+// duplicate — ключ уже есть в БД или выше в этом же импорте, invalid — ошибка в данных строки.
+type LicenseImportRow struct {
+	Row    int    `json:"row"` // номер строки: в файле — как в таблице, в JSON — порядковый с 1
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	UserID int    `json:"user_id,omitempty"` // пользователь по user_login
+}
+
+// LicenseImportResult — итог импорта; Rows — по строке на каждый элемент входа, в том же порядке.
+type LicenseImportResult struct {
+	Imported int
+	Warnings []string
+	Rows     []LicenseImportRow
+}
+
+// LicenseFilter — фильтры списка лицензий (пустые поля не применяются).
+type LicenseFilter struct {
+	Product       string
+	Version       string
+	LicenseType   string
+	ExpiresBefore string // YYYY-MM-DD, включительно
+	ExpiresAfter  string // YYYY-MM-DD, включительно
+	Expired       *bool  // true — уже истекли, false — ещё действуют (или бессрочные)
+
+	AssignedUserID int    // лицензии пользователя
+	ComputerID     int    // лицензии, привязанные к ПК из справочника
+	Assigned       *bool  // true — занятые, false — свободные
+	Search         string // подстрока ключа, комментария, ПК или имени пользователя
+}
+
+// licenseSorts — ?sort= для /api/licenses; по умолчанию по id.
+var licenseSorts = listSorts{
+	"":           {idSortKey},
+	"id":         {idSortKey},
+	"key":        {{expr: "unicode_lower(key)"}, idSortKey},
+	"user":       {{expr: "unicode_lower(assigned_user_name)"}, idSortKey},
+	"comment":    {{expr: "unicode_lower(comment)"}, idSortKey},
+	"pc":         {{expr: "unicode_lower(pc)"}, idSortKey},
+	"product":    {{expr: "unicode_lower(product)"}, {expr: "unicode_lower(version)"}, idSortKey},
+	"expires_at": {{expr: "expires_at"}, idSortKey},
+}
+
+const licenseDateLayout = "2006-01-02"
+
+// normalizeLicenseDate приводит дату к YYYY-MM-DD (принимаем также RFC3339 и DD.MM.YYYY).
+func normalizeLicenseDate(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	for _, layout := range []string{licenseDateLayout, time.RFC3339, "02.01.2006"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Format(licenseDateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("некорректная дата %q (ожидается YYYY-MM-DD)", v)
+}
+
+// normalizeLicenseType: perpetual — бессрочная, term — срочная. Пустой тип выводим из даты окончания.
+func normalizeLicenseType(v, expiresAt string) (string, error) {
+	switch t := strings.ToLower(strings.TrimSpace(v)); t {
+	case "":
+		if expiresAt != "" {
+			return "term", nil
+		}
+		return "", nil
+	case "perpetual", "term":
+		if t == "perpetual" && expiresAt != "" {
+			return "", fmt.Errorf("бессрочная лицензия не может иметь дату окончания")
+		}
+		return t, nil
+	default:
+		return "", fmt.Errorf("неизвестный тип лицензии %q (perpetual или term)", v)
+	}
+}
+
+func (s *sqlStore) ListLicenses(ctx context.Context, f LicenseFilter, p ListParams) ([]License, Page, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	var where []string
+	var args []any
+	if v := strings.TrimSpace(f.Product); v != "" {
+		where = append(where, "unicode_lower(product) = unicode_lower(?)")
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(f.Version); v != "" {
+		where = append(where, "unicode_lower(version) = unicode_lower(?)")
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(f.LicenseType); v != "" {
+		where = append(where, "unicode_lower(license_type) = unicode_lower(?)")
+		args = append(args, v)
+	}
+	if f.ExpiresBefore != "" {
+		where = append(where, "expires_at <> '' AND expires_at <= ?")
+		args = append(args, f.ExpiresBefore)
+	}
+	if f.ExpiresAfter != "" {
+		where = append(where, "expires_at >= ?")
+		args = append(args, f.ExpiresAfter)
+	}
+	if f.Expired != nil {
+		today := time.Now().UTC().Format(licenseDateLayout)
+		if *f.Expired {
+			where = append(where, "expires_at <> '' AND expires_at < ?")
+		} else {
+			where = append(where, "(expires_at = '' OR expires_at >= ?)")
 		}
-		if assigned.Valid {
-			l.AssignedUserID = int(assigned.Int64)
+		args = append(args, today)
+	}
+
+	if f.AssignedUserID > 0 {
+		where = append(where, "assigned_user_id = ?")
+		args = append(args, f.AssignedUserID)
+	}
+	if f.ComputerID > 0 {
+		where = append(where, "computer_id = ?")
+		args = append(args, f.ComputerID)
+	}
+	if f.Assigned != nil {
+		if *f.Assigned {
+			where = append(where, "assigned_user_id IS NOT NULL")
 		} else {
-			l.AssignedUserID = 0
+			where = append(where, "assigned_user_id IS NULL")
+		}
+	}
+	if strings.TrimSpace(f.Search) != "" {
+		cond, condArgs := searchCond(f.Search, "key", "comment", "pc", "assigned_user_name")
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	var out []License
+	q := listQuery{cols: licenseColumns, from: licensesWithUser, where: where, args: args}
+	page, err := q.run(ctx, conn, licenseSorts, p, func(rows *sql.Rows, keys []any) error {
+		l, err := scanLicense(rows, keys...)
+		if err != nil {
+			return err
 		}
 		out = append(out, l)
+		return nil
+	})
+	return out, page, err
+}
+
+// GetLicense — одна лицензия; "license_not_found", если её нет.
+func (s *sqlStore) GetLicense(ctx context.Context, id int) (License, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return License{}, err
 	}
-	return out, rows.Err()
+	l, err := scanLicense(conn.QueryRowContext(ctx, `SELECT `+licenseColumns+` FROM `+licensesWithUser+` WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return License{}, fmt.Errorf("license_not_found")
+	}
+	return l, err
 }
 
-func ImportLicenses(ctx context.Context, in []struct {
-	Key     string `json:"key"`
-	Comment string `json:"comment"`
-	PC      string `json:"pc"`
-}) (imported int, warnings []string, err error) {
-	conn, err := requireDB()
+const licenseColumns = `id, key, assigned_user_id, comment, pc, computer_id,
+	product, version, license_type, purchase_date, expires_at, reclaim_flagged_at, assigned_user_name`
+
+// licensesWithUser — лицензии вместе с именем назначенного пользователя (поиск и сортировка по нему).
+const licensesWithUser = `(SELECT licenses.*, COALESCE(u.name, '') AS assigned_user_name
+	FROM licenses LEFT JOIN users u ON u.id = licenses.assigned_user_id) l`
+
+// scanLicense читает строку с колонками licenseColumns; extra — приёмники для следующих за ними колонок.
+func scanLicense(row interface{ Scan(...any) error }, extra ...any) (License, error) {
+	var l License
+	var assigned, computer sql.NullInt64
+	dest := []any{&l.ID, &l.Key, &assigned, &l.Comment, &l.PC, &computer,
+		&l.Product, &l.Version, &l.LicenseType, &l.PurchaseDate, &l.ExpiresAt, &l.ReclaimFlaggedAt, &l.AssignedUserName}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return License{}, err
+	}
+	if assigned.Valid {
+		l.AssignedUserID = int(assigned.Int64)
+	}
+	if computer.Valid {
+		l.ComputerID = int(computer.Int64)
+	}
+	return l, nil
+}
+
+// ImportLicenses добавляет лицензии одной транзакцией; строки с ошибками и дубликаты пропускаются.
+// dryRun — та же проверка с откатом транзакции: результат по строкам совпадает с настоящим импортом.
+func (s *sqlStore) ImportLicenses(ctx context.Context, actor string, in []LicenseImport, dryRun bool) (res LicenseImportResult, err error) {
+	conn, err := s.requireConn()
 	if err != nil {
-		return 0, nil, err
+		return LicenseImportResult{}, err
 	}
 
 	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, nil, err
+		return LicenseImportResult{}, err
 	}
 	defer func() {
-		if err != nil {
+		if err != nil || dryRun {
 			_ = tx.Rollback()
 		}
 	}()
 
 	now := time.Now().UTC().Format(time.RFC3339)
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO licenses(key, assigned_user_id, comment, pc, created_at) VALUES(?, NULL, ?, ?, ?);`)
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO licenses(key, assigned_user_id, comment, pc, computer_id, product, version, license_type, purchase_date, expires_at, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO NOTHING
+		RETURNING id
+	`)
 	if err != nil {
-		return 0, nil, err
+		return LicenseImportResult{}, err
 	}
 	defer stmt.Close()
 
-	for _, lic := range in {
+	seen := map[string]bool{}
+	res.Rows = make([]LicenseImportRow, len(in))
+	for i, lic := range in {
 		key := strings.TrimSpace(lic.Key)
+		row := &res.Rows[i]
+		*row = LicenseImportRow{Row: i + 1, Key: key, Status: "invalid"}
+
 		if key == "" {
-			warnings = append(warnings, "пропущена лицензия без ключа")
+			row.Error = "нет ключа"
+			res.Warnings = append(res.Warnings, "пропущена лицензия без ключа")
 			continue
 		}
-		if _, e := stmt.ExecContext(ctx, key, strings.TrimSpace(lic.Comment), strings.TrimSpace(lic.PC), now); e != nil {
-			if isUniqueConstraintError(e) {
-				warnings = append(warnings, "дубликат ключа: "+key)
-				continue
+		purchase, e := normalizeLicenseDate(lic.PurchaseDate)
+		if e == nil {
+			lic.ExpiresAt, e = normalizeLicenseDate(lic.ExpiresAt)
+		}
+		if e == nil {
+			lic.LicenseType, e = normalizeLicenseType(lic.LicenseType, lic.ExpiresAt)
+		}
+		if e == nil && strings.TrimSpace(lic.UserLogin) != "" {
+			if row.UserID, err = activeUserByLogin(ctx, tx, lic.UserLogin); err != nil {
+				return LicenseImportResult{}, err
+			}
+			if row.UserID == 0 {
+				e = fmt.Errorf("нет активного пользователя с логином %q", strings.TrimSpace(lic.UserLogin))
 			}
+		}
+		if e != nil {
+			row.Error = e.Error()
+			res.Warnings = append(res.Warnings, key+": "+e.Error())
+			continue
+		}
+
+		comment := strings.TrimSpace(lic.Comment)
+		pc := strings.TrimSpace(lic.PC)
+		// pc сопоставляем со справочником ПК (несопоставленное остаётся свободным текстом)
+		computerID := 0
+		if pc != "" {
+			c, e := resolveLicenseComputer(ctx, tx, 0, pc)
+			if e != nil && !strings.Contains(e.Error(), "computer_not_found") {
+				err = e
+				return LicenseImportResult{}, err
+			}
+			computerID = c.ID
+		}
+		// Дубликат — без ошибки: в PostgreSQL ошибка внутри транзакции обрывает весь импорт.
+		var id int64
+		e = stmt.QueryRowContext(ctx, key, nullableID(row.UserID), comment, pc, nullableID(computerID),
+			strings.TrimSpace(lic.Product), strings.TrimSpace(lic.Version), lic.LicenseType, purchase, lic.ExpiresAt, now).Scan(&id)
+		if errors.Is(e, sql.ErrNoRows) {
+			row.Status = "duplicate"
+			row.Error = "ключ уже есть в базе"
+			if seen[key] {
+				row.Error = "ключ повторяется в импорте"
+			}
+			res.Warnings = append(res.Warnings, "дубликат ключа: "+key)
+			continue
+		}
+		if e != nil {
 			err = e
-			return 0, warnings, err
+			return LicenseImportResult{}, err
 		}
-		imported++
+		if e := insertLicenseEvent(ctx, tx, LicenseEvent{
+			LicenseID:  int(id),
+			Action:     "import",
+			Actor:      actor,
+			NewUserID:  row.UserID,
+			NewPC:      pc,
+			NewComment: comment,
+		}); e != nil {
+			err = e
+			return LicenseImportResult{}, err
+		}
+		seen[key] = true
+		row.Status = "ok"
+		res.Imported++
 	}
 
+	if dryRun {
+		return res, nil
+	}
 	if err := tx.Commit(); err != nil {
-		return 0, warnings, err
+		return LicenseImportResult{}, err
 	}
-	return imported, warnings, nil
+	return res, nil
 }
 
-func AssignLicense(ctx context.Context, userID, licenseID int) error {
-	conn, err := requireDB()
+// activeUserByLogin — id активного пользователя по логину (без учёта регистра); 0 — не найден.
+func activeUserByLogin(ctx context.Context, tx *dbTx, login string) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE unicode_lower(login) = unicode_lower(?) AND active=1 ORDER BY id LIMIT 1
+	`, strings.TrimSpace(login)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (s *sqlStore) AssignLicense(ctx context.Context, actor string, userID, licenseID int) (err error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return err
 	}
 
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
 	// проверяем пользователя
 	var tmp int
-	if err := conn.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id=? AND active=1`, userID).Scan(&tmp); err != nil {
+	if err = tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id=? AND active=1`, userID).Scan(&tmp); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("user_not_found")
+			err = fmt.Errorf("user_not_found")
 		}
 		return err
 	}
 
-	res, err := conn.ExecContext(ctx, `UPDATE licenses SET assigned_user_id=? WHERE id=?`, userID, licenseID)
+	old, err := loadLicenseState(ctx, tx, licenseID)
 	if err != nil {
 		return err
 	}
-	a, _ := res.RowsAffected()
-	if a == 0 {
-		return fmt.Errorf("license_not_found")
+
+	if _, err = tx.ExecContext(ctx, `UPDATE licenses SET assigned_user_id=?, reclaim_flagged_at='' WHERE id=?`, userID, licenseID); err != nil {
+		return err
+	}
+
+	ev := old.event("assign", actor)
+	ev.NewUserID = userID
+	if err = insertLicenseEvent(ctx, tx, ev); err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func UpdateLicense(ctx context.Context, licenseID int, comment, pc string) error {
-	conn, err := requireDB()
+// UpdateLicense меняет комментарий и ПК. ПК задаётся computerID или именем (pc) и должен быть активным
+// в справочнике computers; неизменённое значение pc старых записей сохраняется как есть.
+func (s *sqlStore) UpdateLicense(ctx context.Context, actor string, licenseID int, comment, pc string, computerID int) (err error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	res, err := conn.ExecContext(ctx, `UPDATE licenses SET comment=?, pc=? WHERE id=?`, strings.TrimSpace(comment), strings.TrimSpace(pc), licenseID)
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	old, err := loadLicenseState(ctx, tx, licenseID)
 	if err != nil {
 		return err
 	}
-	a, _ := res.RowsAffected()
-	if a == 0 {
-		return fmt.Errorf("license_not_found")
+
+	comment = strings.TrimSpace(comment)
+	pc = strings.TrimSpace(pc)
+	computer := nullableID(old.ComputerID)
+	switch {
+	case computerID > 0:
+		c, e := resolveLicenseComputer(ctx, tx, computerID, "")
+		if e != nil {
+			err = e
+			return err
+		}
+		pc, computer = c.Name, c.ID
+	case pc == "":
+		computer = nil
+	case strings.EqualFold(pc, old.PC) && old.ComputerID != 0:
+		// ПК не менялся — не мешаем править комментарий, даже если ПК уже деактивирован.
+	default:
+		c, e := resolveLicenseComputer(ctx, tx, 0, pc)
+		switch {
+		case e == nil:
+			pc, computer = c.Name, c.ID
+		case strings.Contains(e.Error(), "computer_not_found"):
+			inUse, e := computersDirectoryInUse(ctx, tx)
+			if e != nil {
+				err = e
+				return err
+			}
+			// Свободный текст допустим без справочника и для неизменённого значения старой записи.
+			if inUse && !strings.EqualFold(pc, old.PC) {
+				err = fmt.Errorf("computer_not_found")
+				return err
+			}
+			computer = nil
+		default:
+			err = e
+			return err
+		}
 	}
-	return nil
+
+	if _, err = tx.ExecContext(ctx, `UPDATE licenses SET comment=?, pc=?, computer_id=? WHERE id=?`, comment, pc, computer, licenseID); err != nil {
+		return err
+	}
+
+	ev := old.event("update", actor)
+	ev.NewComment = comment
+	ev.NewPC = pc
+	if err = insertLicenseEvent(ctx, tx, ev); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func UnassignLicense(ctx context.Context, licenseID int) error {
-	conn, err := requireDB()
+func (s *sqlStore) UnassignLicense(ctx context.Context, actor string, licenseID int) (err error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return err
 	}
-	res, err := conn.ExecContext(ctx, `UPDATE licenses SET assigned_user_id=NULL WHERE id=?`, licenseID)
+
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	a, _ := res.RowsAffected()
-	if a == 0 {
-		return fmt.Errorf("license_not_found")
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	old, err := loadLicenseState(ctx, tx, licenseID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE licenses SET assigned_user_id=NULL, reclaim_flagged_at='' WHERE id=?`, licenseID); err != nil {
+		return err
+	}
+
+	ev := old.event("unassign", actor)
+	ev.NewUserID = 0
+	if err = insertLicenseEvent(ctx, tx, ev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteLicense удаляет лицензию. История (license_events) остаётся — последним событием "delete".
+func (s *sqlStore) DeleteLicense(ctx context.Context, actor string, licenseID int) (err error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	old, err := loadLicenseState(ctx, tx, licenseID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM license_notifications WHERE license_id=?`, licenseID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM licenses WHERE id=?`, licenseID); err != nil {
+		return err
+	}
+
+	ev := old.event("delete", actor)
+	ev.NewUserID, ev.NewPC, ev.NewComment = 0, "", ""
+	if err = insertLicenseEvent(ctx, tx, ev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// =============== LICENSE EVENTS ===============
+
+// licenseState — снимок изменяемых полей лицензии до изменения.
+type licenseState struct {
+	ID             int
+	AssignedUserID int
+	Comment        string
+	PC             string
+	ComputerID     int
+}
+
+// event заготавливает запись истории: old/new заполнены текущим состоянием,
+// вызывающий код перезаписывает только то, что реально меняется.
+func (s licenseState) event(action, actor string) LicenseEvent {
+	return LicenseEvent{
+		LicenseID:  s.ID,
+		Action:     action,
+		Actor:      actor,
+		OldUserID:  s.AssignedUserID,
+		NewUserID:  s.AssignedUserID,
+		OldPC:      s.PC,
+		NewPC:      s.PC,
+		OldComment: s.Comment,
+		NewComment: s.Comment,
 	}
-	return nil
+}
+
+// loadLicenseState читает текущее состояние лицензии внутри транзакции.
+func loadLicenseState(ctx context.Context, tx *dbTx, licenseID int) (licenseState, error) {
+	var st licenseState
+	var assigned, computer sql.NullInt64
+	err := tx.QueryRowContext(ctx, `SELECT id, assigned_user_id, comment, pc, computer_id FROM licenses WHERE id=?`, licenseID).
+		Scan(&st.ID, &assigned, &st.Comment, &st.PC, &computer)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return st, fmt.Errorf("license_not_found")
+		}
+		return st, err
+	}
+	if assigned.Valid {
+		st.AssignedUserID = int(assigned.Int64)
+	}
+	if computer.Valid {
+		st.ComputerID = int(computer.Int64)
+	}
+	return st, nil
+}
+
+func insertLicenseEvent(ctx context.Context, tx *dbTx, ev LicenseEvent) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO license_events(license_id, action, actor, old_user_id, new_user_id, old_pc, new_pc, old_comment, new_comment, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.LicenseID, ev.Action, ev.Actor, nullableID(ev.OldUserID), nullableID(ev.NewUserID),
+		ev.OldPC, ev.NewPC, ev.OldComment, ev.NewComment, now)
+	return err
+}
+
+// ListLicenseEvents отдаёт историю лицензии в хронологическом порядке.
+func (s *sqlStore) ListLicenseEvents(ctx context.Context, licenseID int) ([]LicenseEvent, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var tmp int
+	if err := conn.QueryRowContext(ctx, `SELECT 1 FROM licenses WHERE id=?`, licenseID).Scan(&tmp); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		// лицензии уже нет, но история могла остаться
+		if err := conn.QueryRowContext(ctx, `SELECT 1 FROM license_events WHERE license_id=? LIMIT 1`, licenseID).Scan(&tmp); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("license_not_found")
+			}
+			return nil, err
+		}
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT e.id, e.license_id, e.action, e.actor,
+			e.old_user_id, COALESCE(NULLIF(ou.name, ''), ou.email, ''),
+			e.new_user_id, COALESCE(NULLIF(nu.name, ''), nu.email, ''),
+			e.old_pc, e.new_pc, e.old_comment, e.new_comment, e.created_at
+		FROM license_events e
+		LEFT JOIN users ou ON ou.id = e.old_user_id
+		LEFT JOIN users nu ON nu.id = e.new_user_id
+		WHERE e.license_id=?
+		ORDER BY e.id
+	`, licenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LicenseEvent
+	for rows.Next() {
+		var ev LicenseEvent
+		var oldUser, newUser sql.NullInt64
+		if err := rows.Scan(&ev.ID, &ev.LicenseID, &ev.Action, &ev.Actor,
+			&oldUser, &ev.OldUserName, &newUser, &ev.NewUserName,
+			&ev.OldPC, &ev.NewPC, &ev.OldComment, &ev.NewComment, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		if oldUser.Valid {
+			ev.OldUserID = int(oldUser.Int64)
+		}
+		if newUser.Valid {
+			ev.NewUserID = int(newUser.Int64)
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
 }
 
 // =============== MEETINGS ===============
 
-func ReplaceMeetingsSnapshot(ctx context.Context, exportedAt string, items []Meeting) (int, error) {
-	conn, err := requireDB()
+func (s *sqlStore) ReplaceMeetingsSnapshot(ctx context.Context, exportedAt string, items []Meeting) (int, error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return 0, err
 	}
@@ -559,7 +1337,7 @@ func ReplaceMeetingsSnapshot(ctx context.Context, exportedAt string, items []Mee
 	}
 
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO meetings(id, subject, start, end, location, is_recurring, is_canceled, link, participants)
+		INSERT INTO meetings(id, subject, start, "end", location, is_recurring, is_canceled, link, participants)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
@@ -595,8 +1373,8 @@ func ReplaceMeetingsSnapshot(ctx context.Context, exportedAt string, items []Mee
 	return cnt, nil
 }
 
-func GetMeetingsState(ctx context.Context) (MeetingsState, error) {
-	conn, err := requireDB()
+func (s *sqlStore) GetMeetingsState(ctx context.Context) (MeetingsState, error) {
+	conn, err := s.requireConn()
 	if err != nil {
 		return MeetingsState{}, err
 	}
@@ -607,7 +1385,7 @@ func GetMeetingsState(ctx context.Context) (MeetingsState, error) {
 	}
 
 	rows, err := conn.QueryContext(ctx, `
-		SELECT id, subject, start, end, location, is_recurring, is_canceled, link, participants
+		SELECT id, subject, start, "end", location, is_recurring, is_canceled, link, participants
 		FROM meetings ORDER BY start, id
 	`)
 	if err != nil {
@@ -636,11 +1414,11 @@ func GetMeetingsState(ctx context.Context) (MeetingsState, error) {
 // =============== STATE ===============
 
 func GetState(ctx context.Context) (users []User, licenses []License, err error) {
-	users, err = ListUsers(ctx)
+	users, err = getStore().ListUsers(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
-	licenses, err = ListLicenses(ctx)
+	licenses, _, err = getStore().ListLicenses(ctx, LicenseFilter{}, ListParams{})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -656,11 +1434,23 @@ func boolToInt(v bool) int {
 	return 0
 }
 
-func isUniqueConstraintError(err error) bool {
-	if err == nil {
-		return false
+// nullableID превращает 0 в NULL для колонок-ссылок на users.
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
 	}
-	msg := strings.ToLower(err.Error())
-	// modernc + sqlite обычно пишут "constraint failed" / "unique".
-	return strings.Contains(msg, "unique") || strings.Contains(msg, "constraint")
+	return out, rows.Err()
 }
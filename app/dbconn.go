@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Поддерживаемые БД (DB_DRIVER).
+const (
+	driverSQLite   = "sqlite"
+	driverPostgres = "postgres"
+)
+
+// dbConn — *sql.DB с учётом диалекта.
+// SQL в коде пишется с плейсхолдерами "?" и в общем для SQLite и PostgreSQL подмножестве
+// (ON CONFLICT ... DO UPDATE/NOTHING, RETURNING, IS DISTINCT FROM); для PostgreSQL "?" переписываются в $1, $2, ...
+// Вызывать нужно *Context-методы: они переписывают запрос, унаследованные от *sql.DB Exec/Query — нет.
+type dbConn struct {
+	*sql.DB
+	driver string
+}
+
+type dbTx struct {
+	*sql.Tx
+	driver string
+}
+
+func (c *dbConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dbTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, driver: c.driver}, nil
+}
+
+func (t *dbTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, rebind(t.driver, query), args...)
+}
+
+func (t *dbTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, rebind(t.driver, query), args...)
+}
+
+func (t *dbTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, rebind(t.driver, query), args...)
+}
+
+func (t *dbTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.Tx.PrepareContext(ctx, rebind(t.driver, query))
+}
+
+// rebind переписывает "?" в $N для PostgreSQL (строковые литералы и идентификаторы в кавычках не трогаем).
+func rebind(driver, query string) string {
+	if driver != driverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,420 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Напоминания о продлении лицензий. Для окон EXPIRY_NOTIFY_DAYS (например 60,30,7)
+// лицензия попадает в рассылку один раз на окно и канал: факт отправки пишется в license_notifications
+// вместе с expires_at, поэтому после продления (новая дата) напоминания снова включаются.
+
+// ExpiringLicense — лицензия с приближающимся окончанием срока.
+type ExpiringLicense struct {
+	License
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+	DaysLeft  int    `json:"days_left"`
+	Window    int    `json:"window"` // самое узкое окно EXPIRY_NOTIFY_DAYS, в которое попала лицензия (0 — ни в одно)
+}
+
+// ExpiryNotifyResult — итог рассылки.
+type ExpiryNotifyResult struct {
+	Notified     int  `json:"notified"`
+	DigestSent   bool `json:"digest_sent"`
+	UserMails    int  `json:"user_mails"`
+	UserFailures int  `json:"user_failures"`
+}
+
+// expiryWindows — окна по убыванию, без дублей и неположительных значений.
+func expiryWindows() []int {
+	seen := map[int]bool{}
+	var out []int
+	for _, d := range getConfig().ExpiryNotifyDays {
+		if d > 0 && !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+	return out
+}
+
+// reachedWindow — самое узкое окно, в которое уже попала лицензия (0 — ни в одно).
+func reachedWindow(daysLeft int, windows []int) int {
+	reached := 0
+	for _, w := range windows {
+		if daysLeft <= w {
+			reached = w
+		}
+	}
+	return reached
+}
+
+// ListExpiringLicenses — лицензии, истекающие в ближайшие days дней (уже истёкшие не включаются).
+func ListExpiringLicenses(ctx context.Context, days int) ([]ExpiringLicense, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+
+	windows := expiryWindows()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	rows, err := conn.QueryContext(ctx, `
+		SELECT l.id, l.key, l.assigned_user_id, l.comment, l.pc, COALESCE(l.computer_id, 0),
+			l.product, l.version, l.license_type, l.purchase_date, l.expires_at,
+			COALESCE(u.name, ''), COALESCE(u.email, '')
+		FROM licenses l
+		LEFT JOIN users u ON u.id = l.assigned_user_id
+		WHERE l.expires_at <> '' AND l.expires_at >= ? AND l.expires_at <= ?
+		ORDER BY l.expires_at, l.id
+	`, today.Format(licenseDateLayout), today.AddDate(0, 0, days).Format(licenseDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ExpiringLicense
+	for rows.Next() {
+		var e ExpiringLicense
+		var assigned *int64
+		if err := rows.Scan(&e.ID, &e.Key, &assigned, &e.Comment, &e.PC, &e.ComputerID,
+			&e.Product, &e.Version, &e.LicenseType, &e.PurchaseDate, &e.ExpiresAt,
+			&e.UserName, &e.UserEmail); err != nil {
+			return nil, err
+		}
+		if assigned != nil {
+			e.AssignedUserID = int(*assigned)
+		}
+		if exp, err := time.Parse(licenseDateLayout, e.ExpiresAt); err == nil {
+			e.DaysLeft = int(exp.Sub(today).Hours() / 24)
+		}
+		e.Window = reachedWindow(e.DaysLeft, windows)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Каналы рассылки: отметки в license_notifications ведутся по каждому отдельно.
+const (
+	expiryChannelDigest = "digest"
+	expiryChannelHolder = "holder"
+)
+
+func expiryAlreadyNotified(ctx context.Context, channel string, licenseID int, expiresAt string, window int) (bool, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return false, err
+	}
+	n := 0
+	err = conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM license_notifications
+		WHERE license_id=? AND expires_at=? AND window_days=? AND channel=?
+	`, licenseID, expiresAt, window, channel).Scan(&n)
+	return n > 0, err
+}
+
+// expiryMark — одна отметка license_notifications, вставленная reserveExpiryNotified.
+type expiryMark struct {
+	LicenseID int
+	ExpiresAt string
+	Window    int
+}
+
+// reserveExpiryNotified помечает окно и все более широкие окна (чтобы не слать их задним числом)
+// до отправки письма. Возвращает лицензии, окно которых удалось занять (параллельный запуск
+// получит пустой список), и ровно те отметки, что были вставлены, — их снимает releaseExpiryNotified,
+// если письмо не ушло. После успешной отправки писать в БД уже нечего, поэтому сбой БД
+// не приводит к повторной рассылке.
+func reserveExpiryNotified(ctx context.Context, channel string, items []ExpiringLicense, windows []int) (reserved []ExpiringLicense, marks []expiryMark, err error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, it := range items {
+		var itemMarks []expiryMark
+		own := false
+		for _, w := range windows {
+			if w < it.Window {
+				continue
+			}
+			r, err := tx.ExecContext(ctx, `
+				INSERT INTO license_notifications(license_id, expires_at, window_days, channel, sent_at)
+				VALUES(?, ?, ?, ?, ?)
+				ON CONFLICT DO NOTHING
+			`, it.ID, it.ExpiresAt, w, channel, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			if n, _ := r.RowsAffected(); n > 0 {
+				itemMarks = append(itemMarks, expiryMark{LicenseID: it.ID, ExpiresAt: it.ExpiresAt, Window: w})
+				if w == it.Window {
+					own = true
+				}
+			}
+		}
+		if own {
+			reserved = append(reserved, it)
+		}
+		marks = append(marks, itemMarks...)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return reserved, marks, nil
+}
+
+// releaseExpiryNotified снимает отметки, поставленные под неудавшуюся отправку, — следующий запуск повторит её.
+func releaseExpiryNotified(ctx context.Context, channel string, marks []expiryMark) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	for _, m := range marks {
+		if _, err := conn.ExecContext(ctx, `
+			DELETE FROM license_notifications
+			WHERE license_id=? AND expires_at=? AND window_days=? AND channel=?
+		`, m.LicenseID, m.ExpiresAt, m.Window, channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseExpiryMarks — releaseExpiryNotified с записью в лог: ошибка снятия отметок
+// не должна прерывать рассылку остальным получателям.
+func releaseExpiryMarks(ctx context.Context, channel string, marks []expiryMark) {
+	if err := releaseExpiryNotified(ctx, channel, marks); err != nil {
+		logging.Errorf("expiry notify: cannot release %s marks, they will not be retried: %v", channel, err)
+	}
+}
+
+// freshExpiring — лицензии из items, ещё не отправленные по каналу channel в их текущем окне.
+func freshExpiring(ctx context.Context, channel string, items []ExpiringLicense) ([]ExpiringLicense, error) {
+	var fresh []ExpiringLicense
+	for _, e := range items {
+		if e.Window == 0 {
+			continue
+		}
+		done, err := expiryAlreadyNotified(ctx, channel, e.ID, e.ExpiresAt, e.Window)
+		if err != nil {
+			return nil, err
+		}
+		if !done {
+			fresh = append(fresh, e)
+		}
+	}
+	return fresh, nil
+}
+
+func formatExpiringLine(e ExpiringLicense) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- %s", e.Key)
+	if e.Product != "" {
+		fmt.Fprintf(&b, " [%s", e.Product)
+		if e.Version != "" {
+			fmt.Fprintf(&b, " %s", e.Version)
+		}
+		b.WriteString("]")
+	}
+	fmt.Fprintf(&b, ": истекает %s (через %d дн.)", e.ExpiresAt, e.DaysLeft)
+	if e.UserName != "" {
+		fmt.Fprintf(&b, ", пользователь: %s", e.UserName)
+	}
+	if e.PC != "" {
+		fmt.Fprintf(&b, ", ПК: %s", e.PC)
+	}
+	return b.String()
+}
+
+// RunExpiryNotifications рассылает дайджест по новым попаданиям в окна и (опционально) письма держателям.
+// Лицензия считается уведомлённой по каналу, только если письмо этого канала действительно ушло:
+// держатели, которым отправить не удалось, получат письмо при следующем запуске.
+func RunExpiryNotifications(ctx context.Context) (res ExpiryNotifyResult, err error) {
+	cfg := getConfig()
+	windows := expiryWindows()
+	if len(windows) == 0 {
+		return res, nil
+	}
+	if !smtpEnabled() {
+		return res, fmt.Errorf("smtp is not configured")
+	}
+	if !beginJob("expiry_notify") {
+		return res, errRestoreInProgress
+	}
+	defer endJob("expiry_notify")
+
+	all, err := ListExpiringLicenses(ctx, windows[0])
+	if err != nil {
+		return res, err
+	}
+
+	notified := map[int]bool{}
+	defer func() {
+		res.Notified = len(notified)
+		if res.DigestSent || res.UserMails > 0 || res.UserFailures > 0 {
+			logging.Infof("expiry notify: notified=%d digest=%v user_mails=%d user_failures=%d", res.Notified, res.DigestSent, res.UserMails, res.UserFailures)
+		}
+	}()
+
+	// Дайджест — по окнам, от самого срочного.
+	if len(cfg.ExpiryNotifyTo) > 0 {
+		fresh, err := freshExpiring(ctx, expiryChannelDigest, all)
+		if err != nil {
+			return res, err
+		}
+		items, marks, err := reserveExpiryNotified(ctx, expiryChannelDigest, fresh, windows)
+		if err != nil {
+			return res, err
+		}
+		if len(items) > 0 {
+			var b strings.Builder
+			b.WriteString("Лицензии с приближающимся окончанием срока действия.\n")
+			for i := len(windows) - 1; i >= 0; i-- {
+				w := windows[i]
+				header := false
+				for _, e := range items {
+					if e.Window != w {
+						continue
+					}
+					if !header {
+						fmt.Fprintf(&b, "\nВ ближайшие %d дн.:\n", w)
+						header = true
+					}
+					b.WriteString(formatExpiringLine(e) + "\n")
+				}
+			}
+			subject := fmt.Sprintf("onessa: истекают лицензии (%d)", len(items))
+			if err := sendMail(cfg.ExpiryNotifyTo, subject, b.String()); err != nil {
+				releaseExpiryMarks(ctx, expiryChannelDigest, marks)
+				return res, err
+			}
+			res.DigestSent = true
+			for _, e := range items {
+				notified[e.ID] = true
+			}
+		}
+	}
+
+	// Письма держателям: ошибки по отдельным адресам не мешают остальным.
+	if cfg.ExpiryNotifyUsers {
+		var withEmail []ExpiringLicense
+		for _, e := range all {
+			if strings.TrimSpace(e.UserEmail) != "" {
+				withEmail = append(withEmail, e)
+			}
+		}
+		fresh, err := freshExpiring(ctx, expiryChannelHolder, withEmail)
+		if err != nil {
+			return res, err
+		}
+		byEmail := map[string][]ExpiringLicense{}
+		var emails []string
+		for _, e := range fresh {
+			email := strings.TrimSpace(e.UserEmail)
+			if _, ok := byEmail[email]; !ok {
+				emails = append(emails, email)
+			}
+			byEmail[email] = append(byEmail[email], e)
+		}
+		for _, email := range emails {
+			items, marks, err := reserveExpiryNotified(ctx, expiryChannelHolder, byEmail[email], windows)
+			if err != nil {
+				return res, err
+			}
+			if len(items) == 0 {
+				continue
+			}
+			var b strings.Builder
+			b.WriteString("Срок действия закреплённых за вами лицензий подходит к концу:\n\n")
+			for _, e := range items {
+				b.WriteString(formatExpiringLine(e) + "\n")
+			}
+			if err := sendMail([]string{email}, "onessa: истекает срок действия лицензии", b.String()); err != nil {
+				logging.Warnf("expiry notify: cannot mail %s: %v", email, err)
+				releaseExpiryMarks(ctx, expiryChannelHolder, marks)
+				res.UserFailures++
+				continue
+			}
+			res.UserMails++
+			for _, e := range items {
+				notified[e.ID] = true
+			}
+		}
+	}
+	return res, nil
+}
+
+// =============== API ===============
+
+// лицензии, истекающие в ближайшие ?days= дней (по умолчанию — самое широкое окно уведомлений)
+func handleExpiringLicenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 60
+	if ws := expiryWindows(); len(ws) > 0 {
+		days = ws[0]
+	}
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 3650 {
+			httpError(w, "days должен быть от 0 до 3650", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	items, err := ListExpiringLicenses(r.Context(), days)
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Days  int               `json:"days"`
+		Items []ExpiringLicense `json:"items"`
+	}{Days: days, Items: items})
+}
+
+// ручной запуск рассылки (обычно — по расписанию EXPIRY_NOTIFY_SCHEDULE)
+func handleExpiryNotifyRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logging.Infof("expiry notify requested by %q", requestActor(r))
+	res, err := RunExpiryNotifications(r.Context())
+	if errors.Is(err, errRestoreInProgress) {
+		httpError(w, "идёт восстановление БД из бэкапа, повторите позже", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpError(w, "notify error: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	auditTarget(r, "expiry_notify", nil, nil, res)
+	writeJSON(w, res)
+}
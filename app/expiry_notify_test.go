@@ -0,0 +1,254 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSink — минимальный SMTP-сервер в процессе теста: принимает письма и отклоняет RCPT из reject.
+type smtpSink struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	reject map[string]bool
+	mails  []sinkMail
+}
+
+type sinkMail struct {
+	To   []string
+	Data string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpSink{ln: ln, reject: map[string]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return s
+}
+
+func (s *smtpSink) port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+func (s *smtpSink) setReject(addr string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject[addr] = on
+}
+
+// take возвращает принятые письма и очищает список.
+func (s *smtpSink) take() []sinkMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.mails
+	s.mails = nil
+	return out
+}
+
+func (s *smtpSink) serve(c net.Conn) {
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(c)
+	reply := func(line string) { fmt.Fprintf(c, "%s\r\n", line) }
+
+	reply("220 sink ready")
+	var cur sinkMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			cur = sinkMail{}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			addr := strings.Trim(strings.TrimSpace(line[len("RCPT TO:"):]), "<>")
+			s.mu.Lock()
+			rejected := s.reject[addr]
+			s.mu.Unlock()
+			if rejected {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			cur.To = append(cur.To, addr)
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			cur.Data = b.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, cur)
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func mailsTo(mails []sinkMail, addr string) []sinkMail {
+	var out []sinkMail
+	for _, m := range mails {
+		for _, to := range m.To {
+			if to == addr {
+				out = append(out, m)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func TestRunExpiryNotificationsRetriesFailedHolders(t *testing.T) {
+	sink := newSMTPSink(t)
+	conn := newTestDB(t, Config{
+		SMTPHost:          "127.0.0.1",
+		SMTPPort:          sink.port(),
+		SMTPFrom:          "onessa@example.test",
+		SMTPTLS:           "none",
+		ExpiryNotifyDays:  []int{60, 30, 7},
+		ExpiryNotifyTo:    []string{"admin@example.test"},
+		ExpiryNotifyUsers: true,
+	})
+	ctx := context.Background()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	exec := func(q string, args ...any) {
+		t.Helper()
+		if _, err := conn.ExecContext(ctx, q, args...); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	exec(`INSERT INTO users(id, identity, name, email) VALUES(1, 'manual:ok', 'Ok', 'ok@example.test')`)
+	exec(`INSERT INTO users(id, identity, name, email) VALUES(2, 'manual:bad', 'Bad', 'bad@example.test')`)
+	exec(`INSERT INTO licenses(key, assigned_user_id, expires_at) VALUES('KEY-OK', 1, ?)`, today.AddDate(0, 0, 5).Format(licenseDateLayout))
+	exec(`INSERT INTO licenses(key, assigned_user_id, expires_at) VALUES('KEY-BAD', 2, ?)`, today.AddDate(0, 0, 20).Format(licenseDateLayout))
+
+	// Первый запуск: дайджест и письмо ok@ уходят, bad@ отклонён.
+	sink.setReject("bad@example.test", true)
+	res, err := RunExpiryNotifications(ctx)
+	if err != nil {
+		t.Fatalf("run 1: %v", err)
+	}
+	if !res.DigestSent || res.UserMails != 1 || res.UserFailures != 1 || res.Notified != 2 {
+		t.Fatalf("run 1: unexpected result %+v", res)
+	}
+	mails := sink.take()
+	digest := mailsTo(mails, "admin@example.test")
+	if len(digest) != 1 || !strings.Contains(digest[0].Data, "KEY-OK") || !strings.Contains(digest[0].Data, "KEY-BAD") {
+		t.Fatalf("run 1: digest not delivered as expected: %+v", digest)
+	}
+	if n := len(mailsTo(mails, "ok@example.test")); n != 1 {
+		t.Fatalf("run 1: ok@ got %d mails, want 1", n)
+	}
+
+	// Второй запуск: дайджест не повторяется, bad@ снова пытаемся уведомить.
+	res, err = RunExpiryNotifications(ctx)
+	if err != nil {
+		t.Fatalf("run 2: %v", err)
+	}
+	if res.DigestSent || res.UserMails != 0 || res.UserFailures != 1 || res.Notified != 0 {
+		t.Fatalf("run 2: unexpected result %+v", res)
+	}
+	if mails := sink.take(); len(mails) != 0 {
+		t.Fatalf("run 2: unexpected mails %+v", mails)
+	}
+
+	// Третий запуск: адрес bad@ снова принимает почту — держатель получает своё письмо.
+	sink.setReject("bad@example.test", false)
+	res, err = RunExpiryNotifications(ctx)
+	if err != nil {
+		t.Fatalf("run 3: %v", err)
+	}
+	if res.DigestSent || res.UserMails != 1 || res.UserFailures != 0 || res.Notified != 1 {
+		t.Fatalf("run 3: unexpected result %+v", res)
+	}
+	mails = sink.take()
+	if len(mails) != 1 || len(mailsTo(mails, "bad@example.test")) != 1 || !strings.Contains(mails[0].Data, "KEY-BAD") {
+		t.Fatalf("run 3: unexpected mails %+v", mails)
+	}
+
+	// Четвёртый запуск: всё разослано.
+	res, err = RunExpiryNotifications(ctx)
+	if err != nil {
+		t.Fatalf("run 4: %v", err)
+	}
+	if res != (ExpiryNotifyResult{}) {
+		t.Fatalf("run 4: unexpected result %+v", res)
+	}
+	if mails := sink.take(); len(mails) != 0 {
+		t.Fatalf("run 4: unexpected mails %+v", mails)
+	}
+}
+
+func TestRunExpiryNotificationsDigestFailureIsRetried(t *testing.T) {
+	sink := newSMTPSink(t)
+	conn := newTestDB(t, Config{
+		SMTPHost:         "127.0.0.1",
+		SMTPPort:         sink.port(),
+		SMTPFrom:         "onessa@example.test",
+		SMTPTLS:          "none",
+		ExpiryNotifyDays: []int{30},
+		ExpiryNotifyTo:   []string{"admin@example.test"},
+	})
+	ctx := context.Background()
+
+	expires := time.Now().UTC().AddDate(0, 0, 10).Format(licenseDateLayout)
+	if _, err := conn.ExecContext(ctx, `INSERT INTO licenses(key, expires_at) VALUES('KEY-1', ?)`, expires); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.setReject("admin@example.test", true)
+	if _, err := RunExpiryNotifications(ctx); err == nil {
+		t.Fatal("run 1: expected smtp error")
+	}
+
+	sink.setReject("admin@example.test", false)
+	res, err := RunExpiryNotifications(ctx)
+	if err != nil {
+		t.Fatalf("run 2: %v", err)
+	}
+	if !res.DigestSent || res.Notified != 1 {
+		t.Fatalf("run 2: unexpected result %+v", res)
+	}
+	if mails := sink.take(); len(mailsTo(mails, "admin@example.test")) != 1 {
+		t.Fatalf("run 2: digest not delivered: %+v", mails)
+	}
+}
@@ -2,9 +2,11 @@ package app
 
 import (
 	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,10 +22,12 @@ func NewHTTPHandler(cfg Config) http.Handler {
 
 	// базовые middleware
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(parseTrustedProxies(cfg.TrustedProxies)))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(requestTimeout(60*time.Second, restorePath)) // восстановление из бэкапа дольше: см. handleBackupRestore
 	r.Use(requestLogger())
+	r.Use(metricsMiddleware)
+	r.Use(holdDBLease(restorePath, "/healthz")) // см. dbLease: восстановление из бэкапа ждёт начатые запросы
 
 	// Порядок важен:
 	// 1) authMiddleware — проверка авторизации (LDAP + сессии)
@@ -34,26 +38,83 @@ func NewHTTPHandler(cfg Config) http.Handler {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		_, _ = w.Write([]byte("ok"))
 	})
+	if metricsOnMainAddr(cfg) {
+		r.Handle("/metrics", metricsHandler()) // Authorization: Bearer <METRICS_TOKEN>
+	}
 
-	// API пользователей и лицензий
+	// API пользователей и лицензий.
+	// Каждый роут объявляет минимальную роль: viewer — чтение, operator — изменения, admin — администрирование.
 	r.Route("/api", func(api chi.Router) {
-		api.Get("/state", handleState)
-		api.Post("/users/import", handleImportUsers)       // manual fallback
-		api.Get("/users/all", handleUsersAll)              // для фронта: весь список (active + inactive)
-		api.Post("/licenses/import", handleImportLicenses) // всегда в БД
-		api.Post("/assign", handleAssign)
-		api.Post("/license/update", handleUpdateLicense)
-		api.Post("/license/unassign", handleUnassignLicense)
-		api.Get("/computers", handleComputers) // список ПК из LDAP
+		api.Use(auditMiddleware) // журнал изменений: каждый не-GET запрос, в том числе отклонённый по роли или scope
+
+		// Кроме роли: API-токены ограничены своими scope, запросы с cookie сессии проверяются на CSRF.
+		withRole := func(need Role) chi.Router {
+			return api.With(requireTokenScope, requireCSRF, requireRole(need))
+		}
+		viewer := withRole(RoleViewer)
+		operator := withRole(RoleOperator)
+		admin := withRole(RoleAdmin)
+
+		viewer.Get("/openapi.json", handleOpenAPI) // спецификация этого API (app/openapi.json)
+		viewer.Get("/me", handleMe)                // текущий пользователь и роль
+		viewer.Get("/state", handleState)
+		operator.Post("/users/import", handleImportUsers)       // manual fallback
+		viewer.Get("/users/all", handleUsersAll)                // для фронта: весь список (active + inactive)
+		viewer.Get("/licenses", handleLicenses)                 // с фильтрами по продукту/типу/сроку
+		operator.Post("/licenses/import", handleImportLicenses) // всегда в БД
+		operator.Post("/assign", handleAssign)
+		operator.Post("/license/update", handleUpdateLicense)
+		operator.Post("/license/unassign", handleUnassignLicense)
+		viewer.Get("/license/{id}/history", handleLicenseHistory) // история назначений/изменений
+		viewer.Get("/computers", handleComputers)                 // список ПК из LDAP
+		viewer.Get("/licenses/reclaim", handleReclaimReport)      // лицензии неактивных пользователей
+		viewer.Get("/licenses/expiring", handleExpiringLicenses)  // ?days= — истекающие лицензии
+		viewer.Get("/licenses/pc-issues", handleLicensePCIssues)  // ПК нет в каталоге или он деактивирован
+
+		// REST-ресурсы (старые /assign, /license/update, /license/unassign, /license/{id}/history — алиасы)
+		viewer.Get("/licenses/{id}", handleLicenseGet)
+		operator.Patch("/licenses/{id}", handleLicensePatch) // {"comment"?, "pc"?, "computer_id"?}
+		operator.Delete("/licenses/{id}", handleLicenseDelete)
+		operator.Put("/licenses/{id}/assignment", handleAssignmentPut) // {"user_id"}
+		operator.Delete("/licenses/{id}/assignment", handleAssignmentDelete)
+		viewer.Get("/licenses/{id}/history", handleLicenseHistory)
+		viewer.Get("/users/{id}", handleUserGet)         // с лицензиями пользователя
+		viewer.Get("/computers/{id}", handleComputerGet) // с лицензиями, привязанными к ПК
+		viewer.Get("/search", handleSearch)              // ?q= — пользователи, ПК, лицензии и встречи
 
 		// API встреч
-		api.Post("/meetings/import", handleImportMeetings)
-		api.Get("/meetings", handleMeetingsState)
+		operator.Post("/meetings/import", handleImportMeetings)
+		viewer.Get("/meetings", handleMeetingsState)
+
+		// Администрирование
+		admin.Get("/admin/sessions", handleSessionsList)           // ?username=
+		admin.Post("/admin/sessions/revoke", handleSessionsRevoke) // {"id"} или {"username"}
+		viewer.Get("/admin/ldap/sync", handleLDAPSyncStatus)       // для UI: «каталог синхронизирован N минут назад»
+		admin.Post("/admin/ldap/sync", handleLDAPSyncStart)
+		admin.Get("/admin/ldap/blocked", handleLDAPBlockedSyncs) // синхронизации, остановленные защитой от массовой деактивации
+		admin.Post("/admin/ldap/sync/confirm", handleLDAPSyncConfirm)
+		admin.Get("/admin/ldap/sync/runs", handleSyncRuns) // журнал запусков синхронизации
+		admin.Post("/admin/licenses/reclaim/run", handleReclaimRun)
+		admin.Post("/admin/licenses/expiry/notify", handleExpiryNotifyRun) // разослать напоминания сейчас
+		admin.Get("/admin/backups", handleBackupsList)
+		admin.Post("/admin/backups", handleBackupCreate)
+		admin.Post("/admin/backups/restore", handleBackupRestore) // ?name=<бэкап> или тело — файл SQLite; без общего таймаута
+		admin.Get("/admin/backups/{name}", handleBackupDownload)
+		admin.Get("/admin/audit", handleAuditList)          // журнал аудита: ?actor=&action=&entity=&entity_id=&from=&to=&failed=
+		admin.Get("/admin/audit/export", handleAuditExport) // тот же журнал файлом: &format=csv|json
+		admin.Get("/admin/tokens", handleAPITokensList)
+		admin.Post("/admin/tokens", handleAPITokenCreate) // {"name", "scopes", "expires_at"}; токен — только в ответе
+		admin.Delete("/admin/tokens/{id}", handleAPITokenRevoke)
+		admin.Get("/admin/lockouts", handleLoginLockoutsList)        // счётчики неудачных входов и блокировки
+		admin.Post("/admin/lockouts/clear", handleLoginLockoutClear) // {"username"} или {"ip"}
 	})
 
 	// Аутентификация
 	r.Get("/login", handleLogin)
 	r.Post("/login", handleLogin)
+	r.Get("/login/options", handleLoginOptions)       // какие способы входа показать на странице входа
+	r.Get("/login/oidc", handleOIDCLogin)             // вход через OpenID Connect: редирект к провайдеру
+	r.Get("/login/oidc/callback", handleOIDCCallback) // возврат от провайдера с code
 	r.Get("/logout", handleLogout)
 
 	// Статика + SPA fallback (готово для React build в будущем).
@@ -63,6 +124,110 @@ func NewHTTPHandler(cfg Config) http.Handler {
 	return r
 }
 
+// parseTrustedProxies разбирает TRUSTED_PROXIES: IP или подсети CIDR; неразборчивые значения — в лог.
+func parseTrustedProxies(list []string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		if a, err := netip.ParseAddr(s); err == nil {
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		logging.Warnf("TRUSTED_PROXIES: cannot parse %q, ignored", s)
+	}
+	return out
+}
+
+// realIP — вместо middleware.RealIP: адрес клиента из X-Forwarded-For / X-Real-IP берётся, только если
+// запрос пришёл от доверенного прокси; иначе r.RemoteAddr остаётся адресом соединения.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClientIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClientIP — адрес клиента по заголовкам прокси; "" — соединение не от доверенного прокси или заголовков нет.
+// X-Forwarded-For читается справа налево: первый адрес, не являющийся доверенным прокси, — клиент
+// (всё левее него мог дописать сам клиент).
+func forwardedClientIP(r *http.Request, trusted []netip.Prefix) string {
+	isTrusted := func(a netip.Addr) bool {
+		a = a.Unmap()
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+
+	remote, err := netip.ParseAddr(clientIP(r))
+	if err != nil || !isTrusted(remote) {
+		return ""
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = a.Unmap().String()
+			if !isTrusted(a) {
+				break
+			}
+		}
+		return client
+	}
+	if a, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return a.Unmap().String()
+	}
+	return ""
+}
+
+// requestTimeout — middleware.Timeout для всех путей, кроме skip.
+func requestTimeout(d time.Duration, skip ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := middleware.Timeout(d)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(skip, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// holdDBLease держит dbLease на чтение до конца обработки запроса; пути skip — без него.
+func holdDBLease(skip ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(skip, r.URL.Path) {
+				dbLease.RLock()
+				defer dbLease.RUnlock()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func requestLogger() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Фоновые задачи и восстановление из бэкапа взаимоисключающие: восстановление закрывает и подменяет
+// файл БД, поэтому не начинается, пока идут синхронизация LDAP, рассылка напоминаний, бэкап или
+// отзыв лицензий, а новые задачи на время восстановления не запускаются (cron просто пропускает запуск).
+
+// dbLease — HTTP-запросы (кроме самого восстановления) держат его на чтение всё время обработки,
+// RestoreBackup берёт на запись перед копией "pre-restore": запросы, начавшиеся раньше, успевают
+// дописать в прежнюю БД (и их изменения попадают в копию), новые ждут, пока откроется восстановленная.
+// Отдельно от dbMu: запрос берёт dbMu на чтение при каждом обращении к БД, вложенный RLock
+// при ожидающем Lock заблокировал бы его.
+var dbLease sync.RWMutex
+
+var errRestoreInProgress = errors.New("restore_in_progress: database restore is running")
+
+var (
+	jobsMu      sync.Mutex
+	jobsRunning = map[string]int{}
+	restoring   bool
+)
+
+// beginJob регистрирует фоновую задачу name; false — идёт восстановление БД.
+func beginJob(name string) bool {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if restoring {
+		return false
+	}
+	jobsRunning[name]++
+	return true
+}
+
+func endJob(name string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if jobsRunning[name] <= 1 {
+		delete(jobsRunning, name)
+		return
+	}
+	jobsRunning[name]--
+}
+
+// beginRestore — false и список выполняющихся задач, если восстанавливать сейчас нельзя.
+func beginRestore() (busy []string, ok bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if restoring {
+		return []string{"restore"}, false
+	}
+	for name := range jobsRunning {
+		busy = append(busy, name)
+	}
+	if len(busy) > 0 {
+		sort.Strings(busy)
+		return busy, false
+	}
+	restoring = true
+	return nil, true
+}
+
+func endRestore() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	restoring = false
+}
+
+func restoreInProgress() bool {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return restoring
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreRefusedWhileJobRunning(t *testing.T) {
+	newTestDB(t, Config{})
+
+	if !beginJob("ldap_sync") {
+		t.Fatal("beginJob refused without restore")
+	}
+	_, err := RestoreBackup(context.Background(), "unused.sqlite")
+	endJob("ldap_sync")
+	if err == nil || !strings.Contains(err.Error(), "restore_busy: ldap_sync") {
+		t.Fatalf("restore during sync: got %v, want restore_busy", err)
+	}
+
+	if _, ok := beginRestore(); !ok {
+		t.Fatal("beginRestore refused with no running jobs")
+	}
+	if beginLDAPSync("test") {
+		endLDAPSync(nil, nil)
+		t.Fatal("ldap sync started during restore")
+	}
+	if _, err := CreateBackup(context.Background(), ""); !errors.Is(err, errRestoreInProgress) {
+		t.Fatalf("backup during restore: got %v, want %v", err, errRestoreInProgress)
+	}
+	endRestore()
+
+	if !beginJob("backup") {
+		t.Fatal("beginJob refused after restore finished")
+	}
+	endJob("backup")
+}
+
+func TestRestoreWaitsForRequestsInFlight(t *testing.T) {
+	newTestDB(t, Config{})
+	prevDir := dataDir
+	SetDataDir(getConfig().DataDir)
+	t.Cleanup(func() { SetDataDir(prevDir) })
+	ctx := context.Background()
+
+	snapshot, err := CreateBackup(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// запрос начался до восстановления и пишет в БД, когда восстановление уже запрошено
+	started, proceed := make(chan struct{}), make(chan struct{})
+	handler := holdDBLease(restorePath)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-proceed
+		conn, err := requireDB()
+		if err == nil {
+			_, err = conn.ExecContext(r.Context(), `INSERT INTO users(identity, name) VALUES('manual:inflight', 'In flight')`)
+		}
+		if err != nil {
+			httpError(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	w := httptest.NewRecorder()
+	requestDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/users/import", nil))
+		close(requestDone)
+	}()
+	<-started
+
+	var res RestoreResult
+	restoreDone := make(chan error, 1)
+	go func() {
+		var err error
+		res, err = RestoreBackup(ctx, filepath.Join(backupDir(), snapshot.Name))
+		restoreDone <- err
+	}()
+	select {
+	case err := <-restoreDone:
+		t.Fatalf("restore did not wait for the request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(proceed)
+	<-requestDone
+	if w.Code != http.StatusOK {
+		t.Fatalf("request in flight failed: %d %s", w.Code, w.Body.String())
+	}
+	if err := <-restoreDone; err != nil {
+		t.Fatal(err)
+	}
+
+	// изменение запроса — в копии "pre-restore", восстановленная БД — как снимок
+	pre, err := sql.Open("sqlite", "file:"+filepath.Join(backupDir(), res.PreRestore)+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pre.Close()
+	n := 0
+	if err := pre.QueryRow(`SELECT COUNT(*) FROM users WHERE identity = 'manual:inflight'`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("pre-restore backup: %d rows from the request in flight, %v", n, err)
+	}
+	conn, err := requireDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() }) // соединение, открытое восстановлением
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE identity = 'manual:inflight'`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("restored db: %d rows from the request in flight, %v", n, err)
+	}
+}
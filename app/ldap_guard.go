@@ -0,0 +1,293 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Защита от массовой деактивации при LDAP-синхронизации.
+// Неверный фильтр или обрезанная выборка не должны разом «выключать» весь каталог:
+// такая синхронизация откатывается, записывается в sync_runs со статусом blocked и ждёт подтверждения админом.
+// Блокировка действует, пока по этому каталогу не пройдёт успешная синхронизация; подтверждение ссылается
+// на конкретный заблокированный запуск и разрешает деактивировать только его список.
+
+var errLDAPSyncBlocked = errors.New("ldap_sync_blocked")
+
+// сколько identity из списка деактивируемых показываем в API/логе
+const ldapBlockedSampleSize = 20
+
+type ldapDeactivateLimit struct {
+	Count     int
+	Percent   float64
+	IsPercent bool
+}
+
+// parseLDAPDeactivateLimit разбирает LDAP_MAX_DEACTIVATE: "50" или "10%". ok=false — ограничения нет.
+func parseLDAPDeactivateLimit(raw string) (limit ldapDeactivateLimit, ok bool, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return limit, false, nil
+	}
+	if p, found := strings.CutSuffix(raw, "%"); found {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v < 0 || v > 100 {
+			return limit, false, fmt.Errorf("bad LDAP_MAX_DEACTIVATE %q", raw)
+		}
+		return ldapDeactivateLimit{Percent: v, IsPercent: true}, true, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return limit, false, fmt.Errorf("bad LDAP_MAX_DEACTIVATE %q", raw)
+	}
+	return ldapDeactivateLimit{Count: v}, true, nil
+}
+
+func (l ldapDeactivateLimit) exceeded(deactivated, activeBefore int) bool {
+	if !l.IsPercent {
+		return deactivated > l.Count
+	}
+	if activeBefore == 0 {
+		return false
+	}
+	return float64(deactivated)*100/float64(activeBefore) > l.Percent
+}
+
+// LDAPBlockedSync — синхронизация, остановленная защитой от массовой деактивации.
+type LDAPBlockedSync struct {
+	RunID           int      `json:"run_id"` // id запуска в sync_runs — его передают в подтверждение
+	Kind            string   `json:"kind"`
+	Mode            string   `json:"mode"`
+	ActiveBefore    int      `json:"active_before"`
+	WouldDeactivate int      `json:"would_deactivate"`
+	Limit           string   `json:"limit"`
+	Sample          []string `json:"sample"`
+	BlockedAt       string   `json:"blocked_at"`
+}
+
+// ldapSyncApproval — подтверждённая админом заблокированная синхронизация.
+type ldapSyncApproval struct {
+	RunID      int
+	Deactivate map[string]bool // что админ видел в заблокированном запуске
+}
+
+// checkLDAPDeactivateLimit вызывается внутри транзакции синхронизации, до commit.
+// С approval превышение лимита допускается, только если деактивируются объекты из подтверждённого списка.
+func checkLDAPDeactivateLimit(kind, mode string, activeBefore int, deactivated []string, approval *ldapSyncApproval) error {
+	raw := getConfig().LDAPMaxDeactivate
+	limit, ok, err := parseLDAPDeactivateLimit(raw)
+	if err != nil {
+		// Не даём опечатке в конфиге молча отключить защиту.
+		return err
+	}
+	if !ok || !limit.exceeded(len(deactivated), activeBefore) {
+		return nil
+	}
+	raw = strings.TrimSpace(raw)
+
+	if approval != nil {
+		extra := 0
+		for _, id := range deactivated {
+			if !approval.Deactivate[id] {
+				extra++
+			}
+		}
+		if extra == 0 {
+			logging.Warnf("ldap %s sync: deactivating %d of %d (limit %s) — confirmed by admin (run #%d)",
+				kind, len(deactivated), activeBefore, raw, approval.RunID)
+			return nil
+		}
+		logging.Errorf("ldap %s sync BLOCKED again: %d objects to deactivate were not in confirmed run #%d", kind, extra, approval.RunID)
+		return fmt.Errorf("%w: %s would deactivate %d of %d (limit %s), %d of them not in confirmed run #%d",
+			errLDAPSyncBlocked, kind, len(deactivated), activeBefore, raw, extra, approval.RunID)
+	}
+
+	logging.Errorf("ldap %s sync BLOCKED: would deactivate %d of %d active (limit %s); previous state kept, confirm via POST /api/admin/ldap/sync/confirm",
+		kind, len(deactivated), activeBefore, raw)
+	return fmt.Errorf("%w: %s would deactivate %d of %d (limit %s)", errLDAPSyncBlocked, kind, len(deactivated), activeBefore, raw)
+}
+
+// currentBlockedSync — действующая блокировка каталога kind: последний запуск со статусом ok или blocked,
+// если это blocked (ошибки связи с LDAP блокировку не снимают). found=false — блокировки нет.
+func currentBlockedSync(ctx context.Context, kind string) (run SyncRun, found bool, err error) {
+	conn, err := requireDB()
+	if err != nil {
+		return run, false, err
+	}
+
+	var deactivated string
+	err = conn.QueryRowContext(ctx, `
+		SELECT id, kind, mode, status, deactivated, active_before, deactivate_limit, started_at
+		FROM sync_runs
+		WHERE kind=? AND status IN ('ok', 'blocked')
+		ORDER BY id DESC
+		LIMIT 1
+	`, kind).Scan(&run.ID, &run.Kind, &run.Mode, &run.Status, &deactivated, &run.ActiveBefore, &run.Limit, &run.StartedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return run, false, nil
+	}
+	if err != nil {
+		return run, false, err
+	}
+	if run.Status != "blocked" {
+		return run, false, nil
+	}
+	if err := json.Unmarshal([]byte(deactivated), &run.Deactivated); err != nil {
+		return run, false, fmt.Errorf("sync run #%d: %w", run.ID, err)
+	}
+	return run, true, nil
+}
+
+func blockedSyncView(run SyncRun) LDAPBlockedSync {
+	sample := run.Deactivated
+	if len(sample) > ldapBlockedSampleSize {
+		sample = sample[:ldapBlockedSampleSize]
+	}
+	return LDAPBlockedSync{
+		RunID:           run.ID,
+		Kind:            run.Kind,
+		Mode:            run.Mode,
+		ActiveBefore:    run.ActiveBefore,
+		WouldDeactivate: len(run.Deactivated),
+		Limit:           run.Limit,
+		Sample:          append([]string{}, sample...),
+		BlockedAt:       run.StartedAt,
+	}
+}
+
+func listLDAPBlockedSyncs(ctx context.Context) ([]LDAPBlockedSync, error) {
+	out := []LDAPBlockedSync{}
+	for _, kind := range []string{"computers", "users"} {
+		run, found, err := currentBlockedSync(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			out = append(out, blockedSyncView(run))
+		}
+	}
+	return out, nil
+}
+
+// =============== API (admin) ===============
+
+type ConfirmLDAPSyncRequest struct {
+	Kind  string `json:"kind"`   // users | computers
+	RunID int    `json:"run_id"` // id заблокированного запуска из GET /api/admin/ldap/blocked
+}
+
+func handleLDAPBlockedSyncs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blocked, err := listLDAPBlockedSyncs(r.Context())
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Blocked []LDAPBlockedSync `json:"blocked"`
+	}{Blocked: blocked})
+}
+
+// подтверждение заблокированной синхронизации: повторяем её в фоне, разрешая деактивировать
+// только то, что было в подтверждённом запуске (результат — GET /api/admin/ldap/sync и журнал sync_runs)
+func handleLDAPSyncConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ldapEnabled() {
+		httpError(w, "LDAP не настроен", http.StatusBadRequest)
+		return
+	}
+
+	var req ConfirmLDAPSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var d ldapDirectory
+	switch strings.ToLower(strings.TrimSpace(req.Kind)) {
+	case "users":
+		d = usersDirectory()
+	case "computers":
+		d = computersDirectory()
+	default:
+		httpError(w, "kind должен быть users или computers", http.StatusBadRequest)
+		return
+	}
+	if req.RunID <= 0 {
+		httpError(w, "укажите run_id заблокированной синхронизации (GET /api/admin/ldap/blocked)", http.StatusBadRequest)
+		return
+	}
+
+	run, found, err := currentBlockedSync(r.Context(), d.kind)
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		httpError(w, "синхронизация "+d.kind+" не заблокирована", http.StatusConflict)
+		return
+	}
+	if run.ID != req.RunID {
+		httpError(w, fmt.Sprintf("заблокированная синхронизация %s изменилась: актуальный запуск #%d, проверьте его список", d.kind, run.ID), http.StatusConflict)
+		return
+	}
+
+	approval := &ldapSyncApproval{RunID: run.ID, Deactivate: make(map[string]bool, len(run.Deactivated))}
+	for _, id := range run.Deactivated {
+		approval.Deactivate[id] = true
+	}
+
+	if !beginLDAPSync("manual") {
+		httpError(w, ldapSyncBusyMessage(), http.StatusConflict)
+		return
+	}
+
+	logging.Warnf("ldap %s sync run #%d (%d to deactivate) confirmed by %q", d.kind, run.ID, len(run.Deactivated), requestActor(r))
+	auditTarget(r, "ldap_sync", d.kind, nil, map[string]int{"run_id": run.ID, "would_deactivate": len(run.Deactivated)})
+
+	// Как и ручной запуск — отдельно от запроса: на большом каталоге синхронизация дольше HTTP-таймаута.
+	go syncLDAPConfirmed(context.WithoutCancel(r.Context()), d, approval)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, struct {
+		Status string `json:"status"`
+		Kind   string `json:"kind"`
+		RunID  int    `json:"run_id"`
+	}{
+		Status: "started",
+		Kind:   d.kind,
+		RunID:  run.ID,
+	})
+}
+
+func syncLDAPConfirmed(ctx context.Context, d ldapDirectory, approval *ldapSyncApproval) {
+	res := LDAPSyncKindResult{}
+	var err error
+	if res.Synced, res.Deactivated, err = syncLDAPDirectory(ctx, d, "manual", approval); err != nil {
+		res.Error = err.Error()
+		logging.Warnf("ldap %s confirmed sync failed: %v", d.kind, err)
+	}
+	// массовая деактивация, которую подтвердили, — как раз тот случай, когда нужен возврат лицензий
+	if d.kind == "users" {
+		afterLDAPSync(ctx, &res, nil)
+		endLDAPSync(&res, nil)
+	} else {
+		afterLDAPSync(ctx, nil, &res)
+		endLDAPSync(nil, &res)
+	}
+}
@@ -2,7 +2,12 @@ package app
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 
@@ -10,7 +15,9 @@ import (
 )
 
 var (
-	ldapCronOnce sync.Once
+	ldapCronOnce   sync.Once
+	expiryCronOnce sync.Once
+	backupCronOnce sync.Once
 )
 
 // StartBackgroundLDAPSync запускает периодическую синхронизацию LDAP (пользователи + ПК).
@@ -28,13 +35,13 @@ func StartBackgroundLDAPSync(ctx context.Context) {
 		// На старте — сразу делаем sync (по умолчанию включено).
 		if cfg.LDAPSyncOnStartup {
 			go func() {
-				EnsureLDAPDataLoaded(ctx)
+				EnsureLDAPDataLoaded(ctx, "startup")
 			}()
 		}
 
 		spec := "@every " + cfg.LDAPSyncEvery.String()
 		if _, err := c.AddFunc(spec, func() {
-			EnsureLDAPDataLoaded(ctx)
+			EnsureLDAPDataLoaded(ctx, "cron")
 		}); err != nil {
 			logging.Warnf("background ldap sync: cannot schedule %q: %v", spec, err)
 			return
@@ -52,6 +59,202 @@ func StartBackgroundLDAPSync(ctx context.Context) {
 	})
 }
 
+// StartBackgroundExpiryNotify запускает рассылку напоминаний об окончании срока лицензий
+// по расписанию EXPIRY_NOTIFY_SCHEDULE. Не зависит от LDAP; без SMTP не запускается.
+func StartBackgroundExpiryNotify(ctx context.Context) {
+	expiryCronOnce.Do(func() {
+		cfg := getConfig()
+		if !smtpEnabled() || len(expiryWindows()) == 0 {
+			logging.Infof("background expiry notify: disabled (SMTP_HOST/SMTP_FROM or EXPIRY_NOTIFY_DAYS not set)")
+			return
+		}
+
+		c := cron.New(cron.WithLogger(cron.VerbosePrintfLogger(loggingAdapter{})))
+		spec := strings.TrimSpace(cfg.ExpiryNotifySchedule)
+		if _, err := c.AddFunc(spec, func() {
+			if _, err := RunExpiryNotifications(ctx); errors.Is(err, errRestoreInProgress) {
+				logging.Infof("expiry notify skipped: database restore is running")
+			} else if err != nil {
+				logging.Errorf("expiry notify failed: %v", err)
+			}
+		}); err != nil {
+			logging.Warnf("background expiry notify: cannot schedule %q: %v", spec, err)
+			return
+		}
+
+		c.Start()
+		logging.Infof("background expiry notify scheduled: %s", spec)
+
+		go func() {
+			<-ctx.Done()
+			ctxStop := c.Stop()
+			<-ctxStop.Done()
+			logging.Infof("background expiry notify stopped")
+		}()
+	})
+}
+
+// StartBackgroundBackup снимает резервные копии SQLite по расписанию BACKUP_SCHEDULE.
+func StartBackgroundBackup(ctx context.Context) {
+	backupCronOnce.Do(func() {
+		cfg := getConfig()
+		spec := strings.TrimSpace(cfg.BackupSchedule)
+		if spec == "" {
+			logging.Infof("background backup: disabled (BACKUP_SCHEDULE not set)")
+			return
+		}
+		if _, err := requireSQLiteBackup(); err != nil {
+			logging.Warnf("background backup: %v", err)
+			return
+		}
+
+		c := cron.New(cron.WithLogger(cron.VerbosePrintfLogger(loggingAdapter{})))
+		if _, err := c.AddFunc(spec, func() {
+			if _, err := CreateBackup(ctx, ""); errors.Is(err, errRestoreInProgress) {
+				logging.Infof("scheduled backup skipped: database restore is running")
+			} else if err != nil {
+				logging.Errorf("scheduled backup failed: %v", err)
+			}
+		}); err != nil {
+			logging.Warnf("background backup: cannot schedule %q: %v", spec, err)
+			return
+		}
+
+		c.Start()
+		logging.Infof("background backup scheduled: %s (keep %d)", spec, cfg.BackupKeep)
+
+		go func() {
+			<-ctx.Done()
+			ctxStop := c.Stop()
+			<-ctxStop.Done()
+			logging.Infof("background backup stopped")
+		}()
+	})
+}
+
+// =============== статус синхронизации ===============
+
+// LDAPSyncKindResult — итог синхронизации одного каталога.
+type LDAPSyncKindResult struct {
+	Synced      int    `json:"synced"`
+	Deactivated int    `json:"deactivated"`
+	Error       string `json:"error,omitempty"`
+}
+
+// LDAPSyncStatus — состояние последней (или текущей) синхронизации.
+type LDAPSyncStatus struct {
+	Running       bool                `json:"running"`
+	Trigger       string              `json:"trigger"`
+	StartedAt     string              `json:"started_at"`
+	FinishedAt    string              `json:"finished_at"`
+	DurationMs    int64               `json:"duration_ms"`
+	Users         *LDAPSyncKindResult `json:"users,omitempty"`
+	Computers     *LDAPSyncKindResult `json:"computers,omitempty"`
+	LastError     string              `json:"last_error,omitempty"`      // по журналу sync_runs, см. lastSyncOutcome
+	LastSuccessAt string              `json:"last_success_at,omitempty"` // по журналу sync_runs, см. lastSyncOutcome
+}
+
+var (
+	ldapSyncMu      sync.Mutex
+	ldapSyncStatus  LDAPSyncStatus
+	ldapSyncStarted time.Time
+	ldapSyncLock    *sql.Conn // соединение с advisory lock синхронизации (PostgreSQL)
+)
+
+// ldapSyncLockID — ключ advisory lock PostgreSQL: синхронизация LDAP одна на все реплики.
+const ldapSyncLockID = migrationsLockID + 1
+
+// beginLDAPSync — single-flight: false, если синхронизация уже идёт (в этом процессе или, на PostgreSQL,
+// на другой реплике) или восстанавливается БД.
+func beginLDAPSync(trigger string) bool {
+	if !beginJob("ldap_sync") {
+		return false
+	}
+	ldapSyncMu.Lock()
+	defer ldapSyncMu.Unlock()
+
+	if ldapSyncStatus.Running {
+		endJob("ldap_sync")
+		return false
+	}
+	lock, ok := lockLDAPSyncReplicas()
+	if !ok {
+		endJob("ldap_sync")
+		return false
+	}
+	ldapSyncLock = lock
+	ldapSyncStarted = time.Now()
+	ldapSyncStatus.Running = true
+	ldapSyncStatus.Trigger = trigger
+	ldapSyncStatus.StartedAt = ldapSyncStarted.UTC().Format(time.RFC3339)
+	return true
+}
+
+// endLDAPSync фиксирует итог; nil — каталог в этом запуске не синхронизировался.
+func endLDAPSync(users, computers *LDAPSyncKindResult) {
+	defer endJob("ldap_sync")
+	ldapSyncMu.Lock()
+	defer ldapSyncMu.Unlock()
+	unlockLDAPSyncReplicas(ldapSyncLock)
+	ldapSyncLock = nil
+
+	now := time.Now()
+	ldapSyncStatus.Running = false
+	ldapSyncStatus.FinishedAt = now.UTC().Format(time.RFC3339)
+	ldapSyncStatus.DurationMs = now.Sub(ldapSyncStarted).Milliseconds()
+	ldapSyncStatus.Users = users
+	ldapSyncStatus.Computers = computers
+	observeLDAPSync(now.Sub(ldapSyncStarted), users, computers)
+}
+
+// lockLDAPSyncReplicas — на PostgreSQL реплики с общей БД не синхронизируют каталог одновременно:
+// иначе каждая проходит деактивацию по тем же таблицам и записывает свои заблокированные запуски.
+// Lock сессионный и держится на отдельном соединении до endLDAPSync; если реплика упала,
+// PostgreSQL снимает его вместе с сессией. На SQLite — (nil, true): там БД у процесса своя.
+func lockLDAPSyncReplicas() (*sql.Conn, bool) {
+	conn, err := requireDB()
+	if err != nil || conn.driver != driverPostgres {
+		return nil, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		logging.Warnf("ldap sync: cannot take replica lock: %v", err)
+		return nil, false
+	}
+	locked := false
+	if err := c.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(CAST($1 AS BIGINT))`, int64(ldapSyncLockID)).Scan(&locked); err != nil {
+		logging.Warnf("ldap sync: cannot take replica lock: %v", err)
+	}
+	if !locked {
+		_ = c.Close()
+		return nil, false
+	}
+	return c, true
+}
+
+func unlockLDAPSyncReplicas(c *sql.Conn) {
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.ExecContext(ctx, `SELECT pg_advisory_unlock(CAST($1 AS BIGINT))`, int64(ldapSyncLockID)); err != nil {
+		// соединение с неснятым lock в пул не возвращаем — закрываем, lock снимется с сессией
+		logging.Warnf("ldap sync: cannot release replica lock: %v", err)
+		_ = c.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	_ = c.Close()
+}
+
+func getLDAPSyncStatus() LDAPSyncStatus {
+	ldapSyncMu.Lock()
+	defer ldapSyncMu.Unlock()
+	return ldapSyncStatus
+}
+
 // robfig/cron ожидает интерфейс с Printf; адаптируемся к нашему логгеру.
 type loggingAdapter struct{}
 
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLDAPSyncSingleFlight(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+
+		if !beginLDAPSync("test") {
+			t.Fatal("first sync refused")
+		}
+		if beginLDAPSync("test") {
+			t.Fatal("second sync started while the first is running")
+		}
+		endLDAPSync(nil, nil)
+		if conn.driver != driverPostgres {
+			return
+		}
+
+		// другая реплика: отдельная сессия PostgreSQL держит тот же lock
+		other, err := conn.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		locked := false
+		if err := other.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(CAST($1 AS BIGINT))`, int64(ldapSyncLockID)).Scan(&locked); err != nil || !locked {
+			t.Fatalf("lock from another session after the sync ended: %v, %v", locked, err)
+		}
+		if beginLDAPSync("test") {
+			endLDAPSync(nil, nil)
+			t.Fatal("sync started while another replica holds the lock")
+		}
+		if _, err := other.ExecContext(ctx, `SELECT pg_advisory_unlock(CAST($1 AS BIGINT))`, int64(ldapSyncLockID)); err != nil {
+			t.Fatal(err)
+		}
+		if !beginLDAPSync("test") {
+			t.Fatal("sync refused after the other replica released the lock")
+		}
+		endLDAPSync(nil, nil)
+	})
+}
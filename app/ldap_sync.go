@@ -2,9 +2,11 @@ package app
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 
@@ -70,8 +72,8 @@ func pickName(e *ldap.Entry) string {
 	return ""
 }
 
-// FetchLDAPUsers читает пользователей из LDAP (обычно: участники ldapCfg.GroupDN).
-func FetchLDAPUsers(ctx context.Context) ([]LDAPUser, error) {
+// ldapServiceConn подключается к LDAP и делает service bind (если задан).
+func ldapServiceConn() (*ldap.Conn, error) {
 	if !ldapEnabled() {
 		return nil, fmt.Errorf("ldap is not configured")
 	}
@@ -83,22 +85,23 @@ func FetchLDAPUsers(ctx context.Context) ([]LDAPUser, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ldap dial: %w", err)
 	}
-	defer conn.Close()
 
-	// service bind (если задан)
 	if ldapCfg.BindDN != "" {
 		if err := conn.Bind(ldapCfg.BindDN, ldapCfg.BindPassword); err != nil {
+			conn.Close()
 			return nil, fmt.Errorf("ldap bind (service): %w", err)
 		}
 	}
+	return conn, nil
+}
 
-	attrs := []string{ldapCfg.UserAttribute, "mail", "displayName", "cn", "givenName", "sn"}
+func ldapSearchAll(conn *ldap.Conn, baseDN, filter string, attrs []string) ([]*ldap.Entry, error) {
 	req := ldap.NewSearchRequest(
-		ldapCfg.BaseDN,
+		baseDN,
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		0, 0, false,
-		ldapUsersFilter(),
+		filter,
 		attrs,
 		nil,
 	)
@@ -108,139 +111,254 @@ func FetchLDAPUsers(ctx context.Context) ([]LDAPUser, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ldap search: %w", err)
 	}
+	return sr.Entries, nil
+}
 
-	out := make([]LDAPUser, 0, len(sr.Entries))
-	for _, e := range sr.Entries {
-		login := strings.TrimSpace(e.GetAttributeValue(ldapCfg.UserAttribute))
-		if login == "" {
-			continue
-		}
-		email := strings.TrimSpace(e.GetAttributeValue("mail"))
-		name := pickName(e)
-		out = append(out, LDAPUser{Login: login, Name: name, Email: email})
+func ldapUserFromEntry(e *ldap.Entry) (LDAPUser, bool) {
+	login := strings.TrimSpace(e.GetAttributeValue(ldapCfg.UserAttribute))
+	if login == "" {
+		return LDAPUser{}, false
 	}
-	return out, nil
+	return LDAPUser{
+		Login: login,
+		Name:  pickName(e),
+		Email: strings.TrimSpace(e.GetAttributeValue("mail")),
+	}, true
 }
 
-// FetchLDAPComputers читает список ПК из LDAP (обычно: объекты objectClass=computer).
-func FetchLDAPComputers(ctx context.Context) ([]LDAPComputer, error) {
-	if !ldapEnabled() {
-		return nil, fmt.Errorf("ldap is not configured")
+func ldapComputerFromEntry(e *ldap.Entry) (LDAPComputer, bool) {
+	name := strings.TrimSpace(e.GetAttributeValue("cn"))
+	if name == "" {
+		return LDAPComputer{}, false
 	}
+	return LDAPComputer{
+		Name:        name,
+		DNSHostName: strings.TrimSpace(e.GetAttributeValue("dNSHostName")),
+		Description: strings.TrimSpace(e.GetAttributeValue("description")),
+	}, true
+}
 
-	conn, err := ldap.DialURL(
-		ldapCfg.URL,
-		ldap.DialWithTLSConfig(ldapTLSConfig()),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("ldap dial: %w", err)
-	}
-	defer conn.Close()
+func ldapUserAttrs() []string {
+	return []string{ldapCfg.UserAttribute, "mail", "displayName", "cn", "givenName", "sn", "modifyTimestamp"}
+}
 
-	if ldapCfg.BindDN != "" {
-		if err := conn.Bind(ldapCfg.BindDN, ldapCfg.BindPassword); err != nil {
-			return nil, fmt.Errorf("ldap bind (service): %w", err)
-		}
-	}
+var ldapComputerAttrs = []string{"cn", "dNSHostName", "description", "modifyTimestamp"}
+
+// =============== инкрементальная синхронизация ===============
+
+// ldapCursor — «водяной знак» каталога на момент начала синхронизации.
+// В AD это highestCommittedUSN конкретного DC (USN у каждого DC свой, поэтому запоминаем сервер).
+// В остальных каталогах — максимальный modifyTimestamp среди прочитанных объектов.
+type ldapCursor struct {
+	Server string
+	Attr   string // uSNChanged | modifyTimestamp
+	Value  string
+}
 
-	attrs := []string{"cn", "dNSHostName", "description"}
+func readLDAPCursor(conn *ldap.Conn) (ldapCursor, error) {
 	req := ldap.NewSearchRequest(
-		ldapComputersBaseDN(),
-		ldap.ScopeWholeSubtree,
+		"",
+		ldap.ScopeBaseObject,
 		ldap.NeverDerefAliases,
 		0, 0, false,
-		ldapComputersFilter(),
-		attrs,
+		"(objectClass=*)",
+		[]string{"highestCommittedUSN", "dnsHostName", "serverName"},
 		nil,
 	)
-
-	sr, err := conn.SearchWithPaging(req, 500)
+	sr, err := conn.Search(req)
 	if err != nil {
-		return nil, fmt.Errorf("ldap search: %w", err)
+		return ldapCursor{}, fmt.Errorf("ldap rootDSE: %w", err)
 	}
-
-	out := make([]LDAPComputer, 0, len(sr.Entries))
-	for _, e := range sr.Entries {
-		name := strings.TrimSpace(e.GetAttributeValue("cn"))
-		if name == "" {
-			continue
-		}
-		out = append(out, LDAPComputer{
-			Name:        name,
-			DNSHostName: strings.TrimSpace(e.GetAttributeValue("dNSHostName")),
-			Description: strings.TrimSpace(e.GetAttributeValue("description")),
-		})
+	if len(sr.Entries) == 0 {
+		return ldapCursor{Server: ldapCfg.URL, Attr: "modifyTimestamp"}, nil
 	}
-	return out, nil
-}
 
-// SyncLDAPUsersToDB подтягивает ВСЕХ пользователей из LDAP и делает upsert в SQLite.
-// Пользователей LDAP, которых не оказалось в новой выборке, помечаем active=0 (не удаляем — чтобы не ломать назначения лицензий).
-func SyncLDAPUsersToDB(ctx context.Context) (synced int, deactivated int, err error) {
-	users, err := FetchLDAPUsers(ctx)
-	if err != nil {
-		return 0, 0, err
+	e := sr.Entries[0]
+	server := strings.TrimSpace(e.GetAttributeValue("serverName"))
+	if server == "" {
+		server = strings.TrimSpace(e.GetAttributeValue("dnsHostName"))
 	}
-
-	conn, err := requireDB()
-	if err != nil {
-		return 0, 0, err
+	if server == "" {
+		server = ldapCfg.URL
 	}
 
-	tx, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		return 0, 0, err
+	if usn := strings.TrimSpace(e.GetAttributeValue("highestCommittedUSN")); usn != "" {
+		return ldapCursor{Server: server, Attr: "uSNChanged", Value: usn}, nil
 	}
-	defer func() {
+	return ldapCursor{Server: server, Attr: "modifyTimestamp"}, nil
+}
+
+// deltaClause — условие «изменён после прошлого курсора».
+func deltaClause(st LDAPSyncState) (string, error) {
+	switch st.CursorAttr {
+	case "uSNChanged":
+		n, err := strconv.ParseInt(st.Cursor, 10, 64)
 		if err != nil {
-			_ = tx.Rollback()
+			return "", fmt.Errorf("bad usn cursor %q: %w", st.Cursor, err)
 		}
-	}()
-
-	beforeActive := 0
-	_ = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE source='ldap' AND active=1`).Scan(&beforeActive)
-
-	if _, err := MarkAllLDAPUsersInactive(ctx, tx); err != nil {
-		return 0, 0, err
+		return fmt.Sprintf("(uSNChanged>=%d)", n+1), nil
+	case "modifyTimestamp":
+		// >= — граничные объекты перечитаются повторно, это безопасно (upsert идемпотентен).
+		return fmt.Sprintf("(modifyTimestamp>=%s)", ldap.EscapeFilter(st.Cursor)), nil
+	default:
+		return "", fmt.Errorf("unknown cursor attr %q", st.CursorAttr)
 	}
+}
 
-	for _, u := range users {
-		if err := UpsertLDAPUser(ctx, tx, u.Login, u.Name, u.Email); err != nil {
-			return 0, 0, err
+// maxModifyTimestamp — новый курсор для каталогов без USN.
+// GeneralizedTime (YYYYMMDDHHMMSSZ) сравнивается как строка.
+func maxModifyTimestamp(entries []*ldap.Entry, prev string) string {
+	out := prev
+	for _, e := range entries {
+		if v := strings.TrimSpace(e.GetAttributeValue("modifyTimestamp")); v > out {
+			out = v
 		}
-		synced++
 	}
+	return out
+}
+
+// ldapDirectory описывает синхронизируемый каталог (пользователи или ПК),
+// чтобы полная и инкрементальная синхронизация были общими для обоих.
+type ldapDirectory struct {
+	kind   string // users | computers — ключ в ldap_sync_state
+	baseDN string
+	filter string
+	// objectFilter — объекты той же категории, в т.ч. не попадающие в filter
+	// (например, отключённые учётки): по нему в delta-режиме ищем выпавшие из выборки.
+	objectFilter string
+	attrs        []string
+
+	identity         func(e *ldap.Entry) string
+	upsert           func(ctx context.Context, tx *dbTx, e *ldap.Entry) (bool, error)
+	activeIdentities func(ctx context.Context, tx *dbTx) (map[string]struct{}, error)
+	deactivateExcept func(ctx context.Context, tx *dbTx, seen []string) ([]string, error)
+	deactivate       func(ctx context.Context, tx *dbTx, identities []string) ([]string, error)
+}
 
-	afterActive := 0
-	_ = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE source='ldap' AND active=1`).Scan(&afterActive)
-	if beforeActive > afterActive {
-		deactivated = beforeActive - afterActive
+func usersDirectory() ldapDirectory {
+	return ldapDirectory{
+		kind:         "users",
+		baseDN:       ldapCfg.BaseDN,
+		filter:       ldapUsersFilter(),
+		objectFilter: fmt.Sprintf("(%s=*)", ldapCfg.UserAttribute),
+		attrs:        ldapUserAttrs(),
+		identity: func(e *ldap.Entry) string {
+			if u, ok := ldapUserFromEntry(e); ok {
+				return ldapUserIdentity(u.Login)
+			}
+			return ""
+		},
+		upsert: func(ctx context.Context, tx *dbTx, e *ldap.Entry) (bool, error) {
+			u, ok := ldapUserFromEntry(e)
+			if !ok {
+				return false, nil
+			}
+			return true, UpsertLDAPUser(ctx, tx, u.Login, u.Name, u.Email)
+		},
+		activeIdentities: ActiveLDAPUserIdentities,
+		deactivateExcept: DeactivateLDAPUsersExcept,
+		deactivate:       DeactivateLDAPUsers,
 	}
+}
 
-	if err := tx.Commit(); err != nil {
-		return 0, 0, err
+func computersDirectory() ldapDirectory {
+	return ldapDirectory{
+		kind:         "computers",
+		baseDN:       ldapComputersBaseDN(),
+		filter:       ldapComputersFilter(),
+		objectFilter: "(objectClass=computer)",
+		attrs:        ldapComputerAttrs,
+		identity: func(e *ldap.Entry) string {
+			if pc, ok := ldapComputerFromEntry(e); ok {
+				return ldapComputerIdentity(pc.Name)
+			}
+			return ""
+		},
+		upsert: func(ctx context.Context, tx *dbTx, e *ldap.Entry) (bool, error) {
+			pc, ok := ldapComputerFromEntry(e)
+			if !ok {
+				return false, nil
+			}
+			return true, UpsertLDAPComputer(ctx, tx, pc.Name, pc.DNSHostName, pc.Description)
+		},
+		activeIdentities: ActiveLDAPComputerIdentities,
+		deactivateExcept: DeactivateLDAPComputersExcept,
+		deactivate:       DeactivateLDAPComputers,
 	}
+}
 
-	logging.Infof("ldap sync done: synced=%d deactivated=%d", synced, deactivated)
-	return synced, deactivated, nil
+// chooseLDAPSyncMode: delta — только если включён LDAP_SYNC_MODE=delta, курсор валиден
+// для того же сервера и полная сверка (ловит удалённые объекты) была не слишком давно.
+func chooseLDAPSyncMode(st LDAPSyncState, found bool, cur ldapCursor) string {
+	c := getConfig()
+	if !strings.EqualFold(strings.TrimSpace(c.LDAPSyncMode), "delta") {
+		return "full"
+	}
+	if !found || st.Cursor == "" || st.Server != cur.Server || st.CursorAttr != cur.Attr {
+		return "full"
+	}
+	lastFull, err := time.Parse(time.RFC3339, st.LastFullAt)
+	if err != nil || (c.LDAPFullSyncEvery > 0 && time.Since(lastFull) >= c.LDAPFullSyncEvery) {
+		return "full"
+	}
+	return "delta"
 }
 
-// SyncLDAPComputersToDB подтягивает ПК из LDAP и делает upsert в SQLite.
-// Отсутствующие в новой выборке — помечаем active=0.
-func SyncLDAPComputersToDB(ctx context.Context) (synced int, deactivated int, err error) {
-	pcs, err := FetchLDAPComputers(ctx)
+// syncLDAPDirectory — общая синхронизация каталога в SQLite.
+// full: читаем всё, делаем upsert и деактивируем отсутствующих.
+// delta: читаем только изменённые с прошлого курсора объекты; выпавшие из фильтра — деактивируем.
+// Удалённые объекты в delta не видны — их ловит периодическая полная сверка (LDAP_FULL_SYNC_EVERY).
+// Если деактивация превышает LDAP_MAX_DEACTIVATE, транзакция откатывается
+// (кроме подтверждённой админом синхронизации с тем же списком деактивируемых — approval).
+func syncLDAPDirectoryTx(ctx context.Context, d ldapDirectory, approval *ldapSyncApproval) (res ldapSyncResult, err error) {
+	conn, err := ldapServiceConn()
 	if err != nil {
-		return 0, 0, err
+		return res, err
 	}
+	defer conn.Close()
 
-	conn, err := requireDB()
+	// Курсор читаем ДО выборки: изменения, случившиеся во время синхронизации, попадут в следующую.
+	cur, err := readLDAPCursor(conn)
 	if err != nil {
-		return 0, 0, err
+		return res, err
 	}
 
-	tx, err := conn.BeginTx(ctx, nil)
+	st, found, err := GetLDAPSyncState(ctx, d.kind)
 	if err != nil {
-		return 0, 0, err
+		return res, err
+	}
+	mode := chooseLDAPSyncMode(st, found, cur)
+	res.Mode = mode
+
+	var entries, dropped []*ldap.Entry
+	if mode == "delta" {
+		clause, e := deltaClause(st)
+		if e != nil {
+			return res, e
+		}
+		entries, err = ldapSearchAll(conn, d.baseDN, "(&"+d.filter+clause+")", d.attrs)
+		if err != nil {
+			return res, err
+		}
+		dropped, err = ldapSearchAll(conn, d.baseDN, "(&"+d.objectFilter+"(!"+d.filter+")"+clause+")", d.attrs)
+		if err != nil {
+			return res, err
+		}
+	} else {
+		entries, err = ldapSearchAll(conn, d.baseDN, d.filter, d.attrs)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	dbConn, err := requireDB()
+	if err != nil {
+		return res, err
+	}
+
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
 	}
 	defer func() {
 		if err != nil {
@@ -248,46 +366,200 @@ func SyncLDAPComputersToDB(ctx context.Context) (synced int, deactivated int, er
 		}
 	}()
 
-	beforeActive := 0
-	_ = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM computers WHERE source='ldap' AND active=1`).Scan(&beforeActive)
+	wasActive, err := d.activeIdentities(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+	activeBefore := len(wasActive)
 
-	if _, err := MarkAllLDAPComputersInactive(ctx, tx); err != nil {
-		return 0, 0, err
+	seen := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ok, err := d.upsert(ctx, tx, e)
+		if err != nil {
+			return res, err
+		}
+		if !ok {
+			continue
+		}
+		id := d.identity(e)
+		seen = append(seen, id)
+		if _, ok := wasActive[id]; !ok {
+			res.Activated = append(res.Activated, id)
+		}
+		res.Synced++
+	}
+
+	var deactivatedIDs []string
+	if mode == "delta" {
+		gone := make([]string, 0, len(dropped))
+		for _, e := range dropped {
+			if id := d.identity(e); id != "" {
+				gone = append(gone, id)
+			}
+		}
+		if deactivatedIDs, err = d.deactivate(ctx, tx, gone); err != nil {
+			return res, err
+		}
+	} else {
+		if deactivatedIDs, err = d.deactivateExcept(ctx, tx, seen); err != nil {
+			return res, err
+		}
 	}
+	res.Deactivated = deactivatedIDs
+	res.ActiveBefore = activeBefore
+	res.Limit = strings.TrimSpace(getConfig().LDAPMaxDeactivate)
 
-	for _, pc := range pcs {
-		if err := UpsertLDAPComputer(ctx, tx, pc.Name, pc.DNSHostName, pc.Description); err != nil {
-			return 0, 0, err
+	if err = checkLDAPDeactivateLimit(d.kind, mode, activeBefore, deactivatedIDs, approval); err != nil {
+		return res, err
+	}
+
+	next := LDAPSyncState{Kind: d.kind, Server: cur.Server, CursorAttr: cur.Attr, Cursor: cur.Value, LastFullAt: st.LastFullAt}
+	if cur.Attr == "modifyTimestamp" {
+		prev := ""
+		if mode == "delta" {
+			prev = st.Cursor
 		}
-		synced++
+		next.Cursor = maxModifyTimestamp(entries, prev)
+		next.Cursor = maxModifyTimestamp(dropped, next.Cursor)
+	}
+	if mode == "full" {
+		next.LastFullAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err = SaveLDAPSyncState(ctx, tx, next); err != nil {
+		return res, err
 	}
 
-	afterActive := 0
-	_ = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM computers WHERE source='ldap' AND active=1`).Scan(&afterActive)
-	if beforeActive > afterActive {
-		deactivated = beforeActive - afterActive
+	if err = tx.Commit(); err != nil {
+		return res, err
 	}
 
-	if err := tx.Commit(); err != nil {
+	logging.Infof("ldap %s sync done: mode=%s synced=%d activated=%d deactivated=%d cursor=%s:%s",
+		d.kind, mode, res.Synced, len(res.Activated), len(res.Deactivated), next.CursorAttr, next.Cursor)
+	return res, nil
+}
+
+// ldapSyncResult — итог синхронизации каталога (для журнала sync_runs).
+type ldapSyncResult struct {
+	Mode        string
+	Synced      int
+	Activated   []string
+	Deactivated []string
+
+	ActiveBefore int    // активных объектов до синхронизации
+	Limit        string // LDAP_MAX_DEACTIVATE на момент запуска
+}
+
+// syncLDAPDirectory выполняет синхронизацию и записывает её в журнал sync_runs
+// (в т.ч. неудачную или заблокированную защитой от массовой деактивации).
+func syncLDAPDirectory(ctx context.Context, d ldapDirectory, trigger string, approval *ldapSyncApproval) (synced int, deactivated int, err error) {
+	started := time.Now()
+	res, err := syncLDAPDirectoryTx(ctx, d, approval)
+
+	run := SyncRun{
+		Kind:        d.kind,
+		Trigger:     trigger,
+		Mode:        res.Mode,
+		Status:      "ok",
+		Forced:      approval != nil,
+		StartedAt:   started.UTC().Format(time.RFC3339),
+		FinishedAt:  time.Now().UTC().Format(time.RFC3339),
+		DurationMs:  time.Since(started).Milliseconds(),
+		Synced:      res.Synced,
+		Activated:   res.Activated,
+		Deactivated: res.Deactivated,
+
+		ActiveBefore: res.ActiveBefore,
+		Limit:        res.Limit,
+	}
+	if err != nil {
+		run.Status = "error"
+		if errors.Is(err, errLDAPSyncBlocked) {
+			run.Status = "blocked"
+		}
+		run.Error = err.Error()
+	}
+	// Журнал пишем отдельно от транзакции синхронизации: откат не должен стирать факт запуска.
+	if e := InsertSyncRun(context.WithoutCancel(ctx), run); e != nil {
+		logging.Warnf("sync_runs insert error: %v", e)
+	}
+
+	if err != nil {
 		return 0, 0, err
 	}
+	return res.Synced, len(res.Deactivated), nil
+}
 
-	logging.Infof("ldap computers sync done: synced=%d deactivated=%d", synced, deactivated)
-	return synced, deactivated, nil
+// SyncLDAPUsersToDB подтягивает пользователей из LDAP и делает upsert в SQLite.
+// Пользователей LDAP, которых не оказалось в новой выборке, помечаем active=0 (не удаляем — чтобы не ломать назначения лицензий).
+// Режим (полный / инкрементальный) выбирается по LDAP_SYNC_MODE и сохранённому курсору.
+func SyncLDAPUsersToDB(ctx context.Context, trigger string) (synced int, deactivated int, err error) {
+	if !ldapEnabled() {
+		return 0, 0, fmt.Errorf("ldap is not configured")
+	}
+	return syncLDAPDirectory(ctx, usersDirectory(), trigger, nil)
 }
 
-// EnsureLDAPUsersLoaded — удобная обёртка для Init(): если LDAP включён, пытаемся синхронизировать.
-func EnsureLDAPDataLoaded(ctx context.Context) {
+// SyncLDAPComputersToDB подтягивает ПК из LDAP и делает upsert в SQLite.
+// Отсутствующие в новой выборке — помечаем active=0.
+func SyncLDAPComputersToDB(ctx context.Context, trigger string) (synced int, deactivated int, err error) {
+	if !ldapEnabled() {
+		return 0, 0, fmt.Errorf("ldap is not configured")
+	}
+	return syncLDAPDirectory(ctx, computersDirectory(), trigger, nil)
+}
+
+// EnsureLDAPDataLoaded синхронизирует пользователей и ПК (если LDAP включён).
+// trigger — кто запустил: startup / cron / manual. Синхронизации не пересекаются:
+// если одна уже идёт, вызов пропускается и возвращает false.
+func EnsureLDAPDataLoaded(ctx context.Context, trigger string) bool {
 	if !ldapEnabled() {
-		return
+		return false
+	}
+	if !beginLDAPSync(trigger) {
+		logging.Infof("ldap sync (%s) skipped: another sync is running", trigger)
+		return false
 	}
-	if _, _, err := SyncLDAPUsersToDB(ctx); err != nil {
+	syncLDAPAll(ctx, trigger)
+	return true
+}
+
+// syncLDAPAll — тело синхронизации; вызывается только после успешного beginLDAPSync.
+func syncLDAPAll(ctx context.Context, trigger string) {
+	users := LDAPSyncKindResult{}
+	var err error
+	if users.Synced, users.Deactivated, err = SyncLDAPUsersToDB(ctx, trigger); err != nil {
+		users.Error = err.Error()
 		logging.Warnf("ldap users sync failed: %v", err)
 	}
-	if _, _, err := SyncLDAPComputersToDB(ctx); err != nil {
+
+	computers := LDAPSyncKindResult{}
+	if computers.Synced, computers.Deactivated, err = SyncLDAPComputersToDB(ctx, trigger); err != nil {
+		computers.Error = err.Error()
 		logging.Warnf("ldap computers sync failed: %v", err)
 	}
+
+	afterLDAPSync(ctx, &users, &computers)
+	endLDAPSync(&users, &computers)
 }
 
-// compile-time sanity
-var _ = sql.ErrNoRows
+// afterLDAPSync — шаги после синхронизации (обычной или подтверждённой); nil — этот каталог
+// не синхронизировался.
+func afterLDAPSync(ctx context.Context, users, computers *LDAPSyncKindResult) {
+	// Появились новые ПК — привязываем к ним лицензии со свободным текстом в pc.
+	if computers != nil && computers.Error == "" {
+		if conn, err := requireDB(); err == nil {
+			if n, err := linkLicenseComputers(ctx, conn); err != nil {
+				logging.Warnf("link license computers failed: %v", err)
+			} else if n > 0 {
+				logging.Infof("linked %d licenses to computers", n)
+			}
+		}
+	}
+
+	// Пользователи могли стать неактивными — применяем политику возврата их лицензий.
+	if users != nil && users.Error == "" {
+		if _, err := RunReclaimPolicy(ctx); err != nil {
+			logging.Warnf("reclaim policy failed: %v", err)
+		}
+	}
+}
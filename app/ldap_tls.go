@@ -11,16 +11,22 @@ import (
 
 func ldapTLSConfig() *tls.Config {
 	c := getConfig()
-	cfg := &tls.Config{InsecureSkipVerify: c.LDAPTLSInsecureSkipVerify}
+	return tlsConfigWithCA("LDAP_CA_FILE", c.LDAPCAFile, c.LDAPTLSInsecureSkipVerify)
+}
+
+// tlsConfigWithCA — TLS с дополнительным корневым сертификатом из файла (корпоративный CA);
+// env — имя переменной для сообщений в логе.
+func tlsConfigWithCA(env, caPath string, insecure bool) *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
 
-	caPath := strings.TrimSpace(c.LDAPCAFile)
+	caPath = strings.TrimSpace(caPath)
 	if caPath == "" {
 		return cfg
 	}
 
 	pem, err := os.ReadFile(caPath)
 	if err != nil {
-		logging.Warnf("LDAP_CA_FILE read error (%s): %v", caPath, err)
+		logging.Warnf("%s read error (%s): %v", env, caPath, err)
 		return cfg
 	}
 
@@ -29,7 +35,7 @@ func ldapTLSConfig() *tls.Config {
 		pool = x509.NewCertPool()
 	}
 	if ok := pool.AppendCertsFromPEM(pem); !ok {
-		logging.Warnf("LDAP_CA_FILE (%s): no certs appended", caPath)
+		logging.Warnf("%s (%s): no certs appended", env, caPath)
 	}
 
 	cfg.RootCAs = pool
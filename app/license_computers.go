@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Привязка лицензий к справочнику ПК (computers, синхронизируется из LDAP).
+// licenses.computer_id — ссылка на ПК; licenses.pc хранит имя ПК, а для старых записей,
+// которые не удалось сопоставить, остаётся свободным текстом.
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// linkLicenseComputers сопоставляет ещё не привязанные лицензии с активными ПК по name / dns_host_name.
+func linkLicenseComputers(ctx context.Context, e execer) (int64, error) {
+	res, err := e.ExecContext(ctx, `
+		UPDATE licenses SET computer_id = (
+			SELECT c.id FROM computers c
+			WHERE c.active = 1 AND (unicode_lower(c.name) = unicode_lower(TRIM(licenses.pc)) OR unicode_lower(c.dns_host_name) = unicode_lower(TRIM(licenses.pc)))
+			ORDER BY c.id LIMIT 1
+		)
+		WHERE computer_id IS NULL AND TRIM(pc) <> ''
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// resolveLicenseComputer ищет активный ПК по id (если задан) или по имени / DNS-имени.
+// Ошибка computer_not_found — такого активного ПК нет.
+func resolveLicenseComputer(ctx context.Context, tx *dbTx, computerID int, pc string) (Computer, error) {
+	var c Computer
+	var err error
+	if computerID > 0 {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, name, dns_host_name, description FROM computers WHERE id=? AND active=1
+		`, computerID).Scan(&c.ID, &c.Name, &c.DNSHostName, &c.Description)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, name, dns_host_name, description FROM computers
+			WHERE active=1 AND (unicode_lower(name) = unicode_lower(?) OR unicode_lower(dns_host_name) = unicode_lower(?))
+			ORDER BY id LIMIT 1
+		`, pc, pc).Scan(&c.ID, &c.Name, &c.DNSHostName, &c.Description)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("computer_not_found")
+	}
+	return c, err
+}
+
+// computersDirectoryInUse — есть ли вообще активные ПК. Без справочника (LDAP не настроен)
+// поле pc остаётся свободным текстом, как раньше.
+func computersDirectoryInUse(ctx context.Context, tx *dbTx) (bool, error) {
+	n := 0
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM computers WHERE active=1`).Scan(&n)
+	return n > 0, err
+}
+
+// LicensePCIssue — лицензия, ПК которой отсутствует в справочнике или деактивирован.
+type LicensePCIssue struct {
+	LicenseID    int    `json:"license_id"`
+	Key          string `json:"key"`
+	PC           string `json:"pc"`
+	ComputerID   int    `json:"computer_id"`
+	ComputerName string `json:"computer_name"`
+	Status       string `json:"status"` // missing — нет в справочнике; inactive — ПК деактивирован
+}
+
+// ListLicensePCIssues — отчёт о расхождениях licenses.pc со справочником ПК.
+func ListLicensePCIssues(ctx context.Context) ([]LicensePCIssue, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT l.id, l.key, l.pc, COALESCE(c.id, 0), COALESCE(c.name, ''),
+			CASE WHEN c.id IS NULL THEN 'missing' ELSE 'inactive' END
+		FROM licenses l
+		LEFT JOIN computers c ON c.id = l.computer_id
+		WHERE (l.computer_id IS NULL AND TRIM(l.pc) <> '') OR c.active = 0
+		ORDER BY l.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LicensePCIssue
+	for rows.Next() {
+		var it LicensePCIssue
+		if err := rows.Scan(&it.LicenseID, &it.Key, &it.PC, &it.ComputerID, &it.ComputerName, &it.Status); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// =============== API ===============
+
+// лицензии, чей ПК не найден в справочнике или деактивирован
+func handleLicensePCIssues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	items, err := ListLicensePCIssues(r.Context())
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	missing, inactive := 0, 0
+	for _, it := range items {
+		if it.Status == "missing" {
+			missing++
+		} else {
+			inactive++
+		}
+	}
+
+	writeJSON(w, struct {
+		Missing  int              `json:"missing"`
+		Inactive int              `json:"inactive"`
+		Items    []LicensePCIssue `json:"items"`
+	}{Missing: missing, Inactive: inactive, Items: items})
+}
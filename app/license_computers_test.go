@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestImportLicensesLinksOnlyImportedRows(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		exec := func(q string, args ...any) {
+			t.Helper()
+			if _, err := conn.ExecContext(ctx, q, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		computerID := func(key string) int {
+			t.Helper()
+			var id sql.NullInt64
+			if err := conn.QueryRowContext(ctx, `SELECT computer_id FROM licenses WHERE key=?`, key).Scan(&id); err != nil {
+				t.Fatalf("%s: %v", key, err)
+			}
+			return int(id.Int64)
+		}
+
+		exec(`INSERT INTO computers(identity, name, dns_host_name) VALUES('ldap:pc-1', 'PC-1', 'pc-1.corp.test')`)
+		var pcID int
+		if err := conn.QueryRowContext(ctx, `SELECT id FROM computers WHERE identity='ldap:pc-1'`).Scan(&pcID); err != nil {
+			t.Fatal(err)
+		}
+		// лицензия, заведённая раньше и не привязанная (например, ПК добавили в справочник позже)
+		exec(`INSERT INTO licenses(key, pc) VALUES('OLD', 'pc-1')`)
+
+		for _, dryRun := range []bool{true, false} {
+			if _, err := getStore().ImportLicenses(ctx, "test", []LicenseImport{
+				{Key: "NEW-1", PC: "pc-1.CORP.test"},
+				{Key: "NEW-2", PC: "unknown-pc"},
+			}, dryRun); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := computerID("NEW-1"); got != pcID {
+			t.Errorf("imported license: computer_id %d, want %d", got, pcID)
+		}
+		if got := computerID("NEW-2"); got != 0 {
+			t.Errorf("license with an unknown pc: computer_id %d, want none", got)
+		}
+		if got := computerID("OLD"); got != 0 {
+			t.Errorf("import relinked a license it did not import: computer_id %d", got)
+		}
+	})
+}
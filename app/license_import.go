@@ -0,0 +1,269 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Импорт лицензий из файла (multipart/form-data на /api/licenses/import):
+//   file               — CSV (разделитель ; , или табуляция; UTF-8 или Windows-1251) или XLSX (первый лист);
+//   header=true|false  — первая непустая строка — заголовок (по умолчанию true);
+//   column_<поле>      — колонка для поля: имя из заголовка, номер с 1 или буква (A, B, ...).
+// Поля: key, comment, pc, user_login, product, version, license_type, purchase_date, expires_at.
+// Без column_* колонки узнаются по заголовку (см. licenseImportColumns), а без заголовка ключ — первая колонка.
+
+const maxLicenseImportUpload = 32 << 20
+
+// licenseImportColumns — поля импорта и заголовки, по которым колонка узнаётся без явного column_<поле>.
+var licenseImportColumns = []struct {
+	field   string
+	aliases []string
+}{
+	{"key", []string{"key", "license_key", "ключ", "лицензия", "лицензионный ключ"}},
+	{"comment", []string{"comment", "комментарий"}},
+	{"pc", []string{"pc", "computer", "hostname", "пк", "компьютер"}},
+	{"user_login", []string{"user_login", "login", "логин", "пользователь"}},
+	{"product", []string{"product", "продукт"}},
+	{"version", []string{"version", "версия"}},
+	{"license_type", []string{"license_type", "type", "тип"}},
+	{"purchase_date", []string{"purchase_date", "дата покупки"}},
+	{"expires_at", []string{"expires_at", "expires", "expiry", "срок", "действует до"}},
+}
+
+// licenseImportFile — строки файла, готовые к ImportLicenses; Rows — номера строк в файле (с 1).
+type licenseImportFile struct {
+	Licenses []LicenseImport
+	Rows     []int
+}
+
+// readLicenseImportForm читает файл и сопоставление колонок из multipart-формы; ошибка — текст для ответа 400.
+func readLicenseImportForm(w http.ResponseWriter, r *http.Request) (licenseImportFile, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLicenseImportUpload)
+	if err := r.ParseMultipartForm(maxLicenseImportUpload); err != nil {
+		return licenseImportFile{}, fmt.Errorf("не удалось прочитать форму: %v", err)
+	}
+	file, hdr, err := r.FormFile("file")
+	if err != nil {
+		return licenseImportFile{}, fmt.Errorf("передайте файл в поле file")
+	}
+	defer file.Close()
+
+	header := true
+	if v := r.FormValue("header"); v != "" {
+		if header, err = strconv.ParseBool(v); err != nil {
+			return licenseImportFile{}, fmt.Errorf("header должен быть true или false")
+		}
+	}
+
+	rows, err := readSpreadsheet(file, hdr)
+	if err != nil {
+		return licenseImportFile{}, err
+	}
+	return mapLicenseImportRows(rows, header, r.MultipartForm.Value)
+}
+
+// readSpreadsheet — все строки файла как текст; формат — по содержимому (XLSX — zip-архив), иначе CSV.
+func readSpreadsheet(file multipart.File, hdr *multipart.FileHeader) ([][]string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readXLSX(data)
+	}
+	if ext := strings.ToLower(filepath.Ext(hdr.Filename)); ext == ".xlsx" || ext == ".xls" {
+		return nil, fmt.Errorf("%s: поддерживается только XLSX (Excel 2007+); старый XLS сохраните как XLSX или CSV", hdr.Filename)
+	}
+	return readCSV(data)
+}
+
+func readXLSX(data []byte) ([][]string, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть XLSX: %v", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("в XLSX нет листов")
+	}
+	// сырые значения: даты — серийные номера Excel (их переводит xlsxDate), а не текст в формате ячейки
+	rows, err := f.GetRows(sheets[0], excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать лист %q: %v", sheets[0], err)
+	}
+	return rows, nil
+}
+
+// xlsxDate переводит серийный номер даты Excel (целое число) в YYYY-MM-DD; остальное возвращает как есть.
+func xlsxDate(v string) string {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return v
+	}
+	t, err := excelize.ExcelDateToTime(float64(n), false)
+	if err != nil {
+		return v
+	}
+	return t.Format(licenseDateLayout)
+}
+
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		// CSV из русского Excel обычно в Windows-1251
+		decoded, err := charmap.Windows1251.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось определить кодировку CSV: %v", err)
+		}
+		data = decoded
+	}
+
+	first, _ := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	switch {
+	case strings.Contains(first, ";"):
+		cr.Comma = ';'
+	case strings.Contains(first, "\t"):
+		cr.Comma = '\t'
+	}
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось разобрать CSV: %v", err)
+	}
+	return rows, nil
+}
+
+// mapLicenseImportRows превращает строки таблицы в LicenseImport по сопоставлению колонок из формы.
+func mapLicenseImportRows(rows [][]string, header bool, form map[string][]string) (licenseImportFile, error) {
+	start := 0
+	for start < len(rows) && isEmptyRow(rows[start]) {
+		start++
+	}
+	var head []string
+	if header && start < len(rows) {
+		head = rows[start]
+		start++
+	}
+
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+
+	cols := map[string]int{}
+	explicit := false
+	for _, c := range licenseImportColumns {
+		v := ""
+		if vals := form["column_"+c.field]; len(vals) > 0 {
+			v = strings.TrimSpace(vals[0])
+		}
+		if v == "" {
+			continue
+		}
+		explicit = true
+		idx, err := columnIndex(v, head)
+		if err == nil && idx >= width {
+			err = fmt.Errorf("в файле нет колонки %q (колонок: %d)", v, width)
+		}
+		if err != nil {
+			return licenseImportFile{}, fmt.Errorf("column_%s: %v", c.field, err)
+		}
+		cols[c.field] = idx
+	}
+	if !explicit {
+		if head == nil {
+			cols["key"] = 0
+		}
+		for i, h := range head {
+			h = strings.ToLower(strings.TrimSpace(h))
+			for _, c := range licenseImportColumns {
+				if _, ok := cols[c.field]; !ok && slices.Contains(c.aliases, h) {
+					cols[c.field] = i
+					break
+				}
+			}
+		}
+	}
+	if _, ok := cols["key"]; !ok {
+		return licenseImportFile{}, fmt.Errorf("не найдена колонка с ключом: укажите column_key (имя колонки, номер или букву)")
+	}
+
+	var out licenseImportFile
+	for i := start; i < len(rows); i++ {
+		row := rows[i]
+		if isEmptyRow(row) {
+			continue
+		}
+		cell := func(field string) string {
+			idx, ok := cols[field]
+			if !ok || idx >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[idx])
+		}
+		out.Licenses = append(out.Licenses, LicenseImport{
+			Key:          cell("key"),
+			Comment:      cell("comment"),
+			PC:           cell("pc"),
+			UserLogin:    cell("user_login"),
+			Product:      cell("product"),
+			Version:      cell("version"),
+			LicenseType:  cell("license_type"),
+			PurchaseDate: xlsxDate(cell("purchase_date")),
+			ExpiresAt:    xlsxDate(cell("expires_at")),
+		})
+		out.Rows = append(out.Rows, i+1)
+	}
+	if len(out.Licenses) == 0 {
+		return licenseImportFile{}, fmt.Errorf("в файле нет строк с данными")
+	}
+	return out, nil
+}
+
+// columnIndex — индекс колонки по номеру с 1, букве (A, B, ..., AA) или имени из заголовка.
+func columnIndex(v string, head []string) (int, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("номер колонки начинается с 1")
+		}
+		return n - 1, nil
+	}
+	for i, h := range head {
+		if strings.EqualFold(strings.TrimSpace(h), v) {
+			return i, nil
+		}
+	}
+	if n, err := excelize.ColumnNameToNumber(v); err == nil {
+		return n - 1, nil
+	}
+	if head == nil {
+		return 0, fmt.Errorf("без заголовка (header=false) колонку задают номером или буквой")
+	}
+	return 0, fmt.Errorf("колонки %q нет в заголовке", v)
+}
+
+func isEmptyRow(row []string) bool {
+	for _, c := range row {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
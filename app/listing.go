@@ -0,0 +1,333 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Постраничная выдача списков (/api/licenses, /api/users/all, /api/computers):
+// ?q=...&sort=<поле>&order=asc|desc&limit=50 и дальше &offset=N или &cursor=<next_cursor>.
+// Без limit список отдаётся целиком, как раньше. Курсор — значения ключей сортировки последней строки
+// (keyset), поэтому страницы не съезжают, если между запросами строки добавились или удалились.
+// Текстовые ключи сортировки и ?q= сравниваются без учёта регистра через unicode_lower (см. init в db.go).
+
+const maxListLimit = 500
+
+// ListParams — сортировка и страница списка.
+type ListParams struct {
+	Sort   string // имя сортировки из набора списка; "" — порядок по умолчанию
+	Desc   bool
+	Limit  int // 0 — без ограничения
+	Offset int
+	Cursor string // next_cursor предыдущей страницы (вместо Offset)
+}
+
+// Page — сведения о странице в ответе списка; Total — число строк под фильтром без учёта страницы.
+type Page struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type sortKey struct {
+	expr    string // SQL-выражение без NULL (COALESCE при необходимости)
+	numeric bool
+	desc    bool
+}
+
+// listOrder — порядок строк; последний ключ — уникальный id, чтобы позиция курсора была однозначной.
+type listOrder []sortKey
+
+// listSorts — допустимые значения ?sort= для списка; ключ "" — порядок по умолчанию.
+type listSorts map[string]listOrder
+
+var idSortKey = sortKey{expr: "id", numeric: true}
+
+func (o listOrder) reversed() listOrder {
+	out := make(listOrder, len(o))
+	for i, k := range o {
+		k.desc = !k.desc
+		out[i] = k
+	}
+	return out
+}
+
+func (o listOrder) orderBy() string {
+	parts := make([]string, len(o))
+	for i, k := range o {
+		parts[i] = k.expr
+		if k.desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (o listOrder) exprs() string {
+	parts := make([]string, len(o))
+	for i, k := range o {
+		parts[i] = k.expr
+	}
+	return strings.Join(parts, ", ")
+}
+
+// after — условие «строка после курсора»: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func (o listOrder) after(vals []any) (string, []any) {
+	var parts []string
+	var args []any
+	for i, k := range o {
+		var conds []string
+		for j := 0; j < i; j++ {
+			conds = append(conds, o[j].expr+" = ?")
+			args = append(args, vals[j])
+		}
+		op := " > ?"
+		if k.desc {
+			op = " < ?"
+		}
+		conds = append(conds, k.expr+op)
+		args = append(args, vals[i])
+		parts = append(parts, "("+strings.Join(conds, " AND ")+")")
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", args
+}
+
+// dest — приёмники для значений ключей сортировки (их SELECT добавляет после колонок строки).
+func (o listOrder) dest() []any {
+	out := make([]any, len(o))
+	for i, k := range o {
+		if k.numeric {
+			out[i] = new(int64)
+		} else {
+			out[i] = new(string)
+		}
+	}
+	return out
+}
+
+type listCursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+func (o listOrder) encodeCursor(sortID string, dest []any) string {
+	c := listCursor{Sort: sortID, Values: make([]any, len(dest))}
+	for i, d := range dest {
+		switch v := d.(type) {
+		case *int64:
+			c.Values[i] = *v
+		case *string:
+			c.Values[i] = *v
+		}
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor проверяет, что курсор выдан для той же сортировки, и приводит значения к типам ключей.
+func (o listOrder) decodeCursor(sortID, s string) ([]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid_cursor")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	var c listCursor
+	if err := dec.Decode(&c); err != nil || c.Sort != sortID || len(c.Values) != len(o) {
+		return nil, fmt.Errorf("invalid_cursor")
+	}
+
+	vals := make([]any, len(o))
+	for i, k := range o {
+		switch v := c.Values[i].(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil || !k.numeric {
+				return nil, fmt.Errorf("invalid_cursor")
+			}
+			vals[i] = n
+		case string:
+			if k.numeric {
+				return nil, fmt.Errorf("invalid_cursor")
+			}
+			vals[i] = v
+		default:
+			return nil, fmt.Errorf("invalid_cursor")
+		}
+	}
+	return vals, nil
+}
+
+// listQuery — выборка списка: cols FROM from WHERE where; страницу и порядок задаёт ListParams.
+type listQuery struct {
+	cols  string
+	from  string
+	where []string
+	args  []any
+}
+
+// run выполняет запрос страницы; scan получает строку и приёмники ключей сортировки (их нужно передать в Scan после колонок).
+// "invalid_sort" / "invalid_cursor" — ошибки параметров запроса.
+func (q listQuery) run(ctx context.Context, conn *dbConn, sorts listSorts, p ListParams, scan func(rows *sql.Rows, keys []any) error) (Page, error) {
+	order, ok := sorts[p.Sort]
+	if !ok {
+		return Page{}, fmt.Errorf("invalid_sort: %q", p.Sort)
+	}
+	sortID := p.Sort + ":asc"
+	if p.Desc {
+		order = order.reversed()
+		sortID = p.Sort + ":desc"
+	}
+
+	where := append([]string{"1=1"}, q.where...)
+	args := append([]any(nil), q.args...)
+	page := Page{Limit: p.Limit, Offset: p.Offset}
+
+	if p.Limit > 0 {
+		if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+q.from+` WHERE `+strings.Join(where, " AND "), args...).Scan(&page.Total); err != nil {
+			return Page{}, err
+		}
+	}
+	if p.Cursor != "" {
+		vals, err := order.decodeCursor(sortID, p.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		cond, condArgs := order.after(vals)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	query := `SELECT ` + q.cols + `, ` + order.exprs() + ` FROM ` + q.from +
+		` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY ` + order.orderBy()
+	if p.Limit > 0 {
+		// строка сверх limit — признак того, что есть следующая страница
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, p.Limit+1, p.Offset)
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+
+	n := 0
+	var last []any
+	for rows.Next() {
+		if p.Limit > 0 && n == p.Limit {
+			page.NextCursor = order.encodeCursor(sortID, last)
+			break
+		}
+		keys := order.dest()
+		if err := scan(rows, keys); err != nil {
+			return Page{}, err
+		}
+		last = keys
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+	if p.Limit == 0 {
+		page.Total = n
+	}
+	return page, nil
+}
+
+// likeContains — шаблон LIKE «содержит s» (без учёта регистра: сравнивать с unicode_lower(...) и ESCAPE '\').
+// unicode_lower, а не lower(): встроенный lower() в SQLite не меняет регистр кириллицы (см. init в db.go).
+func likeContains(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
+
+// searchCond — "(unicode_lower(c1) LIKE ? OR unicode_lower(c2) LIKE ? ...)" по всем колонкам.
+func searchCond(search string, cols ...string) (string, []any) {
+	pattern := likeContains(strings.TrimSpace(search))
+	parts := make([]string, len(cols))
+	args := make([]any, len(cols))
+	for i, c := range cols {
+		parts[i] = "unicode_lower(" + c + `) LIKE ? ESCAPE '\'`
+		args[i] = pattern
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", args
+}
+
+// =============== HTTP ===============
+
+// parseListParams читает ?sort=&order=&limit=&offset=&cursor=; ошибка — текст для ответа 400.
+func parseListParams(q url.Values, sorts listSorts) (ListParams, error) {
+	p := ListParams{Sort: strings.TrimSpace(q.Get("sort")), Cursor: strings.TrimSpace(q.Get("cursor"))}
+	if _, ok := sorts[p.Sort]; !ok {
+		names := make([]string, 0, len(sorts))
+		for name := range sorts {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		return p, fmt.Errorf("sort: допустимые значения — %s", strings.Join(names, ", "))
+	}
+
+	switch strings.ToLower(q.Get("order")) {
+	case "", "asc":
+	case "desc":
+		p.Desc = true
+	default:
+		return p, fmt.Errorf("order должен быть asc или desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return p, fmt.Errorf("limit должен быть от 1 до %d", maxListLimit)
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("некорректный offset")
+		}
+		p.Offset = n
+	}
+	if (p.Offset > 0 || p.Cursor != "") && p.Limit == 0 {
+		return p, fmt.Errorf("offset и cursor используются вместе с limit")
+	}
+	if p.Offset > 0 && p.Cursor != "" {
+		return p, fmt.Errorf("укажите либо offset, либо cursor")
+	}
+	return p, nil
+}
+
+// parseOptionalBool — необязательный параметр true/false.
+func parseOptionalBool(q url.Values, name string) (*bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s должен быть true или false", name)
+	}
+	return &b, nil
+}
+
+// writeListError — ошибки выборки списка: неверный курсор — 400, остальное — 500.
+func writeListError(w http.ResponseWriter, err error) {
+	if strings.Contains(err.Error(), "invalid_cursor") {
+		httpError(w, "некорректный cursor (он действует только с той же сортировкой)", http.StatusBadRequest)
+		return
+	}
+	httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,461 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Защита /login от перебора паролей. Каждая попытка входа — bind в AD, поэтому перебор через onessa
+// нагружает контроллеры домена и блокирует настоящие учётные записи (lockout в AD).
+// Неудачи считаются отдельно по логину и по IP (таблица login_attempts — общая для всех реплик):
+// после каждой неудачи следующая попытка под этим логином разрешена не раньше, чем через паузу
+// (1s, 2s, 4s, ... до минуты), а после LOGIN_MAX_FAILURES / LOGIN_IP_MAX_FAILURES неудач вход блокируется
+// на LOGIN_LOCKOUT. По IP пауза включается после LOGIN_IP_BACKOFF_AFTER неудач: за одним NAT работает
+// весь офис, и несколько опечаток коллег не должны его тормозить.
+// Попытка резервируется до bind: ReserveLoginAttempt одной транзакцией проверяет паузы и заранее
+// засчитывает её как неудачную, поэтому параллельные запросы не проходят в LDAP пачкой мимо проверки.
+// Успешный вход (или ошибка LDAP — не вина пользователя) резерв снимает.
+// Заблокированная попытка до LDAP не доходит и новой неудачей не считается.
+// Счётчик логина сбрасывается успешным входом, любой счётчик — через LOGIN_LOCKOUT без неудач или админом.
+
+const (
+	loginBackoffBase = time.Second
+	loginBackoffMax  = time.Minute
+
+	// loginReserveRetries — сколько раз повторить резервирование, если счётчик параллельно изменила другая попытка.
+	loginReserveRetries = 5
+)
+
+// LoginLockout — счётчик неудачных входов по логину или IP.
+type LoginLockout struct {
+	Kind          string `json:"kind"` // username | ip
+	Value         string `json:"value"`
+	Failures      int    `json:"failures"`
+	LastFailureAt string `json:"last_failure_at"`
+	NextAttemptAt string `json:"next_attempt_at"` // пауза после неудачи (по IP — после LOGIN_IP_BACKOFF_AFTER неудач)
+	LockedUntil   string `json:"locked_until"`    // не пусто — достигнут порог неудач
+}
+
+// LoginReservation — итог ReserveLoginAttempt.
+type LoginReservation struct {
+	Wait       time.Duration // > 0 — попытка не разрешена, столько ждать
+	Locked     bool          // достигнут порог неудач (а не просто пауза после последней)
+	LockedKeys []string      // счётчики, которые эта попытка, если окажется неудачной, довела до порога
+}
+
+// loginAttemptKey — ключ строки login_attempts: "username:<логин>" или "ip:<адрес>".
+func loginAttemptKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// loginAttemptKeys — счётчики, которые затрагивает попытка входа, и порог блокировки каждого.
+func loginAttemptKeys(username, ip string) map[string]int {
+	c := getConfig()
+	keys := map[string]int{}
+	if u := normalizeLogin(username); u != "" {
+		keys[loginAttemptKey("username", u)] = c.LoginMaxFailures
+	}
+	if ip != "" {
+		keys[loginAttemptKey("ip", ip)] = c.LoginIPMaxFailures
+	}
+	return keys
+}
+
+type loginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+
+	// значения в БД как есть — для условия UPDATE (compare-and-swap)
+	rawLast, rawNext, rawLocked string
+}
+
+func readLoginAttempt(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, key string) (loginAttempt, error) {
+	var a loginAttempt
+	err := q.QueryRowContext(ctx, `
+		SELECT failures, last_failure_at, next_attempt_at, locked_until FROM login_attempts WHERE key=?
+	`, key).Scan(&a.failures, &a.rawLast, &a.rawNext, &a.rawLocked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return loginAttempt{}, nil
+		}
+		return loginAttempt{}, err
+	}
+	// пустые и битые значения — нулевое время, то есть «ограничения нет»
+	a.lastFailureAt, _ = time.Parse(time.RFC3339, a.rawLast)
+	a.nextAttemptAt, _ = time.Parse(time.RFC3339, a.rawNext)
+	a.lockedUntil, _ = time.Parse(time.RFC3339, a.rawLocked)
+	return a, nil
+}
+
+var errLoginAttemptConflict = errors.New("login attempt counter changed concurrently")
+
+// ReserveLoginAttempt — перед проверкой пароля: если паузы и блокировки по логину и IP позволяют,
+// засчитывает попытку как неудачную (счётчик, следующая пауза, блокировка по порогу) и возвращает Wait == 0.
+// Иначе — сколько ждать; счётчики при этом не меняются.
+func ReserveLoginAttempt(ctx context.Context, username, ip string) (LoginReservation, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return LoginReservation{}, err
+	}
+
+	// заодно подчищаем счётчики, по которым давно не было неудач (отдельно: не держим блокировки в резерве)
+	now := time.Now().UTC()
+	if _, err := conn.ExecContext(ctx, `DELETE FROM login_attempts WHERE last_failure_at < ? AND locked_until < ?`,
+		now.Add(-getConfig().LoginLockout).Format(time.RFC3339), now.Format(time.RFC3339)); err != nil {
+		logging.Warnf("login attempts cleanup error: %v", err)
+	}
+
+	for i := 0; ; i++ {
+		res, err := reserveLoginAttemptTx(ctx, conn, username, ip)
+		if !errors.Is(err, errLoginAttemptConflict) || i >= loginReserveRetries {
+			return res, err
+		}
+	}
+}
+
+func reserveLoginAttemptTx(ctx context.Context, conn *dbConn, username, ip string) (res LoginReservation, err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return LoginReservation{}, err
+	}
+	defer func() {
+		if err != nil || res.Wait > 0 {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := time.Now().UTC()
+	cfg := getConfig()
+	window := cfg.LoginLockout
+	limits := loginAttemptKeys(username, ip)
+	keys := make([]string, 0, len(limits))
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // один порядок блокировок строк для всех попыток
+
+	// Транзакция начинается с записи: SQLite сразу берёт блокировку на запись (ждёт busy_timeout),
+	// и параллельная попытка читает счётчики уже после коммита этой.
+	for _, key := range keys {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO login_attempts(key) VALUES(?) ON CONFLICT DO NOTHING`, key); err != nil {
+			return LoginReservation{}, err
+		}
+	}
+
+	attempts := make([]loginAttempt, len(keys))
+	for i, key := range keys {
+		if attempts[i], err = readLoginAttempt(ctx, tx, key); err != nil {
+			return LoginReservation{}, err
+		}
+		a := attempts[i]
+		if d := a.lockedUntil.Sub(now); d > 0 {
+			res.Wait, res.Locked = max(res.Wait, d), true
+		}
+		if d := a.nextAttemptAt.Sub(now); d > res.Wait {
+			res.Wait = d
+		}
+	}
+	if res.Wait > 0 {
+		return res, nil
+	}
+
+	for i, key := range keys {
+		prev := attempts[i]
+		a := prev
+		if now.Sub(a.lastFailureAt) > window && now.After(a.lockedUntil) {
+			a = loginAttempt{} // давняя серия неудач — считаем заново
+		}
+		a.failures++
+		a.nextAttemptAt = time.Time{}
+		if d := loginAttemptBackoff(key, a.failures); d > 0 {
+			a.nextAttemptAt = now.Add(d)
+		}
+
+		a.lockedUntil = time.Time{}
+		if limit := limits[key]; limit > 0 && a.failures >= limit {
+			if a.failures == limit {
+				res.LockedKeys = append(res.LockedKeys, key)
+			}
+			a.lockedUntil = now.Add(window)
+		}
+
+		// PostgreSQL (READ COMMITTED) не блокирует строки при чтении: обновляем, только если счётчик
+		// не изменила параллельная попытка, иначе — заново с чтения.
+		r, err := tx.ExecContext(ctx, `
+			UPDATE login_attempts SET failures=?, last_failure_at=?, next_attempt_at=?, locked_until=?
+			WHERE key=? AND failures=? AND last_failure_at=? AND next_attempt_at=? AND locked_until=?
+		`, a.failures, now.Format(time.RFC3339), formatOptionalTime(a.nextAttemptAt), formatOptionalTime(a.lockedUntil),
+			key, prev.failures, prev.rawLast, prev.rawNext, prev.rawLocked)
+		if err != nil {
+			return LoginReservation{}, err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return LoginReservation{}, errLoginAttemptConflict
+		}
+	}
+	return res, tx.Commit()
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// loginBackoff — пауза после n-й неудачи подряд: 1s, 2s, 4s, ... не больше loginBackoffMax.
+func loginBackoff(n int) time.Duration {
+	d := time.Duration(float64(loginBackoffBase) * math.Pow(2, float64(n-1)))
+	return min(d, loginBackoffMax)
+}
+
+// loginAttemptBackoff — пауза после failures неудач подряд по счётчику key (0 — без паузы).
+// По IP первые LOGIN_IP_BACKOFF_AFTER неудач паузы не дают.
+func loginAttemptBackoff(key string, failures int) time.Duration {
+	n := failures
+	if strings.HasPrefix(key, "ip:") {
+		n -= getConfig().LoginIPBackoffAfter
+	}
+	if n <= 0 {
+		return 0
+	}
+	return loginBackoff(n)
+}
+
+// ReleaseLoginAttempt снимает резерв попытки, которая не оказалась неудачной: счётчик уменьшается
+// на единицу, пауза пересчитывается по оставшимся неудачам, блокировка снимается, если без этой
+// попытки порог не достигнут.
+func ReleaseLoginAttempt(ctx context.Context, username, ip string) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	for key, limit := range loginAttemptKeys(username, ip) {
+		if err := releaseLoginAttemptKey(ctx, conn, key, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func releaseLoginAttemptKey(ctx context.Context, conn *dbConn, key string, limit int) error {
+	for i := 0; ; i++ {
+		a, err := readLoginAttempt(ctx, conn, key)
+		if err != nil || a.failures == 0 {
+			return err
+		}
+		failures := a.failures - 1
+		// пауза — как после оставшихся неудач, от времени резерва (last_failure_at): успешный вход
+		// не должен снимать паузу, набранную перебором с того же адреса
+		next := ""
+		if d := loginAttemptBackoff(key, failures); d > 0 && !a.lastFailureAt.IsZero() {
+			next = a.lastFailureAt.Add(d).Format(time.RFC3339)
+		}
+		locked := a.rawLocked
+		if failures < limit {
+			locked = ""
+		}
+
+		r, err := conn.ExecContext(ctx, `
+			UPDATE login_attempts SET failures=?, next_attempt_at=?, locked_until=?
+			WHERE key=? AND failures=? AND last_failure_at=? AND next_attempt_at=? AND locked_until=?
+		`, failures, next, locked, key, a.failures, a.rawLast, a.rawNext, a.rawLocked)
+		if err != nil {
+			return err
+		}
+		if n, _ := r.RowsAffected(); n > 0 {
+			return nil
+		}
+		if i >= loginReserveRetries {
+			return errLoginAttemptConflict
+		}
+	}
+}
+
+// ResetLoginFailures — после успешного входа: счётчик логина удаляется, резерв по IP снимается
+// (сам счётчик IP остаётся: иначе одна известная учётка позволяла бы перебирать остальные с того же адреса).
+func ResetLoginFailures(ctx context.Context, username, ip string) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, `DELETE FROM login_attempts WHERE key=?`, loginAttemptKey("username", normalizeLogin(username))); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return releaseLoginAttemptKey(ctx, conn, loginAttemptKey("ip", ip), getConfig().LoginIPMaxFailures)
+}
+
+// ClearLoginLockout снимает блокировку и обнуляет счётчик; kind — username или ip.
+// "login_lockout_not_found" — счётчика нет.
+func ClearLoginLockout(ctx context.Context, kind, value string) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	res, err := conn.ExecContext(ctx, `DELETE FROM login_attempts WHERE key=?`, loginAttemptKey(kind, value))
+	if err != nil {
+		return err
+	}
+	if a, _ := res.RowsAffected(); a == 0 {
+		return fmt.Errorf("login_lockout_not_found")
+	}
+	return nil
+}
+
+// ListLoginLockouts — действующие счётчики: с блокировкой или с неудачами за последние LOGIN_LOCKOUT.
+func ListLoginLockouts(ctx context.Context) ([]LoginLockout, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	out := []LoginLockout{}
+	err = scanRows(ctx, conn, `
+		SELECT key, failures, last_failure_at, next_attempt_at, locked_until FROM login_attempts
+		WHERE locked_until > ? OR last_failure_at > ?
+		ORDER BY locked_until DESC, last_failure_at DESC
+	`, []any{now.Format(time.RFC3339), now.Add(-getConfig().LoginLockout).Format(time.RFC3339)}, func(rows *sql.Rows) error {
+		var l LoginLockout
+		var key string
+		if err := rows.Scan(&key, &l.Failures, &l.LastFailureAt, &l.NextAttemptAt, &l.LockedUntil); err != nil {
+			return err
+		}
+		l.Kind, l.Value, _ = strings.Cut(key, ":")
+		if l.LockedUntil <= now.Format(time.RFC3339) {
+			l.LockedUntil = "" // блокировка уже истекла, осталась серия неудач
+		}
+		out = append(out, l)
+		return nil
+	})
+	return out, err
+}
+
+// =============== вход ===============
+
+// reserveLogin — перед проверкой пароля: false — попытка не разрешена (ответ уже отправлен).
+func reserveLogin(w http.ResponseWriter, r *http.Request, username, next string) (LoginReservation, bool) {
+	res, err := ReserveLoginAttempt(r.Context(), username, clientIP(r))
+	if err != nil {
+		// без проверки счётчиков в LDAP не пускаем: иначе сбой БД снимал бы защиту от перебора
+		logging.Errorf("login throttle check error: %v", err)
+		auditLogin(r, "loginFailed", username, RoleNone, http.StatusInternalServerError, "throttle_error")
+		redirectToLogin(w, r, next, "Ошибка авторизации, обратитесь к администратору")
+		return res, false
+	}
+	if res.Wait <= 0 {
+		return res, true
+	}
+
+	reason := "backoff"
+	if res.Locked {
+		reason = "locked"
+	}
+	logging.Warnf("handleLogin: attempt for %q from %s blocked (%s, retry in %s)", username, clientIP(r), reason, res.Wait.Round(100*time.Millisecond))
+	writeAudit(r.Context(), r, AuditEntry{
+		Actor:    normalizeLogin(username),
+		Action:   "loginBlocked",
+		Entity:   "session",
+		EntityID: normalizeLogin(username),
+		After:    auditJSON(map[string]any{"reason": reason, "retry_after": int(math.Ceil(res.Wait.Seconds()))}),
+		Status:   http.StatusTooManyRequests,
+	})
+	redirectToLogin(w, r, next, "Слишком много неудачных попыток входа. Повторите через "+retryText(res.Wait))
+	return res, false
+}
+
+// loginFailed — после неверного пароля: попытка уже засчитана при резервировании, остаётся лог блокировок.
+func loginFailed(res LoginReservation) {
+	for _, key := range res.LockedKeys {
+		logging.Warnf("login locked for %s for %s", key, getConfig().LoginLockout)
+	}
+}
+
+// releaseLogin — попытка не дошла до проверки пароля по вине сервера (ошибка LDAP, сессии): снимаем резерв.
+func releaseLogin(r *http.Request, username string) {
+	if err := ReleaseLoginAttempt(r.Context(), username, clientIP(r)); err != nil {
+		logging.Warnf("handleLogin: cannot release login attempt for %q: %v", username, err)
+	}
+}
+
+// retryText — «через сколько» для сообщения на странице входа.
+func retryText(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d сек.", int(math.Ceil(d.Seconds())))
+	}
+	return fmt.Sprintf("%d мин.", int(math.Ceil(d.Minutes())))
+}
+
+// =============== API (admin) ===============
+
+type ClearLockoutRequest struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+func handleLoginLockoutsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := ListLoginLockouts(r.Context())
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"items": items})
+}
+
+// снятие блокировки входа: {"username"} или {"ip"}
+func handleLoginLockoutClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ClearLockoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var kind, value string
+	switch {
+	case strings.TrimSpace(req.Username) != "":
+		kind, value = "username", normalizeLogin(req.Username)
+	case strings.TrimSpace(req.IP) != "":
+		kind, value = "ip", strings.TrimSpace(req.IP)
+	default:
+		httpError(w, "передайте username или ip", http.StatusBadRequest)
+		return
+	}
+
+	if err := ClearLoginLockout(r.Context(), kind, value); err != nil {
+		if strings.Contains(err.Error(), "login_lockout_not_found") {
+			httpError(w, "блокировки нет", http.StatusNotFound)
+			return
+		}
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Infof("login lockout cleared by %q: %s=%s", requestActor(r), kind, value)
+	auditTarget(r, "login_lockout", loginAttemptKey(kind, value), nil, nil)
+	writeJSON(w, map[string]any{"status": "ok"})
+}
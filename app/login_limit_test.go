@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func loginLimitConfig() Config {
+	return Config{
+		LoginMaxFailures:    5,
+		LoginIPMaxFailures:  20,
+		LoginIPBackoffAfter: 5,
+		LoginLockout:        15 * time.Minute,
+	}
+}
+
+func TestReserveLoginAttemptIsAtomic(t *testing.T) {
+	forEachTestDB(t, loginLimitConfig(), func(t *testing.T, _ *dbConn) {
+		const n = 20
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		allowed := 0
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := ReserveLoginAttempt(context.Background(), "ivan", "10.0.0.1")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Wait <= 0 {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != 1 {
+			t.Fatalf("%d of %d parallel attempts for one login reached LDAP, want 1", allowed, n)
+		}
+	})
+}
+
+func TestLoginIPBackoff(t *testing.T) {
+	newTestDB(t, loginLimitConfig())
+	ctx := context.Background()
+
+	// первые LOGIN_IP_BACKOFF_AFTER неудач с адреса и ещё одна — без паузы, дальше — пауза
+	for i := 1; i <= 6; i++ {
+		res, err := ReserveLoginAttempt(ctx, fmt.Sprintf("user%d", i), "10.0.0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Wait > 0 {
+			t.Fatalf("attempt %d from one ip: unexpected wait %s", i, res.Wait)
+		}
+	}
+	res, err := ReserveLoginAttempt(ctx, "user7", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Wait <= 0 || res.Locked {
+		t.Fatalf("attempt 7 from one ip: got %+v, want backoff", res)
+	}
+
+	// другой адрес не затронут
+	if res, err := ReserveLoginAttempt(ctx, "user8", "10.0.0.3"); err != nil || res.Wait > 0 {
+		t.Fatalf("other ip: got %+v, %v", res, err)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	cfg := loginLimitConfig()
+	cfg.LoginIPMaxFailures = 3
+	cfg.LoginIPBackoffAfter = 100
+	newTestDB(t, cfg)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		res, err := ReserveLoginAttempt(ctx, fmt.Sprintf("user%d", i), "10.0.0.4")
+		if err != nil || res.Wait > 0 {
+			t.Fatalf("attempt %d: got %+v, %v", i, res, err)
+		}
+		if wantLocked := i == 3; (len(res.LockedKeys) > 0) != wantLocked {
+			t.Fatalf("attempt %d: locked keys %v", i, res.LockedKeys)
+		}
+	}
+	res, err := ReserveLoginAttempt(ctx, "user4", "10.0.0.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Locked || res.Wait <= 0 {
+		t.Fatalf("attempt 4: got %+v, want lockout", res)
+	}
+}
+
+func TestLoginSuccessReleasesReservation(t *testing.T) {
+	newTestDB(t, loginLimitConfig())
+	ctx := context.Background()
+
+	if res, err := ReserveLoginAttempt(ctx, "ivan", "10.0.0.5"); err != nil || res.Wait > 0 {
+		t.Fatalf("reserve: got %+v, %v", res, err)
+	}
+	if err := ResetLoginFailures(ctx, "ivan", "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ListLoginLockouts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range items {
+		if l.Kind == "username" || l.Failures != 0 || l.NextAttemptAt != "" {
+			t.Fatalf("counter left after successful login: %+v", l)
+		}
+	}
+
+	// сразу можно снова
+	if res, err := ReserveLoginAttempt(ctx, "ivan", "10.0.0.5"); err != nil || res.Wait > 0 {
+		t.Fatalf("reserve after success: got %+v, %v", res, err)
+	}
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.1.0.0/16", "192.168.1.10", "bogus"})
+	cases := []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"untrusted peer, headers ignored", "203.0.113.5:1234", "1.2.3.4", "5.6.7.8", ""},
+		{"trusted proxy, client from xff", "10.1.2.3:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"spoofed left part of xff is skipped", "10.1.2.3:1234", "1.1.1.1, 198.51.100.7", "", "198.51.100.7"},
+		{"chain of trusted proxies", "192.168.1.10:80", "198.51.100.7, 10.1.9.9", "", "198.51.100.7"},
+		{"x-real-ip from trusted proxy", "10.1.2.3:1234", "", "198.51.100.8", "198.51.100.8"},
+		{"garbage xff", "10.1.2.3:1234", "not-an-ip", "", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := forwardedClientIP(r, trusted); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLoginSuccessKeepsIPBackoff(t *testing.T) {
+	conn := newTestDB(t, loginLimitConfig())
+	ctx := context.Background()
+	const ip = "10.0.0.6"
+
+	for i := 1; i <= 6; i++ {
+		if res, err := ReserveLoginAttempt(ctx, fmt.Sprintf("user%d", i), ip); err != nil || res.Wait > 0 {
+			t.Fatalf("attempt %d: got %+v, %v", i, res, err)
+		}
+	}
+	// пауза после шестой неудачи прошла
+	if _, err := conn.ExecContext(ctx, `UPDATE login_attempts SET next_attempt_at = '' WHERE key = ?`, loginAttemptKey("ip", ip)); err != nil {
+		t.Fatal(err)
+	}
+
+	// успешный вход известной учёткой с того же адреса
+	if res, err := ReserveLoginAttempt(ctx, "ivan", ip); err != nil || res.Wait > 0 {
+		t.Fatalf("reserve for a good login: got %+v, %v", res, err)
+	}
+	if err := ResetLoginFailures(ctx, "ivan", ip); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ReserveLoginAttempt(ctx, "user7", ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Wait <= 0 {
+		t.Fatal("successful login from the ip cleared its backoff")
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Отправка почты через SMTP. Режимы SMTP_TLS:
+// none — без шифрования (локальный relay / тестовый SMTP-sink);
+// starttls — обычное соединение с апгрейдом через STARTTLS (порт 587/25);
+// tls — сразу TLS (SMTPS, порт 465).
+
+func smtpEnabled() bool {
+	c := getConfig()
+	return strings.TrimSpace(c.SMTPHost) != "" && strings.TrimSpace(c.SMTPFrom) != ""
+}
+
+func smtpTLSConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host, InsecureSkipVerify: getConfig().SMTPTLSInsecureSkipVerify}
+}
+
+// sendMail отправляет text/plain письмо (UTF-8) списку адресатов.
+func sendMail(to []string, subject, body string) error {
+	c := getConfig()
+	if !smtpEnabled() {
+		return fmt.Errorf("smtp is not configured")
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	host := strings.TrimSpace(c.SMTPHost)
+	addr := net.JoinHostPort(host, strconv.Itoa(c.SMTPPort))
+	mode := strings.ToLower(strings.TrimSpace(c.SMTPTLS))
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	if mode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, smtpTLSConfig(host))
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if mode == "starttls" {
+		if err := client.StartTLS(smtpTLSConfig(host)); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+
+	if strings.TrimSpace(c.SMTPUsername) != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	from := strings.TrimSpace(c.SMTPFrom)
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp rcpt %s: %w", rcpt, err)
+		}
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	msg, err := buildMailMessage(from, to, subject, body)
+	if err != nil {
+		_ = wc.Close()
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		_ = wc.Close()
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("smtp data close: %w", err)
+	}
+	return client.Quit()
+}
+
+func buildMailMessage(from string, to []string, subject, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	headers := []struct{ k, v string }{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.k, h.v)
+	}
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Метрики Prometheus (/metrics).
+// Endpoint открывается только если задан METRICS_TOKEN (Authorization: Bearer <token>)
+// и/или METRICS_ADDR (отдельный адрес, например 127.0.0.1:9100, — тогда на основном порту /metrics нет).
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onessa_http_requests_total",
+		Help: "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "onessa_http_request_duration_seconds",
+		Help:    "HTTP request latency by chi route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ldapSyncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "onessa_ldap_sync_duration_seconds",
+		Help:    "Duration of LDAP synchronization runs.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	ldapSyncRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onessa_ldap_sync_runs_total",
+		Help: "LDAP synchronization results by directory (users/computers) and result (success/error).",
+	}, []string{"kind", "result"})
+
+	ldapSyncObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "onessa_ldap_sync_objects",
+		Help: "Objects synced and deactivated by the last LDAP synchronization run.",
+	}, []string{"kind", "action"})
+
+	ldapSyncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "onessa_ldap_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last LDAP synchronization that finished without errors.",
+	})
+
+	ldapLoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onessa_ldap_logins_total",
+		Help: "LDAP login attempts by result (success, failure — bad credentials or no access, error — LDAP unavailable).",
+	}, []string{"result"})
+
+	oidcLoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onessa_oidc_logins_total",
+		Help: "OpenID Connect logins by result (success, failure — provider denied or no access, error — provider or token problem).",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		ldapSyncDuration,
+		ldapSyncRuns,
+		ldapSyncObjects,
+		ldapSyncLastSuccess,
+		ldapLoginsTotal,
+		oidcLoginsTotal,
+		inventoryCollector{},
+	)
+}
+
+// metricsMiddleware считает запросы по шаблону маршрута chi ("/api/license/{id}/history"),
+// а не по фактическому пути — иначе число рядов росло бы с каждым id.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if p := rctx.RoutePattern(); p != "" {
+				route = p
+			}
+		}
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(ww.Status())).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// observeLDAPSync — итог синхронизации для метрик; nil — каталог в этом запуске не синхронизировался.
+func observeLDAPSync(duration time.Duration, users, computers *LDAPSyncKindResult) {
+	ldapSyncDuration.Observe(duration.Seconds())
+
+	ok := true
+	for kind, res := range map[string]*LDAPSyncKindResult{"users": users, "computers": computers} {
+		if res == nil {
+			continue
+		}
+		if res.Error != "" {
+			ok = false
+			ldapSyncRuns.WithLabelValues(kind, "error").Inc()
+			continue
+		}
+		ldapSyncRuns.WithLabelValues(kind, "success").Inc()
+		ldapSyncObjects.WithLabelValues(kind, "synced").Set(float64(res.Synced))
+		ldapSyncObjects.WithLabelValues(kind, "deactivated").Set(float64(res.Deactivated))
+	}
+	if ok {
+		ldapSyncLastSuccess.SetToCurrentTime()
+	}
+}
+
+func observeLDAPLogin(role Role, err error) {
+	switch {
+	case err != nil:
+		ldapLoginsTotal.WithLabelValues("error").Inc()
+	case role == RoleNone:
+		ldapLoginsTotal.WithLabelValues("failure").Inc()
+	default:
+		ldapLoginsTotal.WithLabelValues("success").Inc()
+	}
+}
+
+// =============== инвентарь (считается из БД при каждом scrape) ===============
+
+var (
+	licensesDesc = prometheus.NewDesc("onessa_licenses",
+		"Licenses by state (total, assigned, free).", []string{"state"}, nil)
+	activeUsersDesc = prometheus.NewDesc("onessa_active_users",
+		"Active users.", nil, nil)
+	activeComputersDesc = prometheus.NewDesc("onessa_active_computers",
+		"Active computers.", nil, nil)
+)
+
+type inventoryCollector struct{}
+
+func (inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- licensesDesc
+	ch <- activeUsersDesc
+	ch <- activeComputersDesc
+}
+
+func (inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	st, err := inventoryStats(ctx)
+	if err != nil {
+		logging.Warnf("metrics: inventory stats: %v", err)
+		ch <- prometheus.NewInvalidMetric(licensesDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(licensesDesc, prometheus.GaugeValue, float64(st.Licenses), "total")
+	ch <- prometheus.MustNewConstMetric(licensesDesc, prometheus.GaugeValue, float64(st.Assigned), "assigned")
+	ch <- prometheus.MustNewConstMetric(licensesDesc, prometheus.GaugeValue, float64(st.Licenses-st.Assigned), "free")
+	ch <- prometheus.MustNewConstMetric(activeUsersDesc, prometheus.GaugeValue, float64(st.ActiveUsers))
+	ch <- prometheus.MustNewConstMetric(activeComputersDesc, prometheus.GaugeValue, float64(st.ActiveComputers))
+}
+
+type inventory struct {
+	Licenses        int
+	Assigned        int
+	ActiveUsers     int
+	ActiveComputers int
+}
+
+func inventoryStats(ctx context.Context) (inventory, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return inventory{}, err
+	}
+	var st inventory
+	err = conn.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM licenses),
+			(SELECT COUNT(*) FROM licenses WHERE assigned_user_id IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE active=1),
+			(SELECT COUNT(*) FROM computers WHERE active=1)
+	`).Scan(&st.Licenses, &st.Assigned, &st.ActiveUsers, &st.ActiveComputers)
+	return st, err
+}
+
+// =============== HTTP ===============
+
+// metricsHandler отдаёт /metrics; если задан METRICS_TOKEN — только с Authorization: Bearer <token>.
+func metricsHandler() http.Handler {
+	h := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := getConfig().MetricsToken; token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				httpError(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// metricsOnMainAddr — /metrics на основном порту: только с токеном и без отдельного METRICS_ADDR.
+func metricsOnMainAddr(cfg Config) bool {
+	return strings.TrimSpace(cfg.MetricsAddr) == "" && cfg.MetricsToken != ""
+}
+
+// NewMetricsHandler — обработчик для отдельного адреса METRICS_ADDR (nil, если он не задан).
+func NewMetricsHandler(cfg Config) http.Handler {
+	if strings.TrimSpace(cfg.MetricsAddr) == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	return mux
+}
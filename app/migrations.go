@@ -0,0 +1,554 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Версионированные миграции схемы.
+// Каждая миграция применяется один раз, в своей транзакции, и записывается в schema_migrations.
+// Номера только растут; уже выпущенные миграции не редактируются — изменения схемы добавляются новой миграцией.
+//
+// Миграции 1–9 повторяют схему, которую раньше создавал migrate() через CREATE IF NOT EXISTS,
+// поэтому написаны идемпотентно: на существующей БД без schema_migrations они просто регистрируются.
+//
+// Один список миграций обслуживает SQLite и PostgreSQL: новые миграции пишутся в общем подмножестве DDL,
+// а SQLite-диалект выпущенных (автоинкрементные ключи, INSERT OR IGNORE) переводит execMigration (см. migrationDDL).
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx *dbTx) error
+}
+
+var migrations = []migration{
+	{1, "init", execMigration(
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			identity TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL DEFAULT '',
+			email TEXT NOT NULL DEFAULT '',
+			login TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT 'manual',
+			active INTEGER NOT NULL DEFAULT 1,
+			updated_at TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_users_active ON users(active);`,
+		`CREATE TABLE IF NOT EXISTS computers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			identity TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL DEFAULT '',
+			dns_host_name TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT 'manual',
+			active INTEGER NOT NULL DEFAULT 1,
+			updated_at TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_computers_active ON computers(active);`,
+		`CREATE TABLE IF NOT EXISTS licenses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL UNIQUE,
+			assigned_user_id INTEGER NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			pc TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY(assigned_user_id) REFERENCES users(id) ON DELETE SET NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS meetings_meta (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			exported_at TEXT NOT NULL DEFAULT ''
+		);`,
+		`INSERT OR IGNORE INTO meetings_meta(id, exported_at) VALUES (1, '');`,
+		`CREATE TABLE IF NOT EXISTS meetings (
+			id TEXT PRIMARY KEY,
+			subject TEXT NOT NULL DEFAULT '',
+			start TEXT NOT NULL DEFAULT '',
+			end TEXT NOT NULL DEFAULT '',
+			location TEXT NOT NULL DEFAULT '',
+			is_recurring INTEGER NOT NULL DEFAULT 0,
+			is_canceled INTEGER NOT NULL DEFAULT 0,
+			link TEXT NOT NULL DEFAULT '',
+			participants TEXT NOT NULL DEFAULT ''
+		);`,
+	)},
+	// История лицензий: не ссылаемся на licenses через FK, чтобы история переживала удаление ключа.
+	{2, "license_events", execMigration(
+		`CREATE TABLE IF NOT EXISTS license_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			license_id INTEGER NOT NULL,
+			action TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			old_user_id INTEGER NULL,
+			new_user_id INTEGER NULL,
+			old_pc TEXT NOT NULL DEFAULT '',
+			new_pc TEXT NOT NULL DEFAULT '',
+			old_comment TEXT NOT NULL DEFAULT '',
+			new_comment TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_license_events_license ON license_events(license_id, id);`,
+	)},
+	// Серверные сессии (id = HMAC от токена из cookie).
+	{3, "sessions", execMigration(
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT '',
+			last_seen_at TEXT NOT NULL DEFAULT '',
+			expires_at TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);`,
+	)},
+	// Курсор инкрементальной синхронизации LDAP (по одному на каталог: users / computers).
+	{4, "ldap_sync_state", execMigration(
+		`CREATE TABLE IF NOT EXISTS ldap_sync_state (
+			kind TEXT PRIMARY KEY,
+			server TEXT NOT NULL DEFAULT '',
+			cursor_attr TEXT NOT NULL DEFAULT '',
+			cursor TEXT NOT NULL DEFAULT '',
+			last_full_at TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL DEFAULT ''
+		);`,
+	)},
+	// Журнал запусков синхронизации LDAP (списки identity — JSON-массивы).
+	{5, "sync_runs", execMigration(
+		`CREATE TABLE IF NOT EXISTS sync_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			trigger TEXT NOT NULL DEFAULT '',
+			mode TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT '',
+			forced INTEGER NOT NULL DEFAULT 0,
+			synced INTEGER NOT NULL DEFAULT 0,
+			activated_count INTEGER NOT NULL DEFAULT 0,
+			deactivated_count INTEGER NOT NULL DEFAULT 0,
+			activated TEXT NOT NULL DEFAULT '[]',
+			deactivated TEXT NOT NULL DEFAULT '[]',
+			error TEXT NOT NULL DEFAULT '',
+			started_at TEXT NOT NULL DEFAULT '',
+			finished_at TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_sync_runs_kind ON sync_runs(kind, id);`,
+	)},
+	{6, "license_reclaim_flag", func(ctx context.Context, tx *dbTx) error {
+		return addColumnIfMissing(ctx, tx, "licenses", "reclaim_flagged_at", `ALTER TABLE licenses ADD COLUMN reclaim_flagged_at TEXT NOT NULL DEFAULT ''`)
+	}},
+	// Продукт и срок действия лицензии.
+	{7, "license_product", func(ctx context.Context, tx *dbTx) error {
+		for _, c := range []struct{ column, ddl string }{
+			{"product", `ALTER TABLE licenses ADD COLUMN product TEXT NOT NULL DEFAULT ''`},
+			{"version", `ALTER TABLE licenses ADD COLUMN version TEXT NOT NULL DEFAULT ''`},
+			{"license_type", `ALTER TABLE licenses ADD COLUMN license_type TEXT NOT NULL DEFAULT ''`},
+			{"purchase_date", `ALTER TABLE licenses ADD COLUMN purchase_date TEXT NOT NULL DEFAULT ''`},
+			{"expires_at", `ALTER TABLE licenses ADD COLUMN expires_at TEXT NOT NULL DEFAULT ''`},
+		} {
+			if err := addColumnIfMissing(ctx, tx, "licenses", c.column, c.ddl); err != nil {
+				return err
+			}
+		}
+		return execMigration(`CREATE INDEX IF NOT EXISTS idx_licenses_expires ON licenses(expires_at);`)(ctx, tx)
+	}},
+	// Отправленные напоминания об окончании срока лицензий (по окну и конкретной дате окончания).
+	{8, "license_notifications", execMigration(
+		`CREATE TABLE IF NOT EXISTS license_notifications (
+			license_id INTEGER NOT NULL,
+			expires_at TEXT NOT NULL,
+			window_days INTEGER NOT NULL,
+			sent_at TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (license_id, expires_at, window_days)
+		);`,
+	)},
+	// Привязка лицензий к справочнику ПК; старые записи сопоставляем по computers.name / dns_host_name.
+	{9, "license_computer", func(ctx context.Context, tx *dbTx) error {
+		if err := addColumnIfMissing(ctx, tx, "licenses", "computer_id",
+			`ALTER TABLE licenses ADD COLUMN computer_id INTEGER NULL REFERENCES computers(id) ON DELETE SET NULL`); err != nil {
+			return err
+		}
+		if err := execMigration(`CREATE INDEX IF NOT EXISTS idx_licenses_computer ON licenses(computer_id);`)(ctx, tx); err != nil {
+			return err
+		}
+		_, err := linkLicenseComputers(ctx, tx)
+		return err
+	}},
+	// Полнотекстовый поиск (/api/search): FTS5 с триграммами (подстроки от 3 символов, без учёта регистра),
+	// индексы обновляют триггеры. Только SQLite — в PostgreSQL поиск идёт по LIKE (см. search.go).
+	{10, "search_index", func(ctx context.Context, tx *dbTx) error {
+		if tx.driver == driverPostgres {
+			return nil
+		}
+		return execMigration(
+			`CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(name, email, login, tokenize='trigram');`,
+			`INSERT INTO users_fts(rowid, name, email, login) SELECT id, name, email, login FROM users;`,
+			`CREATE TRIGGER IF NOT EXISTS users_fts_ai AFTER INSERT ON users BEGIN
+				INSERT INTO users_fts(rowid, name, email, login) VALUES (new.id, new.name, new.email, new.login);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS users_fts_au AFTER UPDATE OF name, email, login ON users
+			WHEN old.name IS NOT new.name OR old.email IS NOT new.email OR old.login IS NOT new.login BEGIN
+				DELETE FROM users_fts WHERE rowid = old.id;
+				INSERT INTO users_fts(rowid, name, email, login) VALUES (new.id, new.name, new.email, new.login);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS users_fts_ad AFTER DELETE ON users BEGIN
+				DELETE FROM users_fts WHERE rowid = old.id;
+			END;`,
+
+			`CREATE VIRTUAL TABLE IF NOT EXISTS computers_fts USING fts5(name, dns_host_name, description, tokenize='trigram');`,
+			`INSERT INTO computers_fts(rowid, name, dns_host_name, description) SELECT id, name, dns_host_name, description FROM computers;`,
+			`CREATE TRIGGER IF NOT EXISTS computers_fts_ai AFTER INSERT ON computers BEGIN
+				INSERT INTO computers_fts(rowid, name, dns_host_name, description) VALUES (new.id, new.name, new.dns_host_name, new.description);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS computers_fts_au AFTER UPDATE OF name, dns_host_name, description ON computers
+			WHEN old.name IS NOT new.name OR old.dns_host_name IS NOT new.dns_host_name OR old.description IS NOT new.description BEGIN
+				DELETE FROM computers_fts WHERE rowid = old.id;
+				INSERT INTO computers_fts(rowid, name, dns_host_name, description) VALUES (new.id, new.name, new.dns_host_name, new.description);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS computers_fts_ad AFTER DELETE ON computers BEGIN
+				DELETE FROM computers_fts WHERE rowid = old.id;
+			END;`,
+
+			`CREATE VIRTUAL TABLE IF NOT EXISTS licenses_fts USING fts5(key, comment, pc, tokenize='trigram');`,
+			`INSERT INTO licenses_fts(rowid, key, comment, pc) SELECT id, key, comment, pc FROM licenses;`,
+			`CREATE TRIGGER IF NOT EXISTS licenses_fts_ai AFTER INSERT ON licenses BEGIN
+				INSERT INTO licenses_fts(rowid, key, comment, pc) VALUES (new.id, new.key, new.comment, new.pc);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS licenses_fts_au AFTER UPDATE OF key, comment, pc ON licenses
+			WHEN old.key IS NOT new.key OR old.comment IS NOT new.comment OR old.pc IS NOT new.pc BEGIN
+				DELETE FROM licenses_fts WHERE rowid = old.id;
+				INSERT INTO licenses_fts(rowid, key, comment, pc) VALUES (new.id, new.key, new.comment, new.pc);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS licenses_fts_ad AFTER DELETE ON licenses BEGIN
+				DELETE FROM licenses_fts WHERE rowid = old.id;
+			END;`,
+
+			// у meetings текстовый ключ, а неявный rowid может поменяться при VACUUM — храним id отдельной колонкой
+			`CREATE VIRTUAL TABLE IF NOT EXISTS meetings_fts USING fts5(meeting_id UNINDEXED, subject, location, participants, tokenize='trigram');`,
+			`INSERT INTO meetings_fts(meeting_id, subject, location, participants) SELECT id, subject, location, participants FROM meetings;`,
+			`CREATE TRIGGER IF NOT EXISTS meetings_fts_ai AFTER INSERT ON meetings BEGIN
+				INSERT INTO meetings_fts(meeting_id, subject, location, participants) VALUES (new.id, new.subject, new.location, new.participants);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS meetings_fts_au AFTER UPDATE ON meetings BEGIN
+				DELETE FROM meetings_fts WHERE meeting_id = old.id;
+				INSERT INTO meetings_fts(meeting_id, subject, location, participants) VALUES (new.id, new.subject, new.location, new.participants);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS meetings_fts_ad AFTER DELETE ON meetings BEGIN
+				DELETE FROM meetings_fts WHERE meeting_id = old.id;
+			END;`,
+		)(ctx, tx)
+	}},
+	// Журнал аудита: изменения через /api, вход и выход (before/after — JSON состояния сущности).
+	{11, "audit_log", execMigration(
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL DEFAULT '',
+			entity TEXT NOT NULL DEFAULT '',
+			entity_id TEXT NOT NULL DEFAULT '',
+			before_json TEXT NOT NULL DEFAULT '',
+			after_json TEXT NOT NULL DEFAULT '',
+			method TEXT NOT NULL DEFAULT '',
+			path TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL DEFAULT 0,
+			request_id TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity, entity_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);`,
+	)},
+	{12, "api_tokens", execMigration(
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL DEFAULT '',
+			expires_at TEXT NOT NULL DEFAULT '',
+			last_used_at TEXT NOT NULL DEFAULT '',
+			revoked_at TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_name ON api_tokens(name);`,
+	)},
+	{13, "login_attempts", execMigration(
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			key TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TEXT NOT NULL DEFAULT '',
+			next_attempt_at TEXT NOT NULL DEFAULT '',
+			locked_until TEXT NOT NULL DEFAULT ''
+		);`,
+	)},
+	// Заблокированная синхронизация LDAP хранится в sync_runs (переживает рестарт, видна всем репликам):
+	// для подтверждения нужны размер каталога и лимит на момент блокировки.
+	{14, "sync_runs_blocked", func(ctx context.Context, tx *dbTx) error {
+		for _, c := range []struct{ column, ddl string }{
+			{"active_before", `ALTER TABLE sync_runs ADD COLUMN active_before INTEGER NOT NULL DEFAULT 0`},
+			{"deactivate_limit", `ALTER TABLE sync_runs ADD COLUMN deactivate_limit TEXT NOT NULL DEFAULT ''`},
+		} {
+			if err := addColumnIfMissing(ctx, tx, "sync_runs", c.column, c.ddl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	// Напоминания учитываются по каналу (digest — сводка администраторам, holder — письмо держателю):
+	// неудачное письмо держателю не должно помечать лицензию как отправленную в сводке и наоборот.
+	// Прежние отметки переносятся в оба канала — всё, что было помечено, считалось разосланным.
+	{15, "license_notifications_channel", execMigration(
+		`CREATE TABLE license_notifications_new (
+			license_id INTEGER NOT NULL,
+			expires_at TEXT NOT NULL,
+			window_days INTEGER NOT NULL,
+			channel TEXT NOT NULL,
+			sent_at TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (license_id, expires_at, window_days, channel)
+		);`,
+		`INSERT INTO license_notifications_new(license_id, expires_at, window_days, channel, sent_at)
+			SELECT license_id, expires_at, window_days, 'digest', sent_at FROM license_notifications;`,
+		`INSERT INTO license_notifications_new(license_id, expires_at, window_days, channel, sent_at)
+			SELECT license_id, expires_at, window_days, 'holder', sent_at FROM license_notifications;`,
+		`DROP TABLE license_notifications;`,
+		`ALTER TABLE license_notifications_new RENAME TO license_notifications;`,
+	)},
+}
+
+// execMigration — миграция из набора SQL-операторов.
+func execMigration(stmts ...string) func(ctx context.Context, tx *dbTx) error {
+	return func(ctx context.Context, tx *dbTx) error {
+		for _, s := range stmts {
+			s = migrationDDL(tx.driver, s)
+			if _, err := tx.ExecContext(ctx, s); err != nil {
+				return fmt.Errorf("%w (sql=%s)", err, s)
+			}
+		}
+		return nil
+	}
+}
+
+// migrationDDL переводит DDL выпущенных миграций (написаны для SQLite и не меняются) на PostgreSQL:
+// автоинкрементный первичный ключ, INSERT OR IGNORE и колонка с зарезервированным именем end.
+func migrationDDL(driver, stmt string) string {
+	if driver != driverPostgres {
+		return stmt
+	}
+	stmt = strings.ReplaceAll(stmt, "INTEGER PRIMARY KEY AUTOINCREMENT", "INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY")
+	if rest, ok := strings.CutPrefix(stmt, "INSERT OR IGNORE INTO "); ok {
+		stmt = "INSERT INTO " + strings.TrimSuffix(strings.TrimSpace(rest), ";") + " ON CONFLICT DO NOTHING;"
+	}
+	return reservedColumnDDL.ReplaceAllString(stmt, `$1"end"$2`)
+}
+
+// reservedColumnDDL — определение колонки end в CREATE TABLE (в PostgreSQL end — ключевое слово).
+var reservedColumnDDL = regexp.MustCompile(`(?m)^(\s+)end(\s+TEXT\b)`)
+
+// addColumnIfMissing — ALTER TABLE ADD COLUMN только если колонки ещё нет
+// (в SQLite нет ADD COLUMN IF NOT EXISTS).
+func addColumnIfMissing(ctx context.Context, tx *dbTx, table, column, ddl string) error {
+	q := `SELECT name FROM pragma_table_info(?)`
+	if tx.driver == driverPostgres {
+		q = `SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	rows, err := tx.QueryContext(ctx, q, table)
+	if err != nil {
+		return fmt.Errorf("%w (table_info %s)", err, table)
+	}
+	names, err := scanStrings(rows)
+	if err != nil {
+		return fmt.Errorf("%w (table_info %s)", err, table)
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, column) {
+			return nil
+		}
+	}
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("%w (sql=%s)", err, ddl)
+	}
+	return nil
+}
+
+// MigrationStatus — состояние одной миграции.
+type MigrationStatus struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt string `json:"applied_at,omitempty"`
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *dbConn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			applied_at TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	return err
+}
+
+// migrationStatus сверяет список миграций с schema_migrations.
+// Ошибка — в БД есть версии, неизвестные этой сборке (БД обновлена более новой версией приложения).
+func migrationStatus(ctx context.Context, conn *dbConn) ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var v int
+		var at string
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		out = append(out, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+		delete(applied, m.Version)
+	}
+	for v := range applied {
+		return out, fmt.Errorf("database schema version %d is unknown to this build (latest %d): upgrade the application", v, migrations[len(migrations)-1].Version)
+	}
+	return out, nil
+}
+
+// applyMigrations применяет все неприменённые миграции по порядку, каждую в своей транзакции.
+func applyMigrations(ctx context.Context, conn *dbConn) ([]MigrationStatus, error) {
+	status, err := migrationStatus(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSQLFunctions(ctx, conn); err != nil {
+		return nil, fmt.Errorf("%s migrate error: sql functions: %w", conn.driver, err)
+	}
+
+	var done []MigrationStatus
+	for i, st := range status {
+		if st.Applied {
+			continue
+		}
+		if err := applyMigration(ctx, conn, migrations[i]); err != nil {
+			return done, fmt.Errorf("%s migrate error: migration %d (%s): %w", conn.driver, st.Version, st.Name, err)
+		}
+		st.Applied = true
+		st.AppliedAt = time.Now().UTC().Format(time.RFC3339)
+		done = append(done, st)
+		logging.Infof("schema migration applied: %d %s", st.Version, st.Name)
+	}
+	return done, nil
+}
+
+// ensureSQLFunctions создаёт функции, которые вызывают запросы приложения (и миграции, использующие его код).
+// unicode_lower — lower() с учётом Unicode: в SQLite регистрируется из Go (см. init в db.go),
+// в PostgreSQL — обёртка над встроенным lower(); создаётся до миграций и на каждом старте.
+func ensureSQLFunctions(ctx context.Context, conn *dbConn) (err error) {
+	if conn.driver != driverPostgres {
+		return nil
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// CREATE OR REPLACE из нескольких реплик одновременно конфликтует — под тем же lock, что и миграции
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(CAST(? AS BIGINT))`, int64(migrationsLockID)); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		CREATE OR REPLACE FUNCTION unicode_lower(s text) RETURNS text
+		LANGUAGE sql IMMUTABLE STRICT AS $$ SELECT lower(s) $$
+	`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrationsLockID — ключ advisory lock PostgreSQL: реплики, стартующие одновременно,
+// применяют миграции по очереди.
+const migrationsLockID = 0x6f6e65737361 // "onessa"
+
+func applyMigration(ctx context.Context, conn *dbConn, m migration) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if conn.driver == driverPostgres {
+		if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(CAST(? AS BIGINT))`, int64(migrationsLockID)); err != nil {
+			return err
+		}
+		// пока ждали блокировку, миграцию могла применить другая реплика
+		n := 0
+		if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version=?`, m.Version).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return tx.Commit()
+		}
+	}
+
+	if err = m.Up(ctx, tx); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)`,
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// =============== CLI: onessa migrate status|up ===============
+
+// MigrateStatus открывает БД без запуска приложения и отдаёт состояние миграций.
+func MigrateStatus(ctx context.Context, cfg Config) ([]MigrationStatus, error) {
+	SetConfig(cfg)
+	conn, _, err := openDB(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return migrationStatus(ctx, conn)
+}
+
+// MigrateUp применяет неприменённые миграции и возвращает список применённых.
+func MigrateUp(ctx context.Context, cfg Config) ([]MigrationStatus, error) {
+	SetConfig(cfg)
+	conn, _, err := openDB(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return applyMigrations(ctx, conn)
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Спецификация OpenAPI 3 для /api ведётся вручную в openapi.json и отдаётся как есть.
+// При добавлении или изменении маршрута в NewHTTPHandler обновите openapi.json (и pkg/client):
+// TestOpenAPIMatchesRoutes (checkOpenAPIRoutes) сверяет маршруты chi со спецификацией и падает на расхождениях.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(openAPISpec)
+}
+
+// openAPIOperations — "METHOD /path" → operationId для всех операций спецификации.
+func openAPIOperations(spec []byte) (map[string]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi.json: %w", err)
+	}
+	ops := map[string]string{}
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			switch method {
+			case "get", "put", "post", "delete", "patch", "head", "options":
+				var op struct {
+					OperationID string `json:"operationId"`
+				}
+				_ = json.Unmarshal(raw, &op)
+				ops[strings.ToUpper(method)+" "+path] = op.OperationID
+			}
+		}
+	}
+	return ops, nil
+}
+
+// checkOpenAPIRoutes сверяет маршруты /api, зарегистрированные в chi, с openapi.json.
+// Возвращает расхождения в обе стороны (маршрут без описания и описание без маршрута).
+func checkOpenAPIRoutes(routes chi.Routes) ([]string, error) {
+	spec, err := openAPIOperations(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := map[string]bool{}
+	err = chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/api/") {
+			registered[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for op := range registered {
+		if _, ok := spec[op]; !ok {
+			problems = append(problems, "route is not documented in openapi.json: "+op)
+		}
+	}
+	for op := range spec {
+		if !registered[op] {
+			problems = append(problems, "openapi.json describes unregistered route: "+op)
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	r, ok := NewHTTPHandler(Config{StaticDir: t.TempDir()}).(chi.Routes)
+	if !ok {
+		t.Fatal("NewHTTPHandler does not return a chi router")
+	}
+
+	problems, err := checkOpenAPIRoutes(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
+
+func TestOpenAPIOperationIDs(t *testing.T) {
+	ops, err := openAPIOperations(openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]string{}
+	for op, id := range ops {
+		if id == "" {
+			t.Errorf("%s: operationId is empty", op)
+			continue
+		}
+		if prev, dup := seen[id]; dup {
+			t.Errorf("operationId %q is used by both %s and %s", id, prev, op)
+		}
+		seen[id] = op
+	}
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Возврат (reclaim) лицензий, закреплённых за пользователями, которые стали active=0 после синхронизации.
+// ListUsers таких пользователей не отдаёт, и на фронте ключ выглядит «закреплённым ни за кем».
+// Время деактивации берём из users.updated_at: после инкрементальной синхронизации оно меняется
+// только при реальных изменениях записи.
+
+const reclaimActor = "system:reclaim"
+
+// ReclaimCandidate — лицензия, держатель которой деактивирован.
+type ReclaimCandidate struct {
+	LicenseID     int    `json:"license_id"`
+	Key           string `json:"key"`
+	PC            string `json:"pc"`
+	Comment       string `json:"comment"`
+	UserID        int    `json:"user_id"`
+	UserName      string `json:"user_name"`
+	UserEmail     string `json:"user_email"`
+	UserLogin     string `json:"user_login"`
+	InactiveSince string `json:"inactive_since"`
+	InactiveDays  int    `json:"inactive_days"`
+	FlaggedAt     string `json:"flagged_at,omitempty"`
+}
+
+// ReclaimResult — итог применения политики.
+type ReclaimResult struct {
+	Policy     string `json:"policy"`
+	AfterDays  int    `json:"after_days"`
+	Candidates int    `json:"candidates"`
+	Unassigned int    `json:"unassigned"`
+	Flagged    int    `json:"flagged"`
+}
+
+func reclaimPolicy() string {
+	switch p := strings.ToLower(strings.TrimSpace(getConfig().ReclaimPolicy)); p {
+	case "unassign", "flag":
+		return p
+	default:
+		return "report"
+	}
+}
+
+// ListReclaimCandidates — лицензии, закреплённые за неактивными пользователями (самые «старые» сверху).
+func ListReclaimCandidates(ctx context.Context) ([]ReclaimCandidate, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+	return listReclaimCandidates(ctx, conn)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func listReclaimCandidates(ctx context.Context, q queryer) ([]ReclaimCandidate, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.id, l.key, l.pc, l.comment, l.reclaim_flagged_at,
+			u.id, u.name, u.email, u.login, u.updated_at
+		FROM licenses l
+		JOIN users u ON u.id = l.assigned_user_id
+		WHERE u.active = 0
+		ORDER BY u.updated_at, l.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	var out []ReclaimCandidate
+	for rows.Next() {
+		var c ReclaimCandidate
+		if err := rows.Scan(&c.LicenseID, &c.Key, &c.PC, &c.Comment, &c.FlaggedAt,
+			&c.UserID, &c.UserName, &c.UserEmail, &c.UserLogin, &c.InactiveSince); err != nil {
+			return nil, err
+		}
+		if since, err := time.Parse(time.RFC3339, c.InactiveSince); err == nil {
+			c.InactiveDays = int(now.Sub(since).Hours() / 24)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// RunReclaimPolicy применяет RECLAIM_POLICY к лицензиям неактивных пользователей.
+// Каждое автоматическое действие пишется в license_events (actor=system:reclaim).
+func RunReclaimPolicy(ctx context.Context) (res ReclaimResult, err error) {
+	cfg := getConfig()
+	res.Policy = reclaimPolicy()
+	res.AfterDays = cfg.ReclaimAfterDays
+
+	if !beginJob("reclaim") {
+		return res, errRestoreInProgress
+	}
+	defer endJob("reclaim")
+
+	conn, err := requireDB()
+	if err != nil {
+		return res, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	candidates, err := listReclaimCandidates(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+	res.Candidates = len(candidates)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, c := range candidates {
+		if res.Policy == "report" || c.InactiveDays < cfg.ReclaimAfterDays {
+			continue
+		}
+
+		old, e := loadLicenseState(ctx, tx, c.LicenseID)
+		if e != nil {
+			err = e
+			return res, err
+		}
+
+		switch res.Policy {
+		case "unassign":
+			if _, err = tx.ExecContext(ctx, `UPDATE licenses SET assigned_user_id=NULL, reclaim_flagged_at='' WHERE id=?`, c.LicenseID); err != nil {
+				return res, err
+			}
+			ev := old.event("reclaim_unassign", reclaimActor)
+			ev.NewUserID = 0
+			if err = insertLicenseEvent(ctx, tx, ev); err != nil {
+				return res, err
+			}
+			res.Unassigned++
+		case "flag":
+			if c.FlaggedAt != "" {
+				continue
+			}
+			if _, err = tx.ExecContext(ctx, `UPDATE licenses SET reclaim_flagged_at=? WHERE id=?`, now, c.LicenseID); err != nil {
+				return res, err
+			}
+			if err = insertLicenseEvent(ctx, tx, old.event("reclaim_flag", reclaimActor)); err != nil {
+				return res, err
+			}
+			res.Flagged++
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return res, err
+	}
+
+	if res.Unassigned > 0 || res.Flagged > 0 {
+		logging.Infof("reclaim: policy=%s candidates=%d unassigned=%d flagged=%d", res.Policy, res.Candidates, res.Unassigned, res.Flagged)
+	}
+	return res, nil
+}
+
+// =============== API ===============
+
+// отчёт: лицензии, закреплённые за деактивированными пользователями
+func handleReclaimReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	items, err := ListReclaimCandidates(r.Context())
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Policy    string             `json:"policy"`
+		AfterDays int                `json:"after_days"`
+		Items     []ReclaimCandidate `json:"items"`
+	}{
+		Policy:    reclaimPolicy(),
+		AfterDays: getConfig().ReclaimAfterDays,
+		Items:     items,
+	})
+}
+
+// ручной запуск политики (обычно она применяется после каждой синхронизации LDAP)
+func handleReclaimRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := RunReclaimPolicy(r.Context())
+	if errors.Is(err, errRestoreInProgress) {
+		httpError(w, "идёт восстановление БД из бэкапа, повторите позже", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Infof("reclaim run requested by %q", requestActor(r))
+	auditTarget(r, "license", nil, nil, res)
+	writeJSON(w, res)
+}
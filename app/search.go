@@ -0,0 +1,257 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Глобальный поиск (/api/search): пользователи, ПК, лицензии и встречи по одной строке запроса.
+// SQLite — FTS5-индексы с триграммами (миграция 10), результаты по релевантности (bm25);
+// PostgreSQL — unicode_lower(...) LIKE по тем же полям (searchCond), порядок по имени.
+// Каждое слово запроса (от 3 символов) должно найтись в записи; к лицензиям добавляются связанные —
+// назначенные найденным пользователям и привязанные к найденным ПК.
+
+const (
+	searchMinTermLen   = 3 // триграммный индекс не ищет подстроки короче 3 символов
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// SearchResults — результаты по группам; внутри группы — сначала более релевантные.
+type SearchResults struct {
+	Query     string         `json:"query"`
+	Users     []UserFull     `json:"users"`
+	Computers []ComputerFull `json:"computers"`
+	Licenses  []License      `json:"licenses"`
+	Meetings  []Meeting      `json:"meetings"`
+}
+
+// searchSource — индексируемая таблица: FTS5-таблица (SQLite) и колонки для LIKE (PostgreSQL).
+type searchSource struct {
+	table   string
+	fts     string
+	ftsID   string // колонка FTS-таблицы с id записи
+	columns []string
+}
+
+var (
+	searchUsers     = searchSource{"users", "users_fts", "rowid", []string{"name", "email", "login"}}
+	searchComputers = searchSource{"computers", "computers_fts", "rowid", []string{"name", "dns_host_name", "description"}}
+	searchLicenses  = searchSource{"licenses", "licenses_fts", "rowid", []string{"key", "comment", "pc"}}
+	searchMeetings  = searchSource{"meetings", "meetings_fts", "meeting_id", []string{"subject", "location", "participants"}}
+)
+
+// hits — подзапрос (hit_id, hit_rank) найденных записей: не больше limit, меньший hit_rank — выше.
+func (src searchSource) hits(driver string, terms []string, limit int) (string, []any) {
+	if driver != driverPostgres {
+		return `SELECT ` + src.ftsID + ` AS hit_id, rank AS hit_rank FROM ` + src.fts +
+			` WHERE ` + src.fts + ` MATCH ? ORDER BY rank LIMIT ?`, []any{ftsMatch(terms), limit}
+	}
+
+	var where []string
+	var args []any
+	for _, t := range terms {
+		cond, condArgs := searchCond(t, src.columns...)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	return `SELECT id AS hit_id, 0 AS hit_rank FROM ` + src.table +
+		` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id LIMIT ?`, append(args, limit)
+}
+
+// searchTerms — слова запроса от searchMinTermLen символов; "search_query_too_short", если таких нет.
+func searchTerms(q string) ([]string, error) {
+	var terms []string
+	for _, t := range strings.Fields(q) {
+		if utf8.RuneCountInString(t) >= searchMinTermLen {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search_query_too_short")
+	}
+	return terms, nil
+}
+
+// ftsMatch — выражение MATCH: каждое слово — фраза в кавычках (спецсимволы FTS5 не действуют), между словами AND.
+func ftsMatch(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, " AND ")
+}
+
+func (s *sqlStore) Search(ctx context.Context, q string, limit int) (SearchResults, error) {
+	conn, err := s.requireConn()
+	if err != nil {
+		return SearchResults{}, err
+	}
+	terms, err := searchTerms(q)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	res := SearchResults{
+		Query:     strings.TrimSpace(q),
+		Users:     []UserFull{},
+		Computers: []ComputerFull{},
+		Licenses:  []License{},
+		Meetings:  []Meeting{},
+	}
+
+	// пользователи
+	hits, args := searchUsers.hits(conn.driver, terms, limit)
+	err = scanRows(ctx, conn, `
+		SELECT u.id, u.name, u.email, u.login, u.source, u.active
+		FROM users u JOIN (`+hits+`) f ON f.hit_id = u.id
+		ORDER BY f.hit_rank, u.active DESC, u.name, u.id
+	`, args, func(rows *sql.Rows) error {
+		var u UserFull
+		var activeInt int
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Login, &u.Source, &activeInt); err != nil {
+			return err
+		}
+		u.Active = activeInt != 0
+		res.Users = append(res.Users, u)
+		return nil
+	})
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search users: %w", err)
+	}
+
+	// ПК
+	hits, args = searchComputers.hits(conn.driver, terms, limit)
+	err = scanRows(ctx, conn, `
+		SELECT c.id, c.name, c.dns_host_name, c.description, c.source, c.active
+		FROM computers c JOIN (`+hits+`) f ON f.hit_id = c.id
+		ORDER BY f.hit_rank, c.active DESC, c.name, c.id
+	`, args, func(rows *sql.Rows) error {
+		var c ComputerFull
+		var activeInt int
+		if err := rows.Scan(&c.ID, &c.Name, &c.DNSHostName, &c.Description, &c.Source, &activeInt); err != nil {
+			return err
+		}
+		c.Active = activeInt != 0
+		res.Computers = append(res.Computers, c)
+		return nil
+	})
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search computers: %w", err)
+	}
+
+	// лицензии: сначала найденные по ключу/комментарию/ПК, затем связанные с найденными пользователями и ПК
+	seen := map[int]bool{}
+	addLicense := func(rows *sql.Rows) error {
+		l, err := scanLicense(rows)
+		if err != nil {
+			return err
+		}
+		if !seen[l.ID] && len(res.Licenses) < limit {
+			seen[l.ID] = true
+			res.Licenses = append(res.Licenses, l)
+		}
+		return nil
+	}
+
+	hits, args = searchLicenses.hits(conn.driver, terms, limit)
+	err = scanRows(ctx, conn, `
+		SELECT `+licenseColumns+`
+		FROM `+licensesWithUser+` JOIN (`+hits+`) f ON f.hit_id = l.id
+		ORDER BY f.hit_rank, l.id
+	`, args, addLicense)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search licenses: %w", err)
+	}
+
+	// связанные лицензии — по тем же подзапросам поиска, что и найденные пользователи и ПК
+	if len(res.Users)+len(res.Computers) > 0 && len(res.Licenses) < limit {
+		userHits, userArgs := searchUsers.hits(conn.driver, terms, limit)
+		pcHits, pcArgs := searchComputers.hits(conn.driver, terms, limit)
+		err = scanRows(ctx, conn, `
+			SELECT `+licenseColumns+`
+			FROM `+licensesWithUser+`
+			WHERE assigned_user_id IN (SELECT hit_id FROM (`+userHits+`) uh)
+				OR computer_id IN (SELECT hit_id FROM (`+pcHits+`) ch)
+			ORDER BY id
+			LIMIT ?
+		`, append(append(userArgs, pcArgs...), limit), addLicense)
+		if err != nil {
+			return SearchResults{}, fmt.Errorf("search related licenses: %w", err)
+		}
+	}
+
+	// встречи
+	hits, args = searchMeetings.hits(conn.driver, terms, limit)
+	err = scanRows(ctx, conn, `
+		SELECT m.id, m.subject, m.start, m."end", m.location, m.is_recurring, m.is_canceled, m.link, m.participants
+		FROM meetings m JOIN (`+hits+`) f ON f.hit_id = m.id
+		ORDER BY f.hit_rank, m.start DESC, m.id
+	`, args, func(rows *sql.Rows) error {
+		var m Meeting
+		var rec, canc int
+		if err := rows.Scan(&m.ID, &m.Subject, &m.Start, &m.End, &m.Location, &rec, &canc, &m.Link, &m.Participants); err != nil {
+			return err
+		}
+		m.IsRecurring = rec != 0
+		m.IsCanceled = canc != 0
+		res.Meetings = append(res.Meetings, m)
+		return nil
+	})
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("search meetings: %w", err)
+	}
+	return res, nil
+}
+
+// scanRows выполняет запрос и передаёт каждую строку в scan.
+func scanRows(ctx context.Context, conn *dbConn, query string, args []any, scan func(rows *sql.Rows) error) error {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// =============== API ===============
+
+// поиск по всему: ?q=<фамилия, имя ПК, часть ключа, тема встречи>&limit=20 (на каждую группу)
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := searchDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > searchMaxLimit {
+			httpError(w, fmt.Sprintf("limit должен быть от 1 до %d", searchMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	res, err := getStore().Search(r.Context(), q.Get("q"), limit)
+	if err != nil {
+		if strings.Contains(err.Error(), "search_query_too_short") {
+			httpError(w, fmt.Sprintf("q: нужно хотя бы одно слово от %d символов", searchMinTermLen), http.StatusBadRequest)
+			return
+		}
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, res)
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ryantrue/onessa/internal/logging"
+)
+
+// Серверные сессии: в cookie лежит случайный токен, в БД — только HMAC от него (id сессии).
+// Так список сессий можно показывать админам, не раскрывая сами токены,
+// а смена SESSION_SECRET по-прежнему инвалидирует все сессии разом.
+
+// Session — активная сессия пользователя.
+type Session struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// last_seen_at обновляем не чаще раза в минуту, чтобы не писать в БД на каждый запрос.
+const sessionTouchEvery = time.Minute
+
+func sessionIDFromToken(token string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	_, _ = mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSession сохраняет новую сессию и возвращает токен для cookie.
+func CreateSession(ctx context.Context, username string, role Role, ip, userAgent string) (string, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("session token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	nowStr := now.Format(time.RFC3339)
+
+	// заодно подчищаем протухшие
+	if _, err := conn.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < ?`, nowStr); err != nil {
+		logging.Warnf("sessions cleanup error: %v", err)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO sessions(id, username, role, created_at, last_seen_at, expires_at, ip, user_agent)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionIDFromToken(token), username, role.String(), nowStr, nowStr,
+		now.Add(sessionTTL).Format(time.RFC3339), strings.TrimSpace(ip), strings.TrimSpace(userAgent))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// TouchSession находит действующую сессию и при необходимости обновляет last_seen_at.
+func TouchSession(ctx context.Context, id string) (Session, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return Session{}, err
+	}
+
+	var s Session
+	err = conn.QueryRowContext(ctx, `
+		SELECT id, username, role, created_at, last_seen_at, expires_at, ip, user_agent
+		FROM sessions WHERE id=?
+	`, id).Scan(&s.ID, &s.Username, &s.Role, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.IP, &s.UserAgent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, fmt.Errorf("session_not_found")
+		}
+		return Session{}, err
+	}
+
+	now := time.Now().UTC()
+	if exp, err := time.Parse(time.RFC3339, s.ExpiresAt); err != nil || now.After(exp) {
+		_, _ = conn.ExecContext(ctx, `DELETE FROM sessions WHERE id=?`, id)
+		return Session{}, fmt.Errorf("session_not_found")
+	}
+
+	if seen, err := time.Parse(time.RFC3339, s.LastSeenAt); err != nil || now.Sub(seen) > sessionTouchEvery {
+		s.LastSeenAt = now.Format(time.RFC3339)
+		if _, err := conn.ExecContext(ctx, `UPDATE sessions SET last_seen_at=? WHERE id=?`, s.LastSeenAt, id); err != nil {
+			logging.Warnf("session touch error: %v", err)
+		}
+	}
+	return s, nil
+}
+
+func DeleteSession(ctx context.Context, id string) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+	res, err := conn.ExecContext(ctx, `DELETE FROM sessions WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	a, _ := res.RowsAffected()
+	if a == 0 {
+		return fmt.Errorf("session_not_found")
+	}
+	return nil
+}
+
+// DeleteUserSessions завершает все сессии пользователя.
+func DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return 0, err
+	}
+	res, err := conn.ExecContext(ctx, `DELETE FROM sessions WHERE username=?`, normalizeLogin(username))
+	if err != nil {
+		return 0, err
+	}
+	a, _ := res.RowsAffected()
+	return int(a), nil
+}
+
+// ListSessions отдаёт действующие сессии (опционально — одного пользователя).
+func ListSessions(ctx context.Context, username string) ([]Session, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+		SELECT id, username, role, created_at, last_seen_at, expires_at, ip, user_agent
+		FROM sessions WHERE expires_at >= ?`
+	args := []any{time.Now().UTC().Format(time.RFC3339)}
+	if u := normalizeLogin(username); u != "" {
+		q += ` AND username=?`
+		args = append(args, u)
+	}
+	q += ` ORDER BY last_seen_at DESC, id`
+
+	rows, err := conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Username, &s.Role, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.IP, &s.UserAgent); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// =============== API (admin) ===============
+
+type RevokeSessionsRequest struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+func handleSessionsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions, err := ListSessions(r.Context(), r.URL.Query().Get("username"))
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Sessions []Session `json:"sessions"`
+	}{Sessions: sessions})
+}
+
+// отзыв сессий: одной (по id) или всех сессий пользователя (по username)
+func handleSessionsRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RevokeSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "не удалось прочитать JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := strings.TrimSpace(req.ID)
+	username := strings.TrimSpace(req.Username)
+
+	switch {
+	case id != "":
+		if err := DeleteSession(r.Context(), id); err != nil {
+			if strings.Contains(err.Error(), "session_not_found") {
+				httpError(w, "сессия не найдена", http.StatusNotFound)
+				return
+			}
+			httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.Infof("session revoked by %q: id=%s", requestActor(r), id)
+		auditTarget(r, "session", id, nil, nil)
+		writeJSON(w, map[string]any{"status": "ok", "revoked": 1})
+	case username != "":
+		n, err := DeleteUserSessions(r.Context(), username)
+		if err != nil {
+			httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.Infof("sessions revoked by %q: username=%q count=%d", requestActor(r), username, n)
+		auditTarget(r, "user", normalizeLogin(username), nil, map[string]any{"sessions_revoked": n})
+		writeJSON(w, map[string]any{"status": "ok", "revoked": n})
+	default:
+		httpError(w, "передайте id или username", http.StatusBadRequest)
+	}
+}
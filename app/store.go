@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"errors"
+)
+
+// Store — доступ к пользователям, ПК, лицензиям и встречам.
+// Реализация — sqlStore поверх SQLite или PostgreSQL (DB_DRIVER); обработчики HTTP работают только через Store.
+type Store interface {
+	// Пользователи и ПК
+	ListUsers(ctx context.Context) ([]User, error)
+	ListUsersAll(ctx context.Context, f UserFilter, p ListParams) ([]UserFull, Page, error)
+	GetUser(ctx context.Context, id int) (UserFull, error)
+	ImportManualUsers(ctx context.Context, in []ManualUserImport) (imported int, warnings []string, err error)
+	ListComputers(ctx context.Context, f ComputerFilter, p ListParams) ([]Computer, Page, error)
+	GetComputer(ctx context.Context, id int) (ComputerFull, error)
+
+	// Лицензии
+	ListLicenses(ctx context.Context, f LicenseFilter, p ListParams) ([]License, Page, error)
+	GetLicense(ctx context.Context, id int) (License, error)
+	ImportLicenses(ctx context.Context, actor string, in []LicenseImport, dryRun bool) (LicenseImportResult, error)
+	AssignLicense(ctx context.Context, actor string, userID, licenseID int) error
+	UpdateLicense(ctx context.Context, actor string, licenseID int, comment, pc string, computerID int) error
+	UnassignLicense(ctx context.Context, actor string, licenseID int) error
+	DeleteLicense(ctx context.Context, actor string, licenseID int) error
+	ListLicenseEvents(ctx context.Context, licenseID int) ([]LicenseEvent, error)
+
+	// Поиск по пользователям, ПК, лицензиям и встречам (limit — на каждую группу)
+	Search(ctx context.Context, q string, limit int) (SearchResults, error)
+
+	// Встречи
+	ReplaceMeetingsSnapshot(ctx context.Context, exportedAt string, items []Meeting) (int, error)
+	GetMeetingsState(ctx context.Context) (MeetingsState, error)
+}
+
+// sqlStore — Store поверх database/sql; диалект (SQLite / PostgreSQL) учитывает dbConn.
+type sqlStore struct {
+	conn *dbConn
+}
+
+var _ Store = (*sqlStore)(nil)
+
+// до InitDB (или если БД не открылась) методы возвращают "db is not initialized"
+var store Store = &sqlStore{}
+
+func getStore() Store {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return store
+}
+
+func (s *sqlStore) requireConn() (*dbConn, error) {
+	if s.conn == nil {
+		return nil, errors.New("db is not initialized")
+	}
+	return s.conn, nil
+}
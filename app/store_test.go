@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Общий для SQLite и PostgreSQL SQL: каждый тест идёт на обоих драйверах (PostgreSQL — при ONESSA_TEST_PG_DSN).
+
+func TestRebind(t *testing.T) {
+	cases := []struct {
+		driver, in, want string
+	}{
+		{driverSQLite, `SELECT * FROM t WHERE a=? AND b=?`, `SELECT * FROM t WHERE a=? AND b=?`},
+		{driverPostgres, `SELECT * FROM t WHERE a=? AND b=?`, `SELECT * FROM t WHERE a=$1 AND b=$2`},
+		{driverPostgres, `SELECT '?' FROM t WHERE a=?`, `SELECT '?' FROM t WHERE a=$1`},
+		{driverPostgres, `SELECT 'it''s ?' FROM t WHERE a=? AND b='?'`, `SELECT 'it''s ?' FROM t WHERE a=$1 AND b='?'`},
+		{driverPostgres, `SELECT "col?" FROM t WHERE a=?`, `SELECT "col?" FROM t WHERE a=$1`},
+		{driverPostgres, `SELECT 1`, `SELECT 1`},
+	}
+	for _, c := range cases {
+		if got := rebind(c.driver, c.in); got != c.want {
+			t.Errorf("rebind(%s, %q) = %q, want %q", c.driver, c.in, got, c.want)
+		}
+	}
+}
+
+func TestRebindQuotedPlaceholder(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		if _, err := conn.ExecContext(ctx, `INSERT INTO users(identity, name, email) VALUES('manual:q', '?', ?)`, "q@example.test"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		var name, email string
+		err := conn.QueryRowContext(ctx, `SELECT name, email FROM users WHERE name = '?' AND email = ?`, "q@example.test").Scan(&name, &email)
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if name != "?" || email != "q@example.test" {
+			t.Fatalf("got name=%q email=%q", name, email)
+		}
+	})
+}
+
+func TestMigrationDDL(t *testing.T) {
+	stmt := `CREATE TABLE x (id INTEGER PRIMARY KEY AUTOINCREMENT, v TEXT)`
+	if got := migrationDDL(driverSQLite, stmt); got != stmt {
+		t.Errorf("sqlite: DDL rewritten: %q", got)
+	}
+	cases := []struct{ in, want string }{
+		{stmt, `CREATE TABLE x (id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, v TEXT)`},
+		{`INSERT OR IGNORE INTO meta(id) VALUES (1);`, `INSERT INTO meta(id) VALUES (1) ON CONFLICT DO NOTHING;`},
+		{
+			"CREATE TABLE m (\n\t\t\tstart TEXT,\n\t\t\tend TEXT NOT NULL DEFAULT '',\n\t\t\tlegend TEXT\n\t\t);",
+			"CREATE TABLE m (\n\t\t\tstart TEXT,\n\t\t\t\"end\" TEXT NOT NULL DEFAULT '',\n\t\t\tlegend TEXT\n\t\t);",
+		},
+	}
+	for _, c := range cases {
+		if got := migrationDDL(driverPostgres, c.in); got != c.want {
+			t.Errorf("postgres: got %q, want %q", got, c.want)
+		}
+	}
+
+	// автоинкрементные ключи после миграций выдаются базой
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		seen := map[int]bool{}
+		for _, key := range []string{"AUTO-1", "AUTO-2", "AUTO-3"} {
+			var id int
+			if err := conn.QueryRowContext(ctx, `INSERT INTO licenses(key) VALUES(?) RETURNING id`, key).Scan(&id); err != nil {
+				t.Fatalf("insert %s: %v", key, err)
+			}
+			if id <= 0 || seen[id] {
+				t.Fatalf("insert %s: bad id %d (seen %v)", key, id, seen)
+			}
+			seen[id] = true
+		}
+	})
+}
+
+func TestImportLicensesOnConflictReturning(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		if _, err := getStore().ImportLicenses(ctx, "test", []LicenseImport{{Key: "KEY-A"}}, false); err != nil {
+			t.Fatalf("first import: %v", err)
+		}
+
+		res, err := getStore().ImportLicenses(ctx, "test", []LicenseImport{{Key: "KEY-A"}, {Key: "KEY-B"}, {Key: "KEY-B"}}, false)
+		if err != nil {
+			t.Fatalf("second import: %v", err)
+		}
+		var statuses []string
+		for _, r := range res.Rows {
+			statuses = append(statuses, r.Status)
+		}
+		if got := strings.Join(statuses, ","); got != "duplicate,ok,duplicate" || res.Imported != 1 {
+			t.Fatalf("statuses=%s imported=%d", got, res.Imported)
+		}
+
+		n := 0
+		if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM licenses`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Fatalf("licenses: got %d, want 2", n)
+		}
+	})
+}
+
+func TestUpsertLDAPUserIsDistinctFrom(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		upsert := func(name, email string) {
+			t.Helper()
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := UpsertLDAPUser(ctx, tx, "ivan", name, email); err != nil {
+				_ = tx.Rollback()
+				t.Fatalf("upsert: %v", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		state := func() (email, updatedAt string, active int) {
+			t.Helper()
+			err := conn.QueryRowContext(ctx, `SELECT email, updated_at, active FROM users WHERE identity = ?`,
+				ldapUserIdentity("ivan")).Scan(&email, &updatedAt, &active)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		setUpdated := func(v string) {
+			t.Helper()
+			if _, err := conn.ExecContext(ctx, `UPDATE users SET updated_at = ? WHERE identity = ?`, v, ldapUserIdentity("ivan")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		upsert("Иван", "ivan@example.test")
+		setUpdated("mark")
+
+		// те же данные — строка не трогается
+		upsert("Иван", "IVAN@example.test")
+		if _, updated, _ := state(); updated != "mark" {
+			t.Fatalf("unchanged upsert touched the row: updated_at=%q", updated)
+		}
+
+		// изменился email — строка обновляется
+		upsert("Иван", "ivan.p@example.test")
+		if email, updated, _ := state(); email != "ivan.p@example.test" || updated == "mark" {
+			t.Fatalf("changed upsert: email=%q updated_at=%q", email, updated)
+		}
+
+		// деактивированная запись с теми же данными снова активируется
+		if _, err := conn.ExecContext(ctx, `UPDATE users SET active = 0, updated_at = 'mark' WHERE identity = ?`, ldapUserIdentity("ivan")); err != nil {
+			t.Fatal(err)
+		}
+		upsert("Иван", "ivan.p@example.test")
+		if _, updated, active := state(); active != 1 || updated == "mark" {
+			t.Fatalf("reactivation: active=%d updated_at=%q", active, updated)
+		}
+	})
+}
+
+func TestSearchLikeFallback(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		for _, u := range []struct{ identity, name, email string }{
+			{"manual:1", "Иванов Пётр", "petr@example.test"},
+			{"manual:2", "Скидка 100%", "sale@example.test"},
+			{"manual:3", "Скидка 1000", "nosale@example.test"},
+			{"manual:4", "user_one", "one@example.test"},
+			{"manual:5", "userxone", "xone@example.test"},
+		} {
+			if _, err := conn.ExecContext(ctx, `INSERT INTO users(identity, name, email) VALUES(?, ?, ?)`, u.identity, u.name, u.email); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// поиск без учёта регистра, в том числе по кириллице
+		res, err := getStore().Search(ctx, "ИВАНОВ", 10)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(res.Users) != 1 || res.Users[0].Name != "Иванов Пётр" {
+			t.Fatalf("search ИВАНОВ: %+v", res.Users)
+		}
+
+		// % и _ в запросе — обычные символы, а не шаблоны LIKE
+		cond, args := searchCond("100%", "name")
+		if got := likeUserNames(t, conn, cond, args); got != "Скидка 100%" {
+			t.Fatalf("LIKE 100%%: got %q", got)
+		}
+		cond, args = searchCond("USER_", "name")
+		if got := likeUserNames(t, conn, cond, args); got != "user_one" {
+			t.Fatalf("LIKE USER_: got %q", got)
+		}
+
+		// LIKE-ветка поиска (в приложении — только PostgreSQL) на обоих драйверах: все слова должны найтись
+		hits, hitArgs := searchUsers.hits(driverPostgres, []string{"скидка", "100%"}, 10)
+		if got := likeUserNames(t, conn, "id IN (SELECT hit_id FROM ("+hits+") h)", hitArgs); got != "Скидка 100%" {
+			t.Fatalf("LIKE hits: got %q", got)
+		}
+	})
+}
+
+// likeUserNames — имена пользователей по условию WHERE через запятую, по id.
+func likeUserNames(t *testing.T, conn *dbConn, where string, args []any) string {
+	t.Helper()
+	rows, err := conn.QueryContext(context.Background(), `SELECT name FROM users WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		t.Fatalf("query %s: %v", where, err)
+	}
+	names, err := scanStrings(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestUnicodeLower(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		var got string
+		if err := conn.QueryRowContext(ctx, `SELECT unicode_lower(?)`, "ПК-Бухгалтерия").Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != "пк-бухгалтерия" {
+			t.Fatalf("unicode_lower: got %q", got)
+		}
+
+		// встроенный lower() в SQLite не подменяется: кириллица остаётся как есть
+		if conn.driver == driverSQLite {
+			if err := conn.QueryRowContext(ctx, `SELECT lower(?)`, "PC-Бух").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != "pc-Бух" {
+				t.Fatalf("builtin lower() is overridden: got %q", got)
+			}
+		}
+	})
+}
+
+func TestDefaultListSortIgnoresCase(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		// побайтово «Б» и «Z» идут раньше «а» и «b»; без учёта регистра — наоборот
+		for i, name := range []string{"Бета", "альфа", "Zeta", "beta"} {
+			if _, err := conn.ExecContext(ctx, `INSERT INTO users(identity, name, email) VALUES(?, ?, ?)`,
+				fmt.Sprintf("manual:%d", i), name, fmt.Sprintf("u%d@example.test", i)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.ExecContext(ctx, `INSERT INTO computers(identity, name) VALUES(?, ?)`,
+				fmt.Sprintf("ldap:pc-%d", i), "ПК "+name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		users, _, err := getStore().ListUsersAll(ctx, UserFilter{}, ListParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, u := range users {
+			got = append(got, u.Name)
+		}
+		if want := "beta,Zeta,альфа,Бета"; strings.Join(got, ",") != want {
+			t.Fatalf("users default order: got %q, want %q", strings.Join(got, ","), want)
+		}
+
+		pcs, _, err := getStore().ListComputers(ctx, ComputerFilter{}, ListParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = got[:0]
+		for _, c := range pcs {
+			got = append(got, c.Name)
+		}
+		if want := "ПК beta,ПК Zeta,ПК альфа,ПК Бета"; strings.Join(got, ",") != want {
+			t.Fatalf("computers default order: got %q, want %q", strings.Join(got, ","), want)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SyncRun — один запуск синхронизации LDAP (журнал sync_runs).
+// Нужен, чтобы отвечать на вопрос «когда человек пропал из каталога и почему его лицензия осиротела».
+type SyncRun struct {
+	ID          int      `json:"id"`
+	Kind        string   `json:"kind"`    // users | computers
+	Trigger     string   `json:"trigger"` // startup | cron | manual
+	Mode        string   `json:"mode"`    // full | delta
+	Status      string   `json:"status"`  // ok | error | blocked
+	Forced      bool     `json:"forced"`
+	Synced      int      `json:"synced"`
+	Activated   []string `json:"activated"`
+	Deactivated []string `json:"deactivated"`
+	Error       string   `json:"error,omitempty"`
+	StartedAt   string   `json:"started_at"`
+	FinishedAt  string   `json:"finished_at"`
+	DurationMs  int64    `json:"duration_ms"`
+
+	// для заблокированных запусков (см. ldap_guard.go)
+	ActiveBefore int    `json:"-"`
+	Limit        string `json:"-"`
+}
+
+// SyncRunsFilter — параметры выборки журнала.
+type SyncRunsFilter struct {
+	Kind     string
+	Status   string
+	Identity string // подстрока identity в списках activated/deactivated
+	Limit    int
+	Offset   int
+}
+
+func InsertSyncRun(ctx context.Context, run SyncRun) error {
+	conn, err := requireDB()
+	if err != nil {
+		return err
+	}
+
+	activated, err := json.Marshal(nonNilStrings(run.Activated))
+	if err != nil {
+		return err
+	}
+	deactivated, err := json.Marshal(nonNilStrings(run.Deactivated))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO sync_runs(kind, trigger, mode, status, forced, synced, activated_count, deactivated_count,
+			activated, deactivated, error, started_at, finished_at, duration_ms, active_before, deactivate_limit)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.Kind, run.Trigger, run.Mode, run.Status, boolToInt(run.Forced), run.Synced,
+		len(run.Activated), len(run.Deactivated), string(activated), string(deactivated),
+		run.Error, run.StartedAt, run.FinishedAt, run.DurationMs, run.ActiveBefore, run.Limit)
+	return err
+}
+
+// ListSyncRuns отдаёт страницу журнала (новые сверху) и общее число записей под фильтром.
+func ListSyncRuns(ctx context.Context, f SyncRunsFilter) ([]SyncRun, int, error) {
+	conn, err := requireDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where := []string{"1=1"}
+	var args []any
+	if k := strings.TrimSpace(f.Kind); k != "" {
+		where = append(where, "kind=?")
+		args = append(args, k)
+	}
+	if st := strings.TrimSpace(f.Status); st != "" {
+		where = append(where, "status=?")
+		args = append(args, st)
+	}
+	if id := strings.ToLower(strings.TrimSpace(f.Identity)); id != "" {
+		where = append(where, "(activated LIKE ? OR deactivated LIKE ?)")
+		like := "%" + id + "%"
+		args = append(args, like, like)
+	}
+	cond := strings.Join(where, " AND ")
+
+	total := 0
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_runs WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, kind, trigger, mode, status, forced, synced, activated, deactivated,
+			error, started_at, finished_at, duration_ms
+		FROM sync_runs WHERE `+cond+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []SyncRun
+	for rows.Next() {
+		var run SyncRun
+		var forced int
+		var activated, deactivated string
+		if err := rows.Scan(&run.ID, &run.Kind, &run.Trigger, &run.Mode, &run.Status, &forced, &run.Synced,
+			&activated, &deactivated, &run.Error, &run.StartedAt, &run.FinishedAt, &run.DurationMs); err != nil {
+			return nil, 0, err
+		}
+		run.Forced = forced != 0
+		_ = json.Unmarshal([]byte(activated), &run.Activated)
+		_ = json.Unmarshal([]byte(deactivated), &run.Deactivated)
+		out = append(out, run)
+	}
+	return out, total, rows.Err()
+}
+
+// lastSyncOutcome — last_success_at и last_error для статуса синхронизации. Берутся из журнала sync_runs,
+// а не из памяти процесса: журнал общий для реплик и переживает перезапуск.
+// lastSuccessAt — к какому времени успешно синхронизированы все каталоги, то есть самая старая из последних
+// удачных синхронизаций users и computers (пусто, если какой-то каталог ни разу не синхронизирован удачно);
+// lastError — ошибки последних запусков каталогов, если те неудачны (в том числе заблокированы).
+func lastSyncOutcome(ctx context.Context) (lastSuccessAt, lastError string, err error) {
+	conn, err := requireDB()
+	if err != nil {
+		return "", "", err
+	}
+
+	var errs []string
+	synced := true
+	for _, kind := range []string{"users", "computers"} {
+		var status, runErr string
+		err := conn.QueryRowContext(ctx, `SELECT status, error FROM sync_runs WHERE kind=? ORDER BY id DESC LIMIT 1`, kind).Scan(&status, &runErr)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // каталог не синхронизировался вовсе (например, фильтр ПК не задан)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if status != "ok" && runErr != "" {
+			errs = append(errs, runErr)
+		}
+
+		okAt := ""
+		if err := conn.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(finished_at), '') FROM sync_runs WHERE kind=? AND status='ok'
+		`, kind).Scan(&okAt); err != nil {
+			return "", "", err
+		}
+		switch {
+		case okAt == "":
+			synced = false
+		case lastSuccessAt == "" || okAt < lastSuccessAt:
+			lastSuccessAt = okAt
+		}
+	}
+	if !synced {
+		lastSuccessAt = ""
+	}
+	return lastSuccessAt, strings.Join(errs, "; "), nil
+}
+
+func nonNilStrings(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
+
+// =============== API (admin) ===============
+
+// журнал синхронизаций: ?kind=users&status=blocked&identity=ivanov&limit=50&offset=0
+func handleSyncRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f := SyncRunsFilter{
+		Kind:     q.Get("kind"),
+		Status:   q.Get("status"),
+		Identity: q.Get("identity"),
+		Limit:    50,
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			httpError(w, "limit должен быть от 1 до 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, "некорректный offset", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+
+	runs, total, err := ListSyncRuns(r.Context(), f)
+	if err != nil {
+		httpError(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Runs   []SyncRun `json:"runs"`
+		Total  int       `json:"total"`
+		Limit  int       `json:"limit"`
+		Offset int       `json:"offset"`
+	}{
+		Runs:   runs,
+		Total:  total,
+		Limit:  f.Limit,
+		Offset: f.Offset,
+	})
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLastSyncOutcomeFromSyncRuns(t *testing.T) {
+	forEachTestDB(t, Config{}, func(t *testing.T, conn *dbConn) {
+		ctx := context.Background()
+		insert := func(kind, status, errText, finished string) {
+			t.Helper()
+			run := SyncRun{Kind: kind, Trigger: "cron", Mode: "full", Status: status, Error: errText, StartedAt: finished, FinishedAt: finished}
+			if err := InsertSyncRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check := func(wantSuccess, wantError string) {
+			t.Helper()
+			success, lastErr, err := lastSyncOutcome(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if success != wantSuccess || lastErr != wantError {
+				t.Fatalf("got last_success_at=%q last_error=%q, want %q %q", success, lastErr, wantSuccess, wantError)
+			}
+		}
+
+		check("", "")
+		insert("users", "error", "ldap: connection refused", "2026-01-01T09:00:00Z")
+		check("", "ldap: connection refused") // пользователи ни разу не синхронизированы удачно
+
+		insert("users", "ok", "", "2026-01-01T10:00:00Z")
+		insert("computers", "ok", "", "2026-01-01T10:05:00Z")
+		check("2026-01-01T10:00:00Z", "")
+
+		insert("computers", "blocked", "ldap_sync_blocked: would deactivate 40 of 50", "2026-01-01T11:00:00Z")
+		insert("users", "ok", "", "2026-01-01T11:00:00Z")
+		check("2026-01-01T10:05:00Z", "ldap_sync_blocked: would deactivate 40 of 50")
+
+		// статус после перезапуска: в памяти ничего, журнал — в БД
+		w := httptest.NewRecorder()
+		handleLDAPSyncStatus(w, httptest.NewRequest("GET", "/api/admin/ldap/sync", nil))
+		var st LDAPSyncStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+			t.Fatalf("status: %v: %s", err, w.Body.String())
+		}
+		if st.LastSuccessAt != "2026-01-01T10:05:00Z" || st.LastError == "" {
+			t.Fatalf("status: got %+v", st)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPGDSNEnv — DSN PostgreSQL (URL) для тестов хранилища; пустой — варианты на PostgreSQL пропускаются.
+// Каждый тест создаёт себе отдельную базу, поэтому пользователю нужно право CREATE DATABASE.
+const testPGDSNEnv = "ONESSA_TEST_PG_DSN"
+
+// newTestDB поднимает чистую SQLite-БД с применёнными миграциями и конфиг cfg на время теста;
+// после теста прежние соединение и конфиг возвращаются на место.
+func newTestDB(t *testing.T, cfg Config) *dbConn {
+	t.Helper()
+	return newTestDBDriver(t, driverSQLite, cfg)
+}
+
+// forEachTestDB выполняет fn подтестами на SQLite и на PostgreSQL (если задан ONESSA_TEST_PG_DSN).
+func forEachTestDB(t *testing.T, cfg Config, fn func(t *testing.T, conn *dbConn)) {
+	t.Helper()
+	for _, driver := range []string{driverSQLite, driverPostgres} {
+		t.Run(driver, func(t *testing.T) {
+			fn(t, newTestDBDriver(t, driver, cfg))
+		})
+	}
+}
+
+func newTestDBDriver(t *testing.T, driver string, cfg Config) *dbConn {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg.DataDir = dir
+	cfg.DBDriver = driver
+	prevCfg := getConfig()
+	SetConfig(cfg)
+	t.Cleanup(func() { SetConfig(prevCfg) })
+
+	var conn *dbConn
+	var err error
+	switch driver {
+	case driverSQLite:
+		conn, _, err = openSQLite(dir)
+	case driverPostgres:
+		conn, err = openTestPostgres(t)
+	default:
+		t.Fatalf("unknown driver %q", driver)
+	}
+	if err != nil {
+		t.Fatalf("open %s: %v", driver, err)
+	}
+	if _, err := applyMigrations(context.Background(), conn); err != nil {
+		_ = conn.Close()
+		t.Fatalf("migrations (%s): %v", driver, err)
+	}
+
+	prev := setDB(conn)
+	t.Cleanup(func() {
+		setDB(prev)
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// openTestPostgres создаёт отдельную базу под тест и удаляет её после теста.
+func openTestPostgres(t *testing.T) (*dbConn, error) {
+	t.Helper()
+
+	dsn := strings.TrimSpace(os.Getenv(testPGDSNEnv))
+	if dsn == "" {
+		t.Skipf("%s is not set", testPGDSNEnv)
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("%s must be a postgres:// URL", testPGDSNEnv)
+	}
+
+	admin, _, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("onessa_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(context.Background(), `CREATE DATABASE `+name); err != nil {
+		_ = admin.Close()
+		return nil, err
+	}
+	// регистрируется раньше закрытия соединения в newTestDBDriver, поэтому выполняется после него
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(context.Background(), `DROP DATABASE `+name); err != nil {
+			t.Logf("drop test database %s: %v", name, err)
+		}
+		_ = admin.Close()
+	})
+
+	u.Path = "/" + name
+	conn, _, err := openPostgres(u.String())
+	return conn, err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ryantrue/onessa/app"
+)
+
+// runBackup — режим CLI: onessa backup [file].
+// Без аргумента копия кладётся в <DATA_DIR>/backups (с учётом BACKUP_KEEP). Сервер можно не останавливать.
+func runBackup(ctx context.Context, cfg app.Config, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: onessa backup [file]")
+		return 2
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	out, err := app.BackupTo(ctx, cfg, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Printf("backup created: %s\n", out)
+	return 0
+}
+
+// runRestore — режим CLI: onessa restore <file>.
+// Сервер должен быть остановлен; на работающем сервере используйте POST /api/admin/backups/restore.
+func runRestore(ctx context.Context, cfg app.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: onessa restore <file>")
+		return 2
+	}
+	res, err := app.RestoreOffline(ctx, cfg, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Printf("restored %s (schema version %d), previous database saved to backups/%s\n", args[0], res.SchemaVersion, res.PreRestore)
+	return 0
+}
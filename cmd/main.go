@@ -32,6 +32,25 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Обслуживание БД без запуска сервера:
+	// onessa migrate status|up, onessa backup [file], onessa restore <file>.
+	if len(os.Args) > 1 {
+		var run func(context.Context, app.Config, []string) int
+		switch os.Args[1] {
+		case "migrate":
+			run = runMigrate
+		case "backup":
+			run = runBackup
+		case "restore":
+			run = runRestore
+		}
+		if run != nil {
+			code := run(ctx, cfg, os.Args[2:])
+			stop()
+			os.Exit(code)
+		}
+	}
+
 	if err := app.Init(ctx, cfg); err != nil {
 		logging.Warnf("cannot load data: %v", err)
 	}
@@ -47,10 +66,29 @@ func main() {
 		IdleTimeout:       60 * time.Second,
 	}
 
+	// /metrics на отдельном адресе (METRICS_ADDR), чтобы не открывать его наружу вместе с UI
+	var metricsSrv *http.Server
+	if h := app.NewMetricsHandler(cfg); h != nil {
+		metricsSrv = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           h,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			logging.Infof("starting metrics server on %s", cfg.MetricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logging.Errorf("metrics server error: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		<-ctx.Done()
 		ctxTimeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		if metricsSrv != nil {
+			_ = metricsSrv.Shutdown(ctxTimeout)
+		}
 		_ = srv.Shutdown(ctxTimeout)
 	}()
 
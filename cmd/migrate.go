@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ryantrue/onessa/app"
+)
+
+// runMigrate — режим CLI: onessa migrate status|up.
+// Работает с той же БД, что и сервер (DATA_DIR / DB_PATH), HTTP-сервер не запускается.
+func runMigrate(ctx context.Context, cfg app.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: onessa migrate status|up")
+		return 2
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := app.MigrateStatus(ctx, cfg)
+		printMigrations(status)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		pending := 0
+		for _, st := range status {
+			if !st.Applied {
+				pending++
+			}
+		}
+		fmt.Printf("%d pending\n", pending)
+		return 0
+	case "up":
+		done, err := app.MigrateUp(ctx, cfg)
+		printMigrations(done)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		fmt.Printf("%d applied\n", len(done))
+		return 0
+	default:
+		fmt.Fprintln(os.Stderr, "usage: onessa migrate status|up")
+		return 2
+	}
+}
+
+func printMigrations(items []app.MigrationStatus) {
+	if len(items) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	for _, st := range items {
+		at := "pending"
+		if st.Applied {
+			at = st.AppliedAt
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", st.Version, st.Name, at)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,523 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// =============== общее ===============
+
+// Me — текущий пользователь и роль (GET /api/me).
+func (c *Client) Me(ctx context.Context) (Me, error) {
+	var out Me
+	err := c.do(ctx, http.MethodGet, "/api/me", nil, nil, &out)
+	return out, err
+}
+
+// State — пользователи и лицензии (GET /api/state).
+func (c *Client) State(ctx context.Context) (users []User, licenses []License, err error) {
+	var out struct {
+		Users    []User    `json:"users"`
+		Licenses []License `json:"licenses"`
+	}
+	err = c.do(ctx, http.MethodGet, "/api/state", nil, nil, &out)
+	return out.Users, out.Licenses, err
+}
+
+// OpenAPI — спецификация API в JSON (GET /api/openapi.json).
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/api/openapi.json", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Search — поиск по пользователям, ПК, лицензиям и встречам (limit — на группу, 0 — по умолчанию сервера).
+func (c *Client) Search(ctx context.Context, query string, limit int) (SearchResults, error) {
+	q := url.Values{"q": {query}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out SearchResults
+	err := c.do(ctx, http.MethodGet, "/api/search", q, nil, &out)
+	return out, err
+}
+
+// =============== пользователи и ПК ===============
+
+// ImportUsers — ручной импорт пользователей (только когда LDAP не настроен).
+func (c *Client) ImportUsers(ctx context.Context, users []ManualUserImport) (ImportResult, error) {
+	var out struct {
+		Imported int      `json:"users_imported"`
+		Warnings []string `json:"warnings"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/users/import", nil, map[string]any{"users": users}, &out)
+	return ImportResult{Imported: out.Imported, Warnings: out.Warnings}, err
+}
+
+// ListUsersAll — пользователи, включая неактивных.
+func (c *Client) ListUsersAll(ctx context.Context, f UserFilter, opt ListOptions) (UserPage, error) {
+	q := listQuery(opt)
+	setQuery(q, "q", f.Search)
+	setQuery(q, "source", f.Source)
+	if f.Active != nil {
+		q.Set("active", strconv.FormatBool(*f.Active))
+	}
+	var out UserPage
+	err := c.do(ctx, http.MethodGet, "/api/users/all", q, nil, &out)
+	return out, err
+}
+
+// GetUser — пользователь (в т.ч. неактивный) и его лицензии.
+func (c *Client) GetUser(ctx context.Context, id int) (UserDetails, error) {
+	var out UserDetails
+	err := c.do(ctx, http.MethodGet, "/api/users/"+strconv.Itoa(id), nil, nil, &out)
+	return out, err
+}
+
+// GetComputer — ПК (в т.ч. деактивированный) и привязанные к нему лицензии.
+func (c *Client) GetComputer(ctx context.Context, id int) (ComputerDetails, error) {
+	var out ComputerDetails
+	err := c.do(ctx, http.MethodGet, "/api/computers/"+strconv.Itoa(id), nil, nil, &out)
+	return out, err
+}
+
+// ListComputers — активные ПК из каталога; search — подстрока имени, DNS-имени или описания.
+func (c *Client) ListComputers(ctx context.Context, search string, opt ListOptions) (ComputerPage, error) {
+	q := listQuery(opt)
+	setQuery(q, "q", search)
+	var out ComputerPage
+	err := c.do(ctx, http.MethodGet, "/api/computers", q, nil, &out)
+	return out, err
+}
+
+// =============== лицензии ===============
+
+// ListLicenses — лицензии с фильтрами.
+func (c *Client) ListLicenses(ctx context.Context, f LicenseFilter, opt ListOptions) (LicensePage, error) {
+	q := listQuery(opt)
+	setQuery(q, "product", f.Product)
+	setQuery(q, "version", f.Version)
+	setQuery(q, "license_type", f.LicenseType)
+	setQuery(q, "expires_before", f.ExpiresBefore)
+	setQuery(q, "expires_after", f.ExpiresAfter)
+	setQuery(q, "q", f.Search)
+	if f.Expired != nil {
+		q.Set("expired", strconv.FormatBool(*f.Expired))
+	}
+	if f.Assigned != nil {
+		q.Set("assigned", strconv.FormatBool(*f.Assigned))
+	}
+	if f.UserID > 0 {
+		q.Set("user_id", strconv.Itoa(f.UserID))
+	}
+
+	var out LicensePage
+	err := c.do(ctx, http.MethodGet, "/api/licenses", q, nil, &out)
+	return out, err
+}
+
+// ImportLicenses — импорт лицензий; уже существующие ключи попадают в Warnings.
+func (c *Client) ImportLicenses(ctx context.Context, licenses []LicenseImport) (ImportResult, error) {
+	var out struct {
+		Imported int      `json:"licenses_imported"`
+		Warnings []string `json:"warnings"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/licenses/import", nil, map[string]any{"licenses": licenses}, &out)
+	return ImportResult{Imported: out.Imported, Warnings: out.Warnings}, err
+}
+
+// CheckLicenses проверяет импорт без записи (dry_run): итог по каждой строке.
+func (c *Client) CheckLicenses(ctx context.Context, licenses []LicenseImport) (LicenseImportReport, error) {
+	var out LicenseImportReport
+	err := c.do(ctx, http.MethodPost, "/api/licenses/import", url.Values{"dry_run": {"true"}}, map[string]any{"licenses": licenses}, &out)
+	return out, err
+}
+
+// ImportLicensesFile загружает CSV или XLSX (name — имя файла) на сервер для импорта или проверки.
+func (c *Client) ImportLicensesFile(ctx context.Context, name string, r io.Reader, opt LicenseFileOptions) (LicenseImportReport, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return LicenseImportReport{}, err
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return LicenseImportReport{}, err
+	}
+	if opt.NoHeader {
+		_ = mw.WriteField("header", "false")
+	}
+	for field, col := range opt.Columns {
+		_ = mw.WriteField("column_"+field, col)
+	}
+	if err := mw.Close(); err != nil {
+		return LicenseImportReport{}, err
+	}
+
+	q := url.Values{}
+	if opt.DryRun {
+		q.Set("dry_run", "true")
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/api/licenses/import", q, &body, mw.FormDataContentType())
+	if err != nil {
+		return LicenseImportReport{}, err
+	}
+	defer resp.Body.Close()
+	var out LicenseImportReport
+	err = decodeJSON(resp.Body, &out)
+	return out, err
+}
+
+// GetLicense — лицензия по id.
+func (c *Client) GetLicense(ctx context.Context, id int) (License, error) {
+	var out License
+	err := c.do(ctx, http.MethodGet, licensePath(id), nil, nil, &out)
+	return out, err
+}
+
+// PatchLicense меняет переданные поля лицензии и возвращает её новое состояние.
+func (c *Client) PatchLicense(ctx context.Context, id int, patch LicensePatch) (License, error) {
+	var out License
+	err := c.do(ctx, http.MethodPatch, licensePath(id), nil, patch, &out)
+	return out, err
+}
+
+// DeleteLicense удаляет лицензию (история на сервере сохраняется).
+func (c *Client) DeleteLicense(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, licensePath(id), nil, nil, nil)
+}
+
+// AssignLicense назначает лицензию пользователю.
+func (c *Client) AssignLicense(ctx context.Context, userID, licenseID int) error {
+	return c.do(ctx, http.MethodPut, licensePath(licenseID)+"/assignment", nil, map[string]int{"user_id": userID}, nil)
+}
+
+// UpdateLicense задаёт комментарий и ПК лицензии (ComputerID — ПК из справочника).
+func (c *Client) UpdateLicense(ctx context.Context, req UpdateLicenseRequest) error {
+	patch := LicensePatch{Comment: &req.Comment, PC: &req.PC}
+	if req.ComputerID > 0 {
+		patch.ComputerID = &req.ComputerID
+	}
+	_, err := c.PatchLicense(ctx, req.LicenseID, patch)
+	return err
+}
+
+// UnassignLicense снимает лицензию с пользователя.
+func (c *Client) UnassignLicense(ctx context.Context, licenseID int) error {
+	return c.do(ctx, http.MethodDelete, licensePath(licenseID)+"/assignment", nil, nil, nil)
+}
+
+// LicenseHistory — история изменений лицензии (доступна и после её удаления).
+func (c *Client) LicenseHistory(ctx context.Context, licenseID int) ([]LicenseEvent, error) {
+	var out struct {
+		Events []LicenseEvent `json:"events"`
+	}
+	err := c.do(ctx, http.MethodGet, licensePath(licenseID)+"/history", nil, nil, &out)
+	return out.Events, err
+}
+
+func licensePath(id int) string {
+	return "/api/licenses/" + strconv.Itoa(id)
+}
+
+// ReclaimReport — лицензии, закреплённые за неактивными пользователями.
+func (c *Client) ReclaimReport(ctx context.Context) (ReclaimReport, error) {
+	var out ReclaimReport
+	err := c.do(ctx, http.MethodGet, "/api/licenses/reclaim", nil, nil, &out)
+	return out, err
+}
+
+// ExpiringLicenses — лицензии, истекающие в ближайшие days дней (0 — окно сервера по умолчанию).
+func (c *Client) ExpiringLicenses(ctx context.Context, days int) (ExpiringLicenses, error) {
+	q := url.Values{}
+	if days > 0 {
+		q.Set("days", strconv.Itoa(days))
+	}
+	var out ExpiringLicenses
+	err := c.do(ctx, http.MethodGet, "/api/licenses/expiring", q, nil, &out)
+	return out, err
+}
+
+// LicensePCIssues — лицензии, чей ПК отсутствует в каталоге или деактивирован.
+func (c *Client) LicensePCIssues(ctx context.Context) (LicensePCIssues, error) {
+	var out LicensePCIssues
+	err := c.do(ctx, http.MethodGet, "/api/licenses/pc-issues", nil, nil, &out)
+	return out, err
+}
+
+// =============== встречи ===============
+
+// ImportMeetings заменяет снимок встреч; возвращает число загруженных.
+func (c *Client) ImportMeetings(ctx context.Context, exportedAt string, items []Meeting) (int, error) {
+	var out struct {
+		Imported int `json:"meetings_imported"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/meetings/import", nil, MeetingsState{ExportedAt: exportedAt, Items: items}, &out)
+	return out.Imported, err
+}
+
+// Meetings — текущий снимок встреч.
+func (c *Client) Meetings(ctx context.Context) (MeetingsState, error) {
+	var out MeetingsState
+	err := c.do(ctx, http.MethodGet, "/api/meetings", nil, nil, &out)
+	return out, err
+}
+
+// =============== администрирование ===============
+
+// ListSessions — активные сессии (username — фильтр, пусто — все).
+func (c *Client) ListSessions(ctx context.Context, username string) ([]Session, error) {
+	q := url.Values{}
+	setQuery(q, "username", username)
+	var out struct {
+		Sessions []Session `json:"sessions"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/admin/sessions", q, nil, &out)
+	return out.Sessions, err
+}
+
+// RevokeSession отзывает одну сессию.
+func (c *Client) RevokeSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/admin/sessions/revoke", nil, map[string]string{"id": id}, nil)
+}
+
+// RevokeUserSessions отзывает все сессии пользователя; возвращает их число.
+func (c *Client) RevokeUserSessions(ctx context.Context, username string) (int, error) {
+	var out struct {
+		Revoked int `json:"revoked"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/admin/sessions/revoke", nil, map[string]string{"username": username}, &out)
+	return out.Revoked, err
+}
+
+// LDAPSyncStatus — состояние последней синхронизации LDAP.
+func (c *Client) LDAPSyncStatus(ctx context.Context) (LDAPSyncStatus, error) {
+	var out LDAPSyncStatus
+	err := c.do(ctx, http.MethodGet, "/api/admin/ldap/sync", nil, nil, &out)
+	return out, err
+}
+
+// StartLDAPSync запускает синхронизацию в фоне (результат — LDAPSyncStatus).
+func (c *Client) StartLDAPSync(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/admin/ldap/sync", nil, nil, nil)
+}
+
+// LDAPBlockedSyncs — синхронизации, остановленные защитой от массовой деактивации.
+func (c *Client) LDAPBlockedSyncs(ctx context.Context) ([]LDAPBlockedSync, error) {
+	var out struct {
+		Blocked []LDAPBlockedSync `json:"blocked"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/admin/ldap/blocked", nil, nil, &out)
+	return out.Blocked, err
+}
+
+// ConfirmLDAPSync запускает в фоне заблокированную синхронизацию kind (users | computers), подтверждая
+// запуск runID из LDAPBlockedSyncs: деактивировать можно только его список (результат — LDAPSyncStatus).
+func (c *Client) ConfirmLDAPSync(ctx context.Context, kind string, runID int) (LDAPSyncConfirmResult, error) {
+	var out LDAPSyncConfirmResult
+	in := struct {
+		Kind  string `json:"kind"`
+		RunID int    `json:"run_id"`
+	}{kind, runID}
+	err := c.do(ctx, http.MethodPost, "/api/admin/ldap/sync/confirm", nil, in, &out)
+	return out, err
+}
+
+// SyncRuns — журнал запусков синхронизации.
+func (c *Client) SyncRuns(ctx context.Context, f SyncRunsFilter) (SyncRuns, error) {
+	q := url.Values{}
+	setQuery(q, "kind", f.Kind)
+	setQuery(q, "status", f.Status)
+	setQuery(q, "identity", f.Identity)
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Offset > 0 {
+		q.Set("offset", strconv.Itoa(f.Offset))
+	}
+	var out SyncRuns
+	err := c.do(ctx, http.MethodGet, "/api/admin/ldap/sync/runs", q, nil, &out)
+	return out, err
+}
+
+// RunReclaim применяет политику возврата лицензий.
+func (c *Client) RunReclaim(ctx context.Context) (ReclaimResult, error) {
+	var out ReclaimResult
+	err := c.do(ctx, http.MethodPost, "/api/admin/licenses/reclaim/run", nil, nil, &out)
+	return out, err
+}
+
+// RunExpiryNotify рассылает напоминания об окончании срока лицензий.
+func (c *Client) RunExpiryNotify(ctx context.Context) (ExpiryNotifyResult, error) {
+	var out ExpiryNotifyResult
+	err := c.do(ctx, http.MethodPost, "/api/admin/licenses/expiry/notify", nil, nil, &out)
+	return out, err
+}
+
+// =============== журнал аудита ===============
+
+// ListAudit — журнал аудита. Порядок — по opt.Desc (true — новые первыми), без opt.Limit сервер отдаёт 50 записей.
+func (c *Client) ListAudit(ctx context.Context, f AuditFilter, opt ListOptions) (AuditPage, error) {
+	q := auditQuery(f)
+	for k, v := range listQuery(opt) {
+		q[k] = v
+	}
+	if !opt.Desc {
+		q.Set("order", "asc")
+	}
+	var out AuditPage
+	err := c.do(ctx, http.MethodGet, "/api/admin/audit", q, nil, &out)
+	return out, err
+}
+
+// ExportAudit записывает в w весь журнал под фильтром; format — csv или json.
+func (c *Client) ExportAudit(ctx context.Context, f AuditFilter, format string, w io.Writer) error {
+	q := auditQuery(f)
+	setQuery(q, "format", format)
+	resp, err := c.send(ctx, http.MethodGet, "/api/admin/audit/export", q, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func auditQuery(f AuditFilter) url.Values {
+	q := url.Values{}
+	setQuery(q, "actor", f.Actor)
+	setQuery(q, "action", f.Action)
+	setQuery(q, "entity", f.Entity)
+	setQuery(q, "entity_id", f.EntityID)
+	setQuery(q, "from", f.From)
+	setQuery(q, "to", f.To)
+	if f.Failed != nil {
+		q.Set("failed", strconv.FormatBool(*f.Failed))
+	}
+	return q
+}
+
+// LoginLockouts — неудачные входы и блокировки входа (по логину и по IP).
+func (c *Client) LoginLockouts(ctx context.Context) ([]LoginLockout, error) {
+	var out struct {
+		Items []LoginLockout `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/admin/lockouts", nil, nil, &out)
+	return out.Items, err
+}
+
+// ClearUserLockout снимает блокировку входа для логина.
+func (c *Client) ClearUserLockout(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodPost, "/api/admin/lockouts/clear", nil, map[string]string{"username": username}, nil)
+}
+
+// ClearIPLockout снимает блокировку входа для IP-адреса.
+func (c *Client) ClearIPLockout(ctx context.Context, ip string) error {
+	return c.do(ctx, http.MethodPost, "/api/admin/lockouts/clear", nil, map[string]string{"ip": ip}, nil)
+}
+
+// =============== API-токены ===============
+
+// ListAPITokens — API-токены, включая отозванные; новые первыми.
+func (c *Client) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	var out struct {
+		Items []APIToken `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/admin/tokens", nil, nil, &out)
+	return out.Items, err
+}
+
+// CreateAPIToken создаёт токен; секрет (CreatedAPIToken.Token) сервер возвращает только здесь.
+func (c *Client) CreateAPIToken(ctx context.Context, req CreateAPITokenRequest) (CreatedAPIToken, error) {
+	var out CreatedAPIToken
+	err := c.do(ctx, http.MethodPost, "/api/admin/tokens", nil, req, &out)
+	return out, err
+}
+
+// RevokeAPIToken отзывает токен.
+func (c *Client) RevokeAPIToken(ctx context.Context, id int) (APIToken, error) {
+	var out APIToken
+	err := c.do(ctx, http.MethodDelete, "/api/admin/tokens/"+strconv.Itoa(id), nil, nil, &out)
+	return out, err
+}
+
+// =============== резервные копии ===============
+
+// ListBackups — резервные копии на сервере, новые первыми.
+func (c *Client) ListBackups(ctx context.Context) ([]BackupInfo, error) {
+	var out struct {
+		Items []BackupInfo `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/admin/backups", nil, nil, &out)
+	return out.Items, err
+}
+
+// CreateBackup снимает резервную копию на сервере.
+func (c *Client) CreateBackup(ctx context.Context) (BackupInfo, error) {
+	var out BackupInfo
+	err := c.do(ctx, http.MethodPost, "/api/admin/backups", nil, nil, &out)
+	return out, err
+}
+
+// DownloadBackup записывает копию name в w.
+func (c *Client) DownloadBackup(ctx context.Context, name string, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/api/admin/backups/"+url.PathEscape(name), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// RestoreBackup восстанавливает БД из копии name, лежащей на сервере.
+func (c *Client) RestoreBackup(ctx context.Context, name string) (RestoreResult, error) {
+	var out RestoreResult
+	err := c.do(ctx, http.MethodPost, "/api/admin/backups/restore", url.Values{"name": {name}}, nil, &out)
+	return out, err
+}
+
+// RestoreBackupFile восстанавливает БД из файла SQLite, переданного в r.
+// Загрузка большой копии может идти дольше таймаута клиента по умолчанию (60 с) —
+// для неё передайте в New свой *http.Client через WithHTTPClient.
+func (c *Client) RestoreBackupFile(ctx context.Context, r io.Reader) (RestoreResult, error) {
+	resp, err := c.send(ctx, http.MethodPost, "/api/admin/backups/restore", nil, r, "application/octet-stream")
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	defer resp.Body.Close()
+	var out RestoreResult
+	err = decodeJSON(resp.Body, &out)
+	return out, err
+}
+
+// listQuery — параметры сортировки и страницы.
+func listQuery(opt ListOptions) url.Values {
+	q := url.Values{}
+	setQuery(q, "sort", opt.Sort)
+	setQuery(q, "cursor", opt.Cursor)
+	if opt.Desc {
+		q.Set("order", "desc")
+	}
+	if opt.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opt.Limit))
+	}
+	if opt.Offset > 0 {
+		q.Set("offset", strconv.Itoa(opt.Offset))
+	}
+	return q
+}
+
+func setQuery(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
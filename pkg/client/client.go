@@ -0,0 +1,225 @@
+// Package client — типизированный клиент HTTP API onessa (/api).
+//
+// Методы соответствуют операциям из /api/openapi.json (app/openapi.json).
+// Авторизация — X-API-Token (WithAPIToken) или сессия после Login (cookie хранится в клиенте;
+// изменяющие запросы по сессии клиент сам дополняет заголовком X-CSRF-Token).
+//
+//	c, err := client.New("https://onessa.example.local", client.WithAPIToken(token))
+//	res, err := c.ImportLicenses(ctx, []client.LicenseImport{{Key: "XXXX-1"}})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client — клиент API. Безопасен для использования из нескольких горутин.
+type Client struct {
+	baseURL  *url.URL
+	http     *http.Client
+	apiToken string
+}
+
+// Option настраивает Client.
+type Option func(*Client)
+
+// WithAPIToken — передавать токен в заголовке X-API-Token (именной токен из CreateAPIToken).
+func WithAPIToken(token string) Option {
+	return func(c *Client) { c.apiToken = token }
+}
+
+// WithHTTPClient — свой *http.Client (таймауты, TLS, прокси).
+// Для Login у него должен быть Jar; если Jar не задан, клиент создаст его сам.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// New создаёт клиент для сервера baseURL (например, "http://localhost:8080").
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("bad base url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("bad base url %q: scheme and host are required", baseURL)
+	}
+
+	c := &Client{baseURL: u, http: &http.Client{Timeout: 60 * time.Second}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http.Jar == nil {
+		jar, _ := cookiejar.New(nil)
+		hc := *c.http
+		hc.Jar = jar
+		c.http = &hc
+	}
+	return c, nil
+}
+
+// APIError — ответ сервера с кодом не 2xx.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("onessa api: %d %s", e.StatusCode, e.Message)
+}
+
+// IsStatus — err является APIError с указанным кодом.
+func IsStatus(err error, code int) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.StatusCode == code
+}
+
+// ErrLoginFailed — неверный логин/пароль или у пользователя нет доступа.
+var ErrLoginFailed = errors.New("onessa api: login failed")
+
+// csrfCookieName — cookie с CSRF-токеном, который сервер ждёт в форме входа и в заголовке X-CSRF-Token.
+const csrfCookieName = "cp_csrf"
+
+// Login открывает сессию (LDAP-логин); cookie сессии сохраняется в клиенте.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	// форма входа принимается только с токеном из cookie cp_csrf, которую выдаёт GET /login
+	pre, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/login", nil), nil)
+	if err != nil {
+		return err
+	}
+	preResp, err := c.http.Do(pre)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, preResp.Body)
+	preResp.Body.Close()
+
+	form := url.Values{
+		"username":   {username},
+		"password":   {password},
+		"next":       {"/api/me"},
+		"csrf_token": {c.cookie(csrfCookieName)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/login", nil), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	// при успехе сервер перенаправляет на next (/api/me), при ошибке — обратно на /login?err=...
+	if resp.Request.URL.Path != "/api/me" || resp.StatusCode != http.StatusOK {
+		if msg := resp.Request.URL.Query().Get("err"); msg != "" {
+			return fmt.Errorf("%w: %s", ErrLoginFailed, msg)
+		}
+		return ErrLoginFailed
+	}
+	return nil
+}
+
+// cookie — значение cookie сервера из Jar клиента ("" если её нет).
+func (c *Client) cookie(name string) string {
+	for _, ck := range c.http.Jar.Cookies(c.baseURL) {
+		if ck.Name == name {
+			return ck.Value
+		}
+	}
+	return ""
+}
+
+func (c *Client) url(path string, q url.Values) string {
+	u := *c.baseURL
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	if len(q) > 0 {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// do выполняет запрос; in (если не nil) кодируется в JSON, ответ декодируется в out (если не nil).
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	resp, err := c.send(ctx, method, path, q, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return decodeJSON(resp.Body, out)
+}
+
+func decodeJSON(r io.Reader, out any) error {
+	if err := json.NewDecoder(r).Decode(out); err != nil {
+		return fmt.Errorf("onessa api: decode response: %w", err)
+	}
+	return nil
+}
+
+// send отправляет запрос и проверяет статус; тело ответа закрывает вызывающий.
+func (c *Client) send(ctx context.Context, method, path string, q url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, q), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiToken != "" {
+		req.Header.Set("X-API-Token", c.apiToken)
+	} else if method != http.MethodGet && method != http.MethodHead {
+		if token := c.cookie(csrfCookieName); token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+	}
+
+	// без сессии сервер перенаправляет на /login — для API это 401
+	hc := *c.http
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusFound && strings.HasPrefix(resp.Header.Get("Location"), "/login") {
+		return nil, &APIError{StatusCode: http.StatusUnauthorized, Message: "not authenticated"}
+	}
+	var e struct {
+		Error string `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(b, &e) != nil || e.Error == "" {
+		e.Error = strings.TrimSpace(string(b))
+	}
+	return nil, &APIError{StatusCode: resp.StatusCode, Message: e.Error}
+}
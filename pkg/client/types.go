@@ -0,0 +1,439 @@
+package client
+
+import "encoding/json"
+
+// Типы запросов и ответов (components/schemas в openapi.json).
+
+type User struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type UserFull struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Login  string `json:"login"`
+	Source string `json:"source"`
+	Active bool   `json:"active"`
+}
+
+type ManualUserImport struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Computer struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	DNSHostName string `json:"dns_host_name"`
+	Description string `json:"description"`
+}
+
+type ComputerFull struct {
+	Computer
+	Source string `json:"source"`
+	Active bool   `json:"active"`
+}
+
+type ComputerDetails struct {
+	ComputerFull
+	Licenses []License `json:"licenses"`
+}
+
+type UserDetails struct {
+	UserFull
+	Licenses []License `json:"licenses"`
+}
+
+type License struct {
+	ID               int    `json:"id"`
+	Key              string `json:"key"`
+	AssignedUserID   int    `json:"assigned_user_id"`
+	Comment          string `json:"comment"`
+	PC               string `json:"pc"`
+	ComputerID       int    `json:"computer_id"`
+	Product          string `json:"product"`
+	Version          string `json:"version"`
+	LicenseType      string `json:"license_type"`
+	PurchaseDate     string `json:"purchase_date"`
+	ExpiresAt        string `json:"expires_at"`
+	ReclaimFlaggedAt string `json:"reclaim_flagged_at,omitempty"`
+	AssignedUserName string `json:"assigned_user_name,omitempty"`
+}
+
+type LicenseImport struct {
+	Key          string `json:"key"`
+	Comment      string `json:"comment,omitempty"`
+	PC           string `json:"pc,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Version      string `json:"version,omitempty"`
+	LicenseType  string `json:"license_type,omitempty"`
+	PurchaseDate string `json:"purchase_date,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	UserLogin    string `json:"user_login,omitempty"` // сразу назначить активному пользователю с этим логином
+}
+
+// LicenseFileOptions — параметры ImportLicensesFile.
+type LicenseFileOptions struct {
+	NoHeader bool              // в файле нет строки заголовка
+	Columns  map[string]string // поле (key, comment, pc, user_login, ...) → имя колонки, номер с 1 или буква
+	DryRun   bool              // только проверить, ничего не записывая
+}
+
+type LicenseImportRow struct {
+	Row    int    `json:"row"`
+	Key    string `json:"key"`
+	Status string `json:"status"` // ok | duplicate | invalid
+	Error  string `json:"error,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+// LicenseImportReport — итог импорта по строкам; при DryRun Imported — сколько будет добавлено.
+type LicenseImportReport struct {
+	Imported int                `json:"licenses_imported"`
+	DryRun   bool               `json:"dry_run"`
+	Warnings []string           `json:"warnings"`
+	Rows     []LicenseImportRow `json:"rows"`
+}
+
+// LicenseFilter — фильтры ListLicenses (пустые поля не передаются).
+type LicenseFilter struct {
+	Product       string
+	Version       string
+	LicenseType   string
+	ExpiresBefore string // YYYY-MM-DD
+	ExpiresAfter  string // YYYY-MM-DD
+	Expired       *bool
+	Assigned      *bool  // true — занятые, false — свободные
+	UserID        int    // лицензии пользователя
+	Search        string // подстрока ключа, комментария, ПК или имени пользователя
+}
+
+// UserFilter — фильтры ListUsersAll (пустые поля не передаются).
+type UserFilter struct {
+	Search string // подстрока имени, email или логина
+	Active *bool
+	Source string // ldap | manual
+}
+
+// ListOptions — сортировка и страница списка (нулевые значения не передаются; без Limit — весь список).
+// Следующая страница — тот же запрос с Cursor = Page.NextCursor.
+type ListOptions struct {
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+type Page struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor"`
+}
+
+type LicensePage struct {
+	Licenses []License `json:"licenses"`
+	Page
+}
+
+type UserPage struct {
+	Users []UserFull `json:"users"`
+	Page
+}
+
+type ComputerPage struct {
+	Computers []Computer `json:"computers"`
+	Page
+}
+
+// SearchResults — результат Search по группам; в Licenses после найденных — связанные с найденными пользователями и ПК.
+type SearchResults struct {
+	Query     string         `json:"query"`
+	Users     []UserFull     `json:"users"`
+	Computers []ComputerFull `json:"computers"`
+	Licenses  []License      `json:"licenses"`
+	Meetings  []Meeting      `json:"meetings"`
+}
+
+// LicensePatch — изменяемые поля лицензии; nil — поле не меняется.
+type LicensePatch struct {
+	Comment    *string `json:"comment,omitempty"`
+	PC         *string `json:"pc,omitempty"` // "" — отвязать ПК
+	ComputerID *int    `json:"computer_id,omitempty"`
+}
+
+type UpdateLicenseRequest struct {
+	LicenseID  int    `json:"license_id"`
+	Comment    string `json:"comment"`
+	PC         string `json:"pc"`
+	ComputerID int    `json:"computer_id,omitempty"`
+}
+
+type LicenseEvent struct {
+	ID          int    `json:"id"`
+	LicenseID   int    `json:"license_id"`
+	Action      string `json:"action"`
+	Actor       string `json:"actor"`
+	OldUserID   int    `json:"old_user_id"`
+	OldUserName string `json:"old_user_name"`
+	NewUserID   int    `json:"new_user_id"`
+	NewUserName string `json:"new_user_name"`
+	OldPC       string `json:"old_pc"`
+	NewPC       string `json:"new_pc"`
+	OldComment  string `json:"old_comment"`
+	NewComment  string `json:"new_comment"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// ImportResult — итог импорта; Warnings — пропущенные строки (дубликаты и т.п.).
+type ImportResult struct {
+	Imported int
+	Warnings []string
+}
+
+type ReclaimCandidate struct {
+	LicenseID     int    `json:"license_id"`
+	Key           string `json:"key"`
+	PC            string `json:"pc"`
+	Comment       string `json:"comment"`
+	UserID        int    `json:"user_id"`
+	UserName      string `json:"user_name"`
+	UserEmail     string `json:"user_email"`
+	UserLogin     string `json:"user_login"`
+	InactiveSince string `json:"inactive_since"`
+	InactiveDays  int    `json:"inactive_days"`
+	FlaggedAt     string `json:"flagged_at,omitempty"`
+}
+
+type ReclaimReport struct {
+	Policy    string             `json:"policy"`
+	AfterDays int                `json:"after_days"`
+	Items     []ReclaimCandidate `json:"items"`
+}
+
+type ReclaimResult struct {
+	Policy     string `json:"policy"`
+	AfterDays  int    `json:"after_days"`
+	Candidates int    `json:"candidates"`
+	Unassigned int    `json:"unassigned"`
+	Flagged    int    `json:"flagged"`
+}
+
+type ExpiringLicense struct {
+	License
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+	DaysLeft  int    `json:"days_left"`
+	Window    int    `json:"window"`
+}
+
+type ExpiringLicenses struct {
+	Days  int               `json:"days"`
+	Items []ExpiringLicense `json:"items"`
+}
+
+type ExpiryNotifyResult struct {
+	Notified     int  `json:"notified"`
+	DigestSent   bool `json:"digest_sent"`
+	UserMails    int  `json:"user_mails"`
+	UserFailures int  `json:"user_failures"`
+}
+
+type LicensePCIssue struct {
+	LicenseID    int    `json:"license_id"`
+	Key          string `json:"key"`
+	PC           string `json:"pc"`
+	ComputerID   int    `json:"computer_id"`
+	ComputerName string `json:"computer_name"`
+	Status       string `json:"status"` // missing | inactive
+}
+
+type LicensePCIssues struct {
+	Missing  int              `json:"missing"`
+	Inactive int              `json:"inactive"`
+	Items    []LicensePCIssue `json:"items"`
+}
+
+type Meeting struct {
+	ID           string `json:"id"`
+	Subject      string `json:"subject"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	Location     string `json:"location"`
+	IsRecurring  bool   `json:"is_recurring"`
+	IsCanceled   bool   `json:"is_canceled"`
+	Link         string `json:"link"`
+	Participants string `json:"participants"`
+}
+
+type MeetingsState struct {
+	ExportedAt string    `json:"exported_at"`
+	Items      []Meeting `json:"items"`
+}
+
+type Me struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type Session struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+}
+
+type LDAPSyncKindResult struct {
+	Synced      int    `json:"synced"`
+	Deactivated int    `json:"deactivated"`
+	Error       string `json:"error,omitempty"`
+}
+
+type LDAPSyncStatus struct {
+	Running       bool                `json:"running"`
+	Trigger       string              `json:"trigger"`
+	StartedAt     string              `json:"started_at"`
+	FinishedAt    string              `json:"finished_at"`
+	DurationMs    int64               `json:"duration_ms"`
+	Users         *LDAPSyncKindResult `json:"users,omitempty"`
+	Computers     *LDAPSyncKindResult `json:"computers,omitempty"`
+	LastError     string              `json:"last_error,omitempty"`
+	LastSuccessAt string              `json:"last_success_at,omitempty"`
+}
+
+type LDAPBlockedSync struct {
+	RunID           int      `json:"run_id"`
+	Kind            string   `json:"kind"`
+	Mode            string   `json:"mode"`
+	ActiveBefore    int      `json:"active_before"`
+	WouldDeactivate int      `json:"would_deactivate"`
+	Limit           string   `json:"limit"`
+	Sample          []string `json:"sample"`
+	BlockedAt       string   `json:"blocked_at"`
+}
+
+type LDAPSyncConfirmResult struct {
+	Status string `json:"status"`
+	Kind   string `json:"kind"`
+	RunID  int    `json:"run_id"`
+}
+
+type SyncRun struct {
+	ID          int      `json:"id"`
+	Kind        string   `json:"kind"`
+	Trigger     string   `json:"trigger"`
+	Mode        string   `json:"mode"`
+	Status      string   `json:"status"`
+	Forced      bool     `json:"forced"`
+	Synced      int      `json:"synced"`
+	Activated   []string `json:"activated"`
+	Deactivated []string `json:"deactivated"`
+	Error       string   `json:"error,omitempty"`
+	StartedAt   string   `json:"started_at"`
+	FinishedAt  string   `json:"finished_at"`
+	DurationMs  int64    `json:"duration_ms"`
+}
+
+// SyncRunsFilter — фильтры SyncRuns (нулевые значения не передаются; Limit по умолчанию 50).
+type SyncRunsFilter struct {
+	Kind     string
+	Status   string
+	Identity string
+	Limit    int
+	Offset   int
+}
+
+type SyncRuns struct {
+	Runs   []SyncRun `json:"runs"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+}
+
+// AuditEntry — запись журнала аудита; Before/After — JSON состояния сущности или итога операции.
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Actor     string          `json:"actor"`
+	Role      string          `json:"role"`
+	Action    string          `json:"action"` // operationId; login | loginFailed | logout
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	RequestID string          `json:"request_id"`
+	IP        string          `json:"ip"`
+}
+
+// AuditFilter — фильтры ListAudit и ExportAudit (пустые поля не передаются).
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID string
+	From     string // YYYY-MM-DD или RFC3339
+	To       string // YYYY-MM-DD (включительно) или RFC3339
+	Failed   *bool  // true — только отклонённые и ошибочные запросы
+}
+
+type AuditPage struct {
+	Items []AuditEntry `json:"items"`
+	Page
+}
+
+// LoginLockout — счётчик неудачных входов; LockedUntil не пуст — вход заблокирован.
+type LoginLockout struct {
+	Kind          string `json:"kind"` // username | ip
+	Value         string `json:"value"`
+	Failures      int    `json:"failures"`
+	LastFailureAt string `json:"last_failure_at"`
+	NextAttemptAt string `json:"next_attempt_at"`
+	LockedUntil   string `json:"locked_until"`
+}
+
+// APIToken — API-токен без секрета; RevokedAt не пуст — отозван.
+type APIToken struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	CreatedBy  string   `json:"created_by"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"`
+	RevokedAt  string   `json:"revoked_at"`
+}
+
+// CreateAPITokenRequest — новый токен. Scopes: read, licenses:write, users:write, meetings:import, admin.
+type CreateAPITokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at,omitempty"` // YYYY-MM-DD или RFC3339; пусто — бессрочный
+}
+
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type BackupInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"created_at"`
+}
+
+type RestoreResult struct {
+	Status        string `json:"status"`
+	SchemaVersion int    `json:"schema_version"`
+	PreRestore    string `json:"pre_restore_backup"`
+}
@@ -19,49 +19,119 @@ const importState = {
     licensesForImport: [] // { key }
 };
 
-// ---------- утилиты чтения файлов ----------
-
-function parseCSV(text) {
-    const rows = [];
-    const lines = text.split(/\r?\n/).filter((line) => line.trim() !== "");
-    for (const line of lines) {
-        const parts = line.includes(";") ? line.split(";") : line.split(",");
-        rows.push(parts.map((x) => x.trim()));
-    }
-    return rows;
+// ---------- импорт файла (разбор на сервере) ----------
+
+const ROW_STATUS = {
+    ok: { text: "добавится", cls: "text-bg-success" },
+    duplicate: { text: "дубликат", cls: "text-bg-secondary" },
+    invalid: { text: "ошибка", cls: "text-bg-danger" }
+};
+
+function fileImportForm() {
+    const input = document.getElementById("import-file");
+    const file = input && input.files && input.files[0];
+    if (!file) return null;
+
+    const form = new FormData();
+    form.append("file", file);
+    form.append("header", document.getElementById("import-header").checked ? "true" : "false");
+    document.querySelectorAll("#import-columns input[data-field]").forEach((inp) => {
+        const v = inp.value.trim();
+        if (v) form.append("column_" + inp.dataset.field, v);
+    });
+    return form;
 }
 
-/** Берём "первый непустой столбец" каждой строки и возвращаем массив строк. */
-function extractFirstColumnValues(rows) {
-    const result = [];
-    for (const row of rows) {
-        if (!row || !row.length) continue;
-        let value = "";
-        for (let i = 0; i < row.length; i++) {
-            const cell = String(row[i] ?? "").trim();
-            if (cell !== "") {
-                value = cell;
-                break;
-            }
+function renderFileRows(rows, dryRun) {
+    const table = document.getElementById("file-rows-table");
+    if (!table) return;
+    table.innerHTML = "";
+    if (!rows || !rows.length) return;
+
+    const thead = document.createElement("thead");
+    const trHead = document.createElement("tr");
+    ["Строка", "Ключ", "Итог", "Пояснение"].forEach((t) => {
+        const th = document.createElement("th");
+        th.textContent = t;
+        trHead.appendChild(th);
+    });
+    thead.appendChild(trHead);
+    table.appendChild(thead);
+
+    const tbody = document.createElement("tbody");
+    rows.forEach((r) => {
+        const tr = document.createElement("tr");
+
+        const tdRow = document.createElement("td");
+        tdRow.textContent = r.row;
+        tr.appendChild(tdRow);
+
+        const tdKey = document.createElement("td");
+        tdKey.className = "font-monospace";
+        tdKey.textContent = r.key;
+        tr.appendChild(tdKey);
+
+        const st = ROW_STATUS[r.status] || { text: r.status, cls: "text-bg-light" };
+        const tdStatus = document.createElement("td");
+        const badge = document.createElement("span");
+        badge.className = "badge " + st.cls;
+        badge.textContent = r.status === "ok" && !dryRun ? "добавлена" : st.text;
+        tdStatus.appendChild(badge);
+        tr.appendChild(tdStatus);
+
+        const tdNote = document.createElement("td");
+        tdNote.className = "small";
+        tdNote.textContent = r.error || (r.user_id ? "назначается пользователю" : "");
+        tr.appendChild(tdNote);
+
+        tbody.appendChild(tr);
+    });
+    table.appendChild(tbody);
+}
+
+async function sendFile(dryRun) {
+    const form = fileImportForm();
+    const importBtn = document.getElementById("file-import-btn");
+    const summary = document.getElementById("file-summary");
+    if (!form) {
+        showMessage("Выберите файл.", true);
+        return;
+    }
+
+    showMessage(dryRun ? "Проверяем файл…" : "Импортируем…", false);
+    try {
+        const res = await fetch("/api/licenses/import" + (dryRun ? "?dry_run=true" : ""), {
+            method: "POST",
+            body: form
+        });
+        const data = await res.json().catch(() => ({}));
+        if (!res.ok) {
+            throw new Error(data.error || "Ошибка импорта файла");
         }
-        if (value) result.push(value);
+
+        const rows = data.rows || [];
+        const skipped = rows.length - data.licenses_imported;
+        renderFileRows(rows, dryRun);
+        summary.textContent = `Строк: ${rows.length}, ${dryRun ? "будет добавлено" : "добавлено"}: ${data.licenses_imported}, пропущено: ${skipped}`;
+        if (dryRun) {
+            importBtn.disabled = data.licenses_imported === 0;
+            showMessage("Проверка завершена — в базу ничего не записано.", false);
+        } else {
+            importBtn.disabled = true;
+            showMessage(`Импорт завершён. Добавлено ключей: ${data.licenses_imported}.`, false);
+        }
+    } catch (e) {
+        console.error(e);
+        importBtn.disabled = true;
+        showMessage("Ошибка: " + e.message, true);
     }
-    return result;
 }
 
-async function readFileAsRows(file) {
-    const ext = file.name.split(".").pop().toLowerCase();
-
-    if (ext === "csv" || ext === "txt") {
-        const text = await file.text();
-        return parseCSV(text);
-    } else {
-        const data = await file.arrayBuffer();
-        const workbook = XLSX.read(data, { type: "array" });
-        const sheetName = workbook.SheetNames[0];
-        const sheet = workbook.Sheets[sheetName];
-        return XLSX.utils.sheet_to_json(sheet, { header: 1, raw: false });
-    }
+// после изменения файла или колонок результат проверки устарел
+function resetFileCheck() {
+    document.getElementById("file-import-btn").disabled = true;
+    document.getElementById("file-summary").textContent = "";
+    renderFileRows([]);
 }
 
 // ---------- ключи ----------
@@ -126,31 +196,6 @@ function clearLicenses() {
     renderLicensesTable();
 }
 
-async function loadLicensesFromFile(file) {
-    try {
-        const rows = await readFileAsRows(file);
-        const values = extractFirstColumnValues(rows);
-        let addedCount = 0;
-
-        values.forEach((v) => {
-            if (!v) return;
-            const exists = importState.licensesForImport.some(
-                (l) => (l.key || "").trim().toLowerCase() === v.toLowerCase()
-            );
-            if (!exists) {
-                importState.licensesForImport.push({ key: v });
-                addedCount++;
-            }
-        });
-
-        renderLicensesTable();
-        showMessage(`Из файла добавлено ключей: ${addedCount}`, false);
-    } catch (e) {
-        console.error(e);
-        showMessage("Ошибка чтения файла ключей: " + e.message, true);
-    }
-}
-
 // ---------- сохранение в бэкенд ----------
 
 async function saveToServer() {
@@ -196,18 +241,17 @@ async function saveToServer() {
 document.addEventListener("DOMContentLoaded", () => {
     const addLicenseBtn = document.getElementById("add-license-row-btn");
     const clearLicensesBtn = document.getElementById("clear-licenses-btn");
-    const licensesFileInput = document.getElementById("licenses-file-input");
     const saveBtn = document.getElementById("save-btn");
+    const fileCheckBtn = document.getElementById("file-check-btn");
+    const fileImportBtn = document.getElementById("file-import-btn");
 
     if (addLicenseBtn) addLicenseBtn.addEventListener("click", addLicenseRow);
     if (clearLicensesBtn) clearLicensesBtn.addEventListener("click", clearLicenses);
-    if (licensesFileInput) {
-        licensesFileInput.addEventListener("change", (e) => {
-            const file = e.target.files && e.target.files[0];
-            if (file) loadLicensesFromFile(file);
-            e.target.value = "";
-        });
-    }
+    if (fileCheckBtn) fileCheckBtn.addEventListener("click", () => sendFile(true));
+    if (fileImportBtn) fileImportBtn.addEventListener("click", () => sendFile(false));
+    document.querySelectorAll("#import-file, #import-header, #import-columns input").forEach((el) => {
+        el.addEventListener("change", resetFileCheck);
+    });
 
     if (saveBtn) saveBtn.addEventListener("click", saveToServer);
 
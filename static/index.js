@@ -22,14 +22,19 @@ function qs(id) {
 
 // --------- состояние ---------
 
+const PAGE_SIZE = 50;
+
+// Фильтрация, сортировка и постраничная выдача — на сервере (/api/licenses); в памяти только текущая страница.
 const listState = {
-    users: [],
     licenses: [],
-    filteredLicenses: [],
+    total: 0, // найдено под фильтром
+    stats: { total: 0, used: 0 }, // по всем лицензиям
     filter: {
         search: "",
-        user: "all" // 'all' | 'unassigned' | userId
+        user: "all" // 'all' | 'unassigned' | 'assigned'
     },
+    sort: { field: "id", desc: false },
+    offset: 0,
     isLoading: false
 };
 
@@ -44,23 +49,52 @@ function setLoading(flag) {
 
 // --------- загрузка состояния с сервера ---------
 
+async function fetchJSON(url) {
+    const res = await fetch(url, { cache: "no-store" });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) throw new Error(data.error || "HTTP " + res.status);
+    return data;
+}
+
+function licensesURL() {
+    const params = new URLSearchParams({
+        sort: listState.sort.field,
+        order: listState.sort.desc ? "desc" : "asc",
+        limit: String(PAGE_SIZE),
+        offset: String(listState.offset)
+    });
+    const search = listState.filter.search.trim();
+    if (search) params.set("q", search);
+    if (listState.filter.user === "unassigned") params.set("assigned", "false");
+    if (listState.filter.user === "assigned") params.set("assigned", "true");
+    return "/api/licenses?" + params.toString();
+}
+
 async function loadState() {
     setLoading(true);
     showMessage("");
 
     try {
-        const res = await fetch("/api/state", { cache: "no-store" });
-        if (!res.ok) throw new Error("HTTP " + res.status);
-        const data = await res.json();
-
-        listState.users = data.users || [];
-        listState.licenses = data.licenses || [];
+        // limit=1 — нужны только total для статистики
+        const [page, all, used] = await Promise.all([
+            fetchJSON(licensesURL()),
+            fetchJSON("/api/licenses?limit=1"),
+            fetchJSON("/api/licenses?assigned=true&limit=1")
+        ]);
+
+        listState.licenses = page.licenses || [];
+        listState.total = page.total || 0;
+        listState.stats = { total: all.total || 0, used: used.total || 0 };
+
+        // страница могла опустеть (удалили/отфильтровали) — возвращаемся на последнюю непустую
+        if (!listState.licenses.length && listState.offset > 0 && listState.total > 0) {
+            listState.offset = Math.max(0, Math.floor((listState.total - 1) / PAGE_SIZE) * PAGE_SIZE);
+            return loadState();
+        }
 
-        rebuildUserFilter();
-        rebuildUsersDatalist();
-        applyFilters();
         renderLicensesTable();
         updateStats();
+        updatePager();
     } catch (e) {
         console.error(e);
         showMessage("Ошибка загрузки данных: " + e.message, true);
@@ -69,6 +103,12 @@ async function loadState() {
     }
 }
 
+// перезагрузка с первой страницы (после смены фильтра или сортировки)
+function reloadFromStart() {
+    listState.offset = 0;
+    loadState();
+}
+
 // --------- хелперы по пользователям ---------
 
 function getUserName(u) {
@@ -78,111 +118,75 @@ function getUserName(u) {
     return "user #" + u.id;
 }
 
-function findUserById(id) {
-    return listState.users.find((u) => u.id === id) || null;
+// активные пользователи по подстроке (для подсказок и поиска при назначении)
+async function searchActiveUsers(query, limit) {
+    const params = new URLSearchParams({
+        active: "true",
+        sort: "name",
+        limit: String(limit)
+    });
+    if (query) params.set("q", query);
+    const data = await fetchJSON("/api/users/all?" + params.toString());
+    return data.users || [];
 }
 
-function findUserByNameCaseInsensitive(name) {
+async function findUserByNameCaseInsensitive(name) {
     const target = (name || "").trim().toLowerCase();
     if (!target) return null;
+    const users = await searchActiveUsers(name.trim(), 50);
     return (
-        listState.users.find(
-            (u) => getUserName(u).trim().toLowerCase() === target
-        ) || null
+        users.find((u) => getUserName(u).trim().toLowerCase() === target) ||
+        null
     );
 }
 
-
-function isActiveUser(u) {
-    // Если бэкенд отдаёт поле active (bool/0/1) — скрываем неактивных.
-    if (!u) return false;
-    if (Object.prototype.hasOwnProperty.call(u, "active")) {
-        return !!u.active;
-    }
-    return true;
-}
-
-function rebuildUsersDatalist() {
-    const dl = qs("users-datalist");
-    if (!dl) return;
-    dl.innerHTML = "";
-    listState.users.filter(isActiveUser).forEach((u) => {
-        const opt = document.createElement("option");
-        opt.value = getUserName(u);
-        dl.appendChild(opt);
-    });
-}
-
-function rebuildUserFilter() {
-    const sel = qs("filter-user");
-    if (!sel) return;
-
-    const current = sel.value || "all";
-
-    sel.innerHTML = "";
-    const optAll = document.createElement("option");
-    optAll.value = "all";
-    optAll.textContent = "Все";
-    sel.appendChild(optAll);
-
-    const optFree = document.createElement("option");
-    optFree.value = "unassigned";
-    optFree.textContent = "Только свободные";
-    sel.appendChild(optFree);
-
-    listState.users.filter(isActiveUser).forEach((u) => {
-        const opt = document.createElement("option");
-        opt.value = String(u.id);
-        opt.textContent = getUserName(u);
-        sel.appendChild(opt);
-    });
-
-    sel.value = current;
-    if (!sel.value) sel.value = "all";
-    listState.filter.user = sel.value;
-}
-
-// --------- фильтрация ---------
-
-function applyFilters() {
-    const search = (listState.filter.search || "").trim().toLowerCase();
-    const userFilter = listState.filter.user;
-
-    listState.filteredLicenses = listState.licenses.filter((lic) => {
-        // фильтр по пользователю
-        if (userFilter === "unassigned") {
-            if (lic.assigned_user_id && lic.assigned_user_id !== 0) {
-                return false;
-            }
-        } else if (userFilter !== "all") {
-            const userId = Number(userFilter);
-            if (lic.assigned_user_id !== userId) return false;
-        }
-
-        // фильтр по поиску
-        if (search) {
-            const key = (lic.key || "").toLowerCase();
-            const comment = (lic.comment || "").toLowerCase();
-            if (!key.includes(search) && !comment.includes(search)) {
-                return false;
-            }
+let datalistTimer = null;
+
+// подсказки в поле «Пользователь»: список пользователей не грузим целиком, а ищем по вводу
+function scheduleUsersDatalist(query) {
+    clearTimeout(datalistTimer);
+    datalistTimer = setTimeout(async () => {
+        const dl = qs("users-datalist");
+        if (!dl) return;
+        try {
+            const users = await searchActiveUsers((query || "").trim(), 20);
+            dl.innerHTML = "";
+            users.forEach((u) => {
+                const opt = document.createElement("option");
+                opt.value = getUserName(u);
+                dl.appendChild(opt);
+            });
+        } catch (e) {
+            console.error(e);
         }
-
-        return true;
-    });
+    }, 250);
 }
 
+// --------- статистика и страницы ---------
+
 function updateStats() {
     const el = qs("stats");
     if (!el) return;
 
-    const total = listState.licenses.length;
-    const used = listState.licenses.filter(
-        (l) => l.assigned_user_id && l.assigned_user_id !== 0
-    ).length;
+    const { total, used } = listState.stats;
     const free = total - used;
+    const from = listState.licenses.length ? listState.offset + 1 : 0;
+    const to = listState.offset + listState.licenses.length;
 
-    el.textContent = `Всего лицензий: ${total}. Занято: ${used}. Свободно: ${free}. Показано: ${listState.filteredLicenses.length}.`;
+    el.textContent = `Всего лицензий: ${total}. Занято: ${used}. Свободно: ${free}. Найдено: ${listState.total}. Показано: ${from}–${to}.`;
+}
+
+function updatePager() {
+    const prev = qs("page-prev");
+    const next = qs("page-next");
+    const info = qs("page-info");
+
+    const pages = Math.max(1, Math.ceil(listState.total / PAGE_SIZE));
+    const current = Math.floor(listState.offset / PAGE_SIZE) + 1;
+
+    if (prev) prev.disabled = listState.offset === 0;
+    if (next) next.disabled = listState.offset + PAGE_SIZE >= listState.total;
+    if (info) info.textContent = `Страница ${current} из ${pages}`;
 }
 
 // --------- отрисовка таблицы ---------
@@ -195,9 +199,24 @@ function renderLicensesTable() {
 
     const thead = document.createElement("thead");
     const trHead = document.createElement("tr");
-    ["Ключ", "Пользователь", "Комментарий", "ПК", "Действия"].forEach((text) => {
+    [
+        ["Ключ", "key"],
+        ["Пользователь", "user"],
+        ["Комментарий", "comment"],
+        ["ПК", "pc"],
+        ["Действия", ""]
+    ].forEach(([text, field]) => {
         const th = document.createElement("th");
         th.textContent = text;
+        if (field) {
+            // клик по заголовку — сортировка по колонке, повторный клик — в обратную сторону
+            th.dataset.sort = field;
+            th.setAttribute("role", "button");
+            th.style.cursor = "pointer";
+            if (listState.sort.field === field) {
+                th.textContent += listState.sort.desc ? " ▼" : " ▲";
+            }
+        }
         trHead.appendChild(th);
     });
     thead.appendChild(trHead);
@@ -205,7 +224,7 @@ function renderLicensesTable() {
 
     const tbody = document.createElement("tbody");
 
-    if (!listState.filteredLicenses.length) {
+    if (!listState.licenses.length) {
         const trEmpty = document.createElement("tr");
         const td = document.createElement("td");
         td.colSpan = 5;
@@ -216,7 +235,7 @@ function renderLicensesTable() {
         return;
     }
 
-    listState.filteredLicenses.forEach((lic) => {
+    listState.licenses.forEach((lic) => {
         const tr = document.createElement("tr");
         tr.dataset.licenseId = String(lic.id);
 
@@ -230,8 +249,7 @@ function renderLicensesTable() {
         const userInput = document.createElement("input");
         userInput.type = "text";
         userInput.className = "form-control form-control-sm user-input";
-        const assignedUser = findUserById(lic.assigned_user_id);
-        userInput.value = assignedUser ? getUserName(assignedUser) : "";
+        userInput.value = lic.assigned_user_name || "";
         userInput.setAttribute("list", "users-datalist");
         userInput.placeholder = "Выберите или введите пользователя";
         tdUser.appendChild(userInput);
@@ -319,14 +337,8 @@ async function assignLicense(licenseId, userName) {
         return;
     }
 
-    // Пользователи приходят из LDAP → локально "добавлять" их нельзя.
-    // Если пользователя нет в текущем списке — пробуем один раз перезагрузить состояние.
-    let user = findUserByNameCaseInsensitive(cleaned);
-
-    if (!user) {
-        await loadState(); // обновим список пользователей из бэкенда (вдруг LDAP уже синкнулся)
-        user = findUserByNameCaseInsensitive(cleaned);
-    }
+    // Пользователи приходят из LDAP → локально "добавлять" их нельзя, ищем среди активных на сервере.
+    const user = await findUserByNameCaseInsensitive(cleaned);
 
     if (!user) {
         throw new Error(
@@ -395,22 +407,29 @@ function initFilters() {
     const searchInput = qs("filter-search");
     const userSelect = qs("filter-user");
     const reloadBtn = qs("reload-btn");
+    const prevBtn = qs("page-prev");
+    const nextBtn = qs("page-next");
 
     if (searchInput) {
+        // ?q= — переход со страницы поиска
+        const initial = new URLSearchParams(window.location.search).get("q");
+        if (initial) {
+            searchInput.value = initial;
+            listState.filter.search = initial;
+        }
+
+        let timer = null;
         searchInput.addEventListener("input", () => {
             listState.filter.search = searchInput.value;
-            applyFilters();
-            renderLicensesTable();
-            updateStats();
+            clearTimeout(timer);
+            timer = setTimeout(reloadFromStart, 300);
         });
     }
 
     if (userSelect) {
         userSelect.addEventListener("change", () => {
             listState.filter.user = userSelect.value;
-            applyFilters();
-            renderLicensesTable();
-            updateStats();
+            reloadFromStart();
         });
     }
 
@@ -419,16 +438,55 @@ function initFilters() {
             loadState();
         });
     }
+
+    if (prevBtn) {
+        prevBtn.addEventListener("click", () => {
+            listState.offset = Math.max(0, listState.offset - PAGE_SIZE);
+            loadState();
+        });
+    }
+
+    if (nextBtn) {
+        nextBtn.addEventListener("click", () => {
+            listState.offset += PAGE_SIZE;
+            loadState();
+        });
+    }
 }
 
 function initTableEvents() {
     const table = qs("licenses-table");
     if (!table) return;
 
+    table.addEventListener("input", (e) => {
+        const target = e.target;
+        if (target instanceof HTMLInputElement && target.classList.contains("user-input")) {
+            scheduleUsersDatalist(target.value);
+        }
+    });
+
+    table.addEventListener("focusin", (e) => {
+        const target = e.target;
+        if (target instanceof HTMLInputElement && target.classList.contains("user-input")) {
+            scheduleUsersDatalist(target.value);
+        }
+    });
+
     table.addEventListener("click", (e) => {
         const target = e.target;
         if (!(target instanceof HTMLElement)) return;
 
+        if (target.dataset.sort) {
+            const field = target.dataset.sort;
+            if (listState.sort.field === field) {
+                listState.sort.desc = !listState.sort.desc;
+            } else {
+                listState.sort = { field, desc: false };
+            }
+            reloadFromStart();
+            return;
+        }
+
         if (target.classList.contains("save-row-btn")) {
             const licenseId = Number(target.dataset.licenseId);
             const tr = target.closest("tr");
@@ -3,6 +3,7 @@
 // - Подключает "шаблон" (Bootswatch темы + Bootstrap JS + Bootstrap Icons) централизованно
 // - Переключение темы (Zephyr/Darkly) одной кнопкой в header
 // - Подсвечивает активный пункт меню
+// - Добавляет CSRF-токен сессии (cookie cp_csrf) в изменяющие запросы fetch к своему серверу
 
 const UI = {
   storageKey: "ui-theme-mode",
@@ -33,6 +34,35 @@ const UI = {
 
 const THEME_LINK_ID = "app-theme-css";
 
+const CSRF = { cookie: "cp_csrf", header: "X-CSRF-Token" };
+
+function readCookie(name) {
+  const prefix = name + "=";
+  for (const part of document.cookie.split(";")) {
+    const c = part.trim();
+    if (c.startsWith(prefix)) return decodeURIComponent(c.slice(prefix.length));
+  }
+  return "";
+}
+
+// Сервер принимает POST/PUT/DELETE по cookie сессии только с заголовком X-CSRF-Token.
+// Оборачиваем fetch один раз: страницам не нужно помнить об этом в каждом запросе.
+function installCsrfFetch() {
+  const origFetch = window.fetch.bind(window);
+  window.fetch = (input, init) => {
+    const req = input instanceof Request ? input : null;
+    const method = String((init && init.method) || (req && req.method) || "GET").toUpperCase();
+    const url = new URL(req ? req.url : String(input), window.location.href);
+    const token = readCookie(CSRF.cookie);
+    if (method === "GET" || method === "HEAD" || url.origin !== window.location.origin || !token) {
+      return origFetch(input, init);
+    }
+    const headers = new Headers((init && init.headers) || (req && req.headers) || undefined);
+    if (!headers.has(CSRF.header)) headers.set(CSRF.header, token);
+    return origFetch(input, { ...(init || {}), headers });
+  };
+}
+
 function getSavedMode() {
   const m = localStorage.getItem(UI.storageKey);
   return m === "dark" || m === "light" ? m : "light";
@@ -162,6 +192,8 @@ function wireThemeToggle() {
   updateThemeToggleUI(getSavedMode());
 }
 
+installCsrfFetch();
+
 // Применяем тему как можно раньше (скрипт обычно подключен в <head defer>)
 ensureThemeCss(getSavedMode());
 ensureBootstrapJs();
@@ -0,0 +1,138 @@
+// search.js — общий поиск (/api/search): пользователи, ПК, лицензии, встречи
+
+function qs(id) {
+    return document.getElementById(id);
+}
+
+function htmlEscape(str) {
+    if (str == null) return "";
+    return String(str)
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/\"/g, "&quot;")
+        .replace(/'/g, "&#39;");
+}
+
+function showError(msg) {
+    const el = qs("globalError");
+    if (!el) return;
+    if (!msg) {
+        el.classList.add("d-none");
+        el.textContent = "";
+        return;
+    }
+    el.textContent = msg;
+    el.classList.remove("d-none");
+}
+
+// ссылка на список лицензий с тем же поиском
+function licensesLink(q, text) {
+    return `<a href="/?q=${encodeURIComponent(q)}">${htmlEscape(text)}</a>`;
+}
+
+function inactiveBadge(active) {
+    return active ? "" : ' <span class="badge text-bg-secondary">неактивен</span>';
+}
+
+// ---- группы результатов ----
+
+function renderGroup(title, icon, items, header, row) {
+    if (!items || items.length === 0) return "";
+    return `
+        <div class="card">
+            <div class="card-header d-flex justify-content-between align-items-center">
+                <span><i class="bi ${icon} me-2"></i>${title}</span>
+                <span class="badge text-bg-light">${items.length}</span>
+            </div>
+            <div class="table-responsive">
+                <table class="table table-sm table-hover mb-0 align-middle">
+                    <thead><tr>${header.map((h) => `<th>${h}</th>`).join("")}</tr></thead>
+                    <tbody>${items.map(row).join("")}</tbody>
+                </table>
+            </div>
+        </div>`;
+}
+
+function renderResults(res) {
+    const html = [
+        renderGroup("Пользователи", "bi-person", res.users, ["Имя", "Email", "Логин"], (u) => `
+            <tr>
+                <td>${licensesLink(u.name, u.name)}${inactiveBadge(u.active)}</td>
+                <td>${htmlEscape(u.email)}</td>
+                <td>${htmlEscape(u.login)}</td>
+            </tr>`),
+        renderGroup("Компьютеры", "bi-pc-display", res.computers, ["Имя", "DNS-имя", "Описание"], (c) => `
+            <tr>
+                <td>${licensesLink(c.name, c.name)}${inactiveBadge(c.active)}</td>
+                <td>${htmlEscape(c.dns_host_name)}</td>
+                <td>${htmlEscape(c.description)}</td>
+            </tr>`),
+        renderGroup("Лицензии", "bi-key", res.licenses, ["Ключ", "Пользователь", "ПК", "Комментарий"], (l) => `
+            <tr>
+                <td class="font-monospace">${licensesLink(l.key, l.key)}</td>
+                <td>${htmlEscape(l.assigned_user_name || "—")}</td>
+                <td>${htmlEscape(l.pc)}</td>
+                <td>${htmlEscape(l.comment)}</td>
+            </tr>`),
+        renderGroup("Встречи", "bi-calendar3", res.meetings, ["Тема", "Начало", "Место", "Участники"], (m) => `
+            <tr>
+                <td><a href="/meetings.html">${htmlEscape(m.subject)}</a>${m.is_canceled ? ' <span class="badge text-bg-danger">отменена</span>' : ""}</td>
+                <td class="text-nowrap">${htmlEscape(m.start)}</td>
+                <td>${htmlEscape(m.location)}</td>
+                <td class="small">${htmlEscape(m.participants)}</td>
+            </tr>`)
+    ].join("");
+
+    qs("results").innerHTML = html;
+    qs("emptyState").classList.toggle("d-none", html !== "");
+}
+
+// ---- загрузка ----
+
+async function runSearch(q) {
+    showError("");
+    qs("emptyState").classList.add("d-none");
+    qs("results").innerHTML = "";
+    if (!q.trim()) return;
+
+    let resp;
+    try {
+        resp = await fetch("/api/search?" + new URLSearchParams({ q }), { cache: "no-store" });
+    } catch (e) {
+        showError("Не удалось обратиться к /api/search: " + e);
+        return;
+    }
+
+    if (!resp.ok) {
+        let msg = "Ошибка HTTP " + resp.status;
+        try {
+            const errBody = await resp.json();
+            if (errBody && errBody.error) msg += ": " + errBody.error;
+        } catch (_) {}
+        showError(msg);
+        return;
+    }
+
+    renderResults(await resp.json());
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+    const form = qs("search-form");
+    const input = qs("search-q");
+    if (!form || !input) return;
+
+    // запрос держим в адресе, чтобы результатами можно было поделиться и вернуться к ним
+    form.addEventListener("submit", (e) => {
+        e.preventDefault();
+        const q = input.value.trim();
+        history.replaceState(null, "", q ? "?q=" + encodeURIComponent(q) : window.location.pathname);
+        runSearch(q);
+    });
+
+    const initial = new URLSearchParams(window.location.search).get("q");
+    if (initial) {
+        input.value = initial;
+        runSearch(initial);
+    }
+});